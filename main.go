@@ -1,39 +1,80 @@
 package main
 
 import (
+	"compress/flate"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/eplightning/gelf-forwarder/pkg/input"
 	"github.com/eplightning/gelf-forwarder/pkg/output"
+	"github.com/eplightning/gelf-forwarder/pkg/processor"
 	"github.com/eplightning/gelf-forwarder/pkg/util"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+// processorNames lists processors accepted by --processors
+var processorNames = []string{"redact", "sample", "dedup", "multiline", "geoip", "script", "kubernetes"}
+
 func main() {
 	setupConfig()
 	setupLogging()
+	validateProcessors()
+	setupKeySanitizer()
 
+	// components are stopped in order input, pipeline, output, so that messages held by processors
+	// are flushed while output is still running
 	stopCh := make(chan interface{})
+	pipeStopCh := make(chan interface{})
+	outStopCh := make(chan interface{})
+	outDoneCh := make(chan interface{})
 	msgCh := make(chan *gelf.Message, viper.GetUint("channel-buffer-size"))
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 4)
+	inWg := &sync.WaitGroup{}
+	pipeWg := &sync.WaitGroup{}
+	outWg := &sync.WaitGroup{}
 	wg := &sync.WaitGroup{}
 
 	out := setupOutput()
 	in := setupInput()
 
-	if err := util.RegisterComponent(in, wg, msgCh, stopCh, errCh); err != nil {
+	// with processors enabled, input and output are connected through the pipeline
+	outCh := msgCh
+	if processors := setupProcessors(); len(processors) > 0 {
+		outCh = make(chan *gelf.Message, viper.GetUint("channel-buffer-size"))
+
+		pipeOpts := processor.NewPipelineOptions()
+		pipeOpts.Processors = processors
+		pipeOpts.Output = outCh
+		pipeOpts.OutputDone = outDoneCh
+
+		if err := util.RegisterComponent(processor.NewPipeline(pipeOpts), pipeWg, msgCh, pipeStopCh, errCh); err != nil {
+			zap.S().Panic("Could not start processors", err)
+		}
+	}
+
+	if err := util.RegisterComponent(in, inWg, msgCh, stopCh, errCh); err != nil {
 		zap.S().Panic("Could not start input", err)
 	}
-	if err := util.RegisterComponent(out, wg, msgCh, stopCh, errCh); err != nil {
+	if err := util.RegisterComponent(out, outWg, outCh, outStopCh, errCh); err != nil {
 		zap.S().Panic("Could not start output", err)
 	}
+	// output failing before shutdown stops reading messages, pipeline drops them instead of blocking
+	go func() {
+		outWg.Wait()
+		close(outDoneCh)
+	}()
+	if addr := viper.GetString("metrics-address"); addr != "" {
+		if err := util.RegisterComponent(util.NewMetricsServer(addr), wg, msgCh, outStopCh, errCh); err != nil {
+			zap.S().Panic("Could not start metrics server", err)
+		}
+	}
 
 	zap.S().Info("All components ready and listening")
 
@@ -51,6 +92,11 @@ func main() {
 		close(stopCh)
 	}()
 
+	inWg.Wait()
+	close(pipeStopCh)
+	pipeWg.Wait()
+	close(outStopCh)
+	outWg.Wait()
 	wg.Wait()
 }
 
@@ -59,24 +105,143 @@ func setupConfig() {
 	pflag.Uint("graceful-timeout", 10, "How many seconds to wait for messages to be sent on shutdown")
 	pflag.Uint("channel-buffer-size", 100, "How many messages to hold in channel buffer")
 	pflag.Bool("backpressure", true, "Enable input backpressure")
+	pflag.String("metrics-address", "", "Listen address for metrics endpoint (/debug/vars), disabled if empty")
+
+	pflag.StringSlice("missing-host-policy", nil, "Policies tried in order when host field is missing or empty: static, remote-addr, tls-cn. Message is dropped if none succeeds")
+	pflag.String("default-host", "", "Host used by static missing host policy")
+	pflag.StringSlice("missing-message-policy", nil, "Policies tried in order when message field is missing or empty: template, event. Message is dropped if none succeeds")
+	pflag.String("message-template", "", "Go template used by template missing message policy, executed against the whole event")
+	pflag.StringSlice("full-message-field", nil, "Paths of fields used as full_message, dotted or JSON pointer. Multiple paths are tried in order")
+	pflag.Int("short-message-max-bytes", 0, "Maximum size of short_message, longer messages are truncated and moved to full_message. Disabled if 0")
+	pflag.String("short-message-truncate", "first-line", "How to truncate too long short_message: first-line or bytes")
+	pflag.String("flatten-separator", "_", "Separator used when flattening nested fields, characters other than underscore need to be allowed by --key-allowed-chars")
+	pflag.Int("flatten-max-depth", 0, "Maximum depth of flattened fields, deeper objects and arrays are kept as JSON strings. Unlimited if 0")
+	pflag.String("flatten-arrays", "index", "How to flatten arrays: index (field per item), json (JSON string) or join (items joined with --flatten-array-delimiter)")
+	pflag.String("flatten-array-delimiter", ",", "Delimiter used by join array flattening mode")
+	pflag.Int("max-extra-fields", 0, "Maximum number of additional fields extracted from each event, number of dropped fields is reported in _dropped_fields. Unlimited if 0")
+	pflag.String("bool-mode", "string", "How to emit boolean fields: string, int (1/0) or native")
+	pflag.String("null-mode", "string", "How to emit null fields: string or drop")
+	pflag.String("timestamp-format", "rfc3339", "Format of timestamps nested in additional fields: rfc3339, unix (seconds) or unix-ms")
+	pflag.StringToString("field-types", nil, "Force type of additional fields (string or number), keyed by flattened field name, for example status=number")
+	pflag.String("key-allowed-chars", "", "Characters allowed in additional field names on top of letters, digits and underscore, for example .-")
+	pflag.Int("key-max-length", 0, "Maximum length of additional field names, longer names are truncated. Unlimited if 0")
+	pflag.String("key-collision-strategy", "overwrite", "What to do when additional field with the same name already exists: overwrite, suffix or drop")
+	pflag.String("key-reserved-suffix", "_", "Suffix appended to additional fields clashing with reserved names such as _id")
+	pflag.StringToString("input-static-fields", nil, "Fields added to every message by input, environment variables in values are expanded, for example env=prod,instance=$HOSTNAME")
+	pflag.StringToString("input-template-fields", nil, "Fields added to every message by input, values are Go templates executed against the message, for example app={{.kubernetes_labels_app}}")
+	pflag.StringSlice("input-metadata", nil, "Request metadata added to every message: input, remote-addr, tls-subject, http-path, connection-id")
+	pflag.StringSlice("input-metadata-headers", nil, "HTTP headers added to every message as _http_header_<name> fields")
+	pflag.StringSlice("timestamp-layouts", nil, "Go layouts or strftime formats (if containing %) of string timestamps, tried in order. RFC3339 is used if empty")
+	pflag.String("timestamp-unit", "auto", "Unit of numeric timestamps: s, ms, us, ns or auto to detect based on magnitude")
+	pflag.String("timestamp-timezone", "UTC", "Timezone used for timestamp layouts without zone information")
+	pflag.Duration("timestamp-max-skew", 0, "Maximum allowed difference between message timestamp and current time, disabled if 0")
+	pflag.String("timestamp-skew-policy", "clamp", "What to do with timestamps exceeding max skew: clamp to allowed range or flag with _timestamp_skew field")
 
 	pflag.String("vector-address", ":9000", "Listen address for vector v1/v2 input")
-	pflag.String("vector-timestamp-field", "timestamp", "Name of timestamp field")
-	pflag.String("vector-message-field", "message", "Name of message field")
-	pflag.String("vector-host-field", "host", "Name of host field")
+	pflag.StringSlice("vector-timestamp-field", []string{"timestamp"}, "Path of timestamp field, dotted or JSON pointer. Multiple paths are tried in order")
+	pflag.StringSlice("vector-message-field", []string{"message"}, "Path of message field, dotted or JSON pointer. Multiple paths are tried in order")
+	pflag.StringSlice("vector-host-field", []string{"host"}, "Path of host field, dotted or JSON pointer. Multiple paths are tried in order")
 	pflag.Uint("vector-max-message-size", input.DefaultMaxMessageSize, "Maximum length of single Vector v1 message")
 
 	pflag.String("http-address", ":9000", "Listen address for http input")
-	pflag.String("http-timestamp-field", "timestamp", "Name of timestamp field")
-	pflag.String("http-message-field", "message", "Name of message field")
-	pflag.String("http-host-field", "host", "Name of host field")
+	pflag.StringSlice("http-timestamp-field", []string{"timestamp"}, "Path of timestamp field, dotted or JSON pointer. Multiple paths are tried in order")
+	pflag.StringSlice("http-message-field", []string{"message"}, "Path of message field, dotted or JSON pointer. Multiple paths are tried in order")
+	pflag.StringSlice("http-host-field", []string{"host"}, "Path of host field, dotted or JSON pointer. Multiple paths are tried in order")
 	pflag.String("http-basic-user", "", "Username for HTTP Basic authentication. Authentication is not required if empty (default)")
 	pflag.String("http-basic-pass", "", "Password for HTTP Basic authentication. Only used if username was set")
 
-	pflag.String("gelf-address", "127.0.0.1:12201", "Address of GELF server")
-	pflag.String("gelf-proto", "udp", "Protocol of GELf server")
+	pflag.StringSlice("processors", nil, "Processors applied to messages between input and output, in order: "+strings.Join(processorNames, ", "))
+
+	pflag.StringSlice("redact-detectors", processor.NewRedactOptions().Detectors, "Builtin detectors of sensitive data: pan, email, ipv4, ipv6, jwt, aws-key, bearer, password")
+	pflag.StringToString("redact-patterns", nil, "Custom detectors of sensitive data, name=regex")
+	pflag.StringSlice("redact-fields", nil, "Additional fields scanned for sensitive data on top of short and full message, * means all fields")
+	pflag.String("redact-action", "mask", "What to do with sensitive data: mask, hash (HMAC-SHA256) or drop the field")
+	pflag.String("redact-hash-key", "", "Key used by hash redact action")
+
+	pflag.StringSlice("sample-key-fields", []string{"host"}, "Fields identifying streams sampled and rate limited separately")
+	pflag.Float64("sample-rate-limit", 0, "Messages per second allowed for each key, disabled if 0")
+	pflag.Int("sample-burst", 100, "Maximum burst of messages allowed for each key")
+	pflag.Float64("sample-rate", 1, "Fraction of messages kept by sampling, disabled if 1")
+	pflag.String("sample-mode", "random", "Sampling mode: random or hash (keeps or drops all messages with the same key)")
+	pflag.Int("sample-exempt-level", -1, "Messages with this or more severe syslog level are never dropped, disabled if -1")
+	pflag.String("sample-level-field", "", "Additional field containing level (number or name), GELF level is used if empty")
+	pflag.Duration("sample-summary-interval", time.Minute, "How often to emit messages summarizing dropped messages, disabled if 0")
+	pflag.Int("sample-max-keys", 10000, "Maximum number of tracked keys, keys above the limit share a single rate limit")
+
+	pflag.StringSlice("dedup-fields", nil, "Fields used to detect duplicates, whole message is compared if empty")
+	pflag.StringSlice("dedup-ignore-fields", nil, "Fields ignored when comparing whole messages, for example timestamp")
+	pflag.Duration("dedup-window", time.Minute, "How long messages are remembered for duplicate detection")
+	pflag.Int("dedup-max-entries", 10000, "Maximum number of remembered messages, least recently seen are forgotten first")
+	pflag.Bool("dedup-collapse", false, "Emit the first duplicate when the window passes with _repeat_count instead of dropping duplicates")
+
+	pflag.StringSlice("multiline-key-fields", nil, "Fields identifying streams joined separately on top of host")
+	pflag.String("multiline-start-pattern", "", "Regex matching first line of a message, other lines are joined with the previous one")
+	pflag.String("multiline-continuation-pattern", processor.DefaultContinuationPattern, "Regex matching lines joined with the previous one, used if start pattern is empty")
+	pflag.Duration("multiline-flush-timeout", time.Second, "How long to wait for more lines before the message is sent")
+	pflag.Int("multiline-max-lines", 500, "Maximum number of lines joined into one message")
+	pflag.Int("multiline-max-bytes", 65536, "Maximum size of joined message in bytes")
+
+	pflag.StringSlice("geoip-fields", nil, "Fields containing IP addresses to look up")
+	pflag.String("geoip-city-database", "", "Path to GeoLite2 / GeoIP2 City database")
+	pflag.String("geoip-country-database", "", "Path to GeoLite2 / GeoIP2 Country database")
+	pflag.String("geoip-asn-database", "", "Path to GeoLite2 ASN database")
+	pflag.Int("geoip-cache-size", 10000, "Number of cached lookup results, disabled if 0")
+	pflag.Duration("geoip-reload-interval", time.Minute, "How often database files are checked for changes, disabled if 0")
+
+	pflag.StringSlice("script-files", nil, "Starlark scripts defining process(msg) function, run in order")
+	pflag.Uint64("script-max-steps", 100000, "Maximum number of execution steps of a single script call, unlimited if 0")
+	pflag.Duration("script-timeout", 100*time.Millisecond, "Maximum duration of a single script call, unlimited if 0")
+	pflag.String("script-on-error", "pass", "What to do with the message if script fails: pass (unmodified) or drop")
+
+	pflag.String("kubernetes-kubeconfig", "", "Path to kubeconfig file, in-cluster configuration is used if empty")
+	pflag.String("kubernetes-namespace", "", "Watch pods only in this namespace, all namespaces are watched if empty")
+	pflag.String("kubernetes-node-name", "", "Watch pods only on this node")
+	pflag.String("kubernetes-name-field", "pod_name", "Field containing pod name")
+	pflag.String("kubernetes-namespace-field", "namespace", "Field containing pod namespace")
+	pflag.String("kubernetes-container-field", "container_name", "Field containing container name, used to pick container image")
+	pflag.String("kubernetes-ip-field", "remote_addr", "Field containing pod IP, used if pod name or namespace is missing. The default field is only set with --input-metadata=remote-addr")
+	pflag.StringSlice("kubernetes-labels", []string{"*"}, "Pod labels added to messages, * means all")
+	pflag.StringSlice("kubernetes-annotations", nil, "Pod annotations added to messages, * means all")
+	pflag.String("kubernetes-prefix", "k8s_", "Prefix of added fields")
+	pflag.Duration("kubernetes-sync-timeout", time.Minute, "How long to wait for initial list of pods on startup")
+
+	pflag.StringSlice("gelf-address", []string{"127.0.0.1:12201"}, "Addresses of GELF servers, URLs such as https://graylog:12201/gelf in case of HTTP")
+	pflag.String("gelf-balance-strategy", "round-robin", "How messages are spread across GELF servers: round-robin, least-outstanding, hash (by message host) or failover (in order of addresses)")
+	pflag.Int("gelf-workers", 1, "Number of messages sent concurrently")
+	pflag.Int("gelf-connections", 0, "Number of TCP/TLS connections to each GELF server, same as --gelf-workers if 0")
+	pflag.Int("gelf-batch-bytes", 0, "Send TCP/TLS messages in batches of up to this many bytes, disabled if 0")
+	pflag.Int("gelf-batch-messages", 0, "Send TCP/TLS messages in batches of up to this many messages, disabled if 0")
+	pflag.Duration("gelf-batch-linger", 100*time.Millisecond, "How long messages wait for the batch to fill up before it's sent anyway")
+	pflag.Bool("gelf-order-by-host", false, "Send messages of the same host through the same worker, preserving their order")
+	pflag.Int("gelf-max-fails", 3, "Consecutive failures after which GELF server is temporarily ejected")
+	pflag.Duration("gelf-eject-duration", 30*time.Second, "How long ejected GELF server is skipped before being tried again")
+	pflag.Duration("gelf-resolve-interval", 30*time.Second, "How often host names of GELF servers are resolved to pick up new addresses, disabled if 0")
+	pflag.String("gelf-proto", "udp", "Protocol of GELf server: udp, tcp, tls or http")
 	pflag.Int("gelf-max-retries", 3, "How many times to retry sending message in case of failure, -1 means infinity")
-	pflag.Bool("gelf-compression", true, "Enable compression for UDP")
+	pflag.Duration("gelf-backoff-initial", 500*time.Millisecond, "Delay before the first retry of failed message, growing exponentially with following retries")
+	pflag.Duration("gelf-backoff-max-interval", time.Minute, "Maximum delay between retries of failed message")
+	pflag.Duration("gelf-backoff-max-elapsed", 15*time.Minute, "How long failed message may be retried, unlimited if 0")
+	pflag.Bool("gelf-compression", true, "Enable compression for UDP and HTTP")
+	pflag.String("gelf-compression-type", "gzip", "Compression of UDP messages: gzip, zlib or none, HTTP always uses gzip")
+	pflag.Int("gelf-compression-level", 1, "Compression level from 1 (best speed) to 9 (best compression), 0 stores data without compressing but still framed, -1 is default and -2 is Huffman only. HTTP uses -1 unless set")
+	pflag.Int("gelf-compression-threshold", 0, "UDP messages smaller than this many bytes are sent uncompressed")
+	pflag.Int("gelf-chunk-size", 1420, "Maximum size of UDP datagram, larger messages are split into up to 128 chunks")
+	pflag.String("gelf-oversize-policy", "drop", "What to do with messages exceeding 128 UDP chunks: drop, truncate (full and short message), drop-fields (largest first) or dead-letter")
+	pflag.String("gelf-dead-letter-path", "", "File where messages rejected for their content (too large, not encodable, 4xx HTTP response) are appended as JSON lines")
+	pflag.StringToString("gelf-static-fields", nil, "Fields added to every message before sending, environment variables in values are expanded")
+	pflag.StringToString("gelf-template-fields", nil, "Fields added to every message before sending, values are Go templates executed against the message")
+	pflag.StringToString("gelf-http-headers", nil, "Additional headers sent to GELF HTTP input")
+	pflag.String("gelf-http-basic-user", "", "Username for HTTP basic authentication to GELF HTTP input")
+	pflag.String("gelf-http-basic-pass", "", "Password for HTTP basic authentication to GELF HTTP input")
+	pflag.String("gelf-http-bearer-token", "", "Bearer token sent to GELF HTTP input")
+	pflag.String("gelf-http-proxy", "", "URL of HTTP proxy used to reach GELF HTTP input, HTTP_PROXY/HTTPS_PROXY are used if empty")
+	pflag.Duration("gelf-http-timeout", 10*time.Second, "Timeout of a single request to GELF HTTP input")
+	pflag.String("gelf-tls-ca-path", "", "Path to PEM-encoded CA bundle used to verify GELF server, system CAs are used if empty")
+	pflag.String("gelf-tls-cert-path", "", "Path to PEM-encoded client certificate presented to GELF server")
+	pflag.String("gelf-tls-key-path", "", "Path to PEM-encoded client key")
+	pflag.String("gelf-tls-server-name", "", "Server name used for verification and SNI, host of GELF address is used if empty")
+	pflag.String("gelf-tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	pflag.Bool("gelf-tls-insecure-skip-verify", false, "Skip verification of GELF server certificate, do not use in production")
 
 	pflag.Bool("tls-enabled", false, "Use TLS for input")
 	pflag.String("tls-cert-path", "", "Path to PEM-encoded certificate to be used for TLS server. Required if TLS was enabled")
@@ -103,36 +268,64 @@ func setupLogging() {
 	zap.RedirectStdLog(logger)
 }
 
+func validateProcessors() {
+	for _, name := range getStringSlice("processors") {
+		valid := false
+		for _, known := range processorNames {
+			valid = valid || name == known
+		}
+
+		if !valid {
+			zap.S().Fatalf("Invalid processor %v, expected one of: %v", name, strings.Join(processorNames, ", "))
+		}
+	}
+}
+
+func setupKeySanitizer() {
+	options := util.NewKeySanitizerOptions()
+	options.AllowedChars = viper.GetString("key-allowed-chars")
+	options.MaxKeyLength = viper.GetInt("key-max-length")
+	options.CollisionStrategy = viper.GetString("key-collision-strategy")
+	options.ReservedSuffix = viper.GetString("key-reserved-suffix")
+
+	if err := util.ConfigureKeySanitizer(options); err != nil {
+		zap.S().Panic("Could not configure key sanitizer", err)
+	}
+}
+
 func setupInput() util.Component {
 	switch viper.GetString("input-type") {
 	case "vector":
 		inOpts := input.NewVectorInputOptions()
 		inOpts.Address = viper.GetString("vector-address")
 		inOpts.MaxMsgSize = viper.GetUint32("vector-max-message-size")
-		inOpts.HostField = viper.GetString("vector-host-field")
-		inOpts.MessageField = viper.GetString("vector-message-field")
-		inOpts.TimestampField = viper.GetString("vector-timestamp-field")
+		inOpts.HostField = getStringSlice("vector-host-field")
+		inOpts.MessageField = getStringSlice("vector-message-field")
+		inOpts.TimestampField = getStringSlice("vector-timestamp-field")
 		inOpts.TLS = createTLSOptions()
+		inOpts.Schema = createSchemaOptions()
 
 		return input.NewVectorInput(inOpts)
 	case "vectorv2":
 		inOpts := input.NewVectorV2InputOptions()
 		inOpts.Address = viper.GetString("vector-address")
-		inOpts.HostField = viper.GetString("vector-host-field")
-		inOpts.MessageField = viper.GetString("vector-message-field")
-		inOpts.TimestampField = viper.GetString("vector-timestamp-field")
+		inOpts.HostField = getStringSlice("vector-host-field")
+		inOpts.MessageField = getStringSlice("vector-message-field")
+		inOpts.TimestampField = getStringSlice("vector-timestamp-field")
 		inOpts.TLS = createTLSOptions()
+		inOpts.Schema = createSchemaOptions()
 
 		return input.NewVectorV2Input(inOpts)
 	case "http":
 		inOpts := input.NewHTTPInputOptions()
 		inOpts.Address = viper.GetString("http-address")
-		inOpts.HostField = viper.GetString("http-host-field")
-		inOpts.MessageField = viper.GetString("http-message-field")
-		inOpts.TimestampField = viper.GetString("http-timestamp-field")
+		inOpts.HostField = getStringSlice("http-host-field")
+		inOpts.MessageField = getStringSlice("http-message-field")
+		inOpts.TimestampField = getStringSlice("http-timestamp-field")
 		inOpts.BasicUser = viper.GetString("http-basic-user")
 		inOpts.BasicPass = viper.GetString("http-basic-pass")
 		inOpts.TLS = createTLSOptions()
+		inOpts.Schema = createSchemaOptions()
 		inOpts.Backpressure = viper.GetBool("backpressure")
 
 		return input.NewHTTPInput(inOpts)
@@ -141,17 +334,225 @@ func setupInput() util.Component {
 	}
 }
 
+func setupProcessors() []processor.Processor {
+	var processors []processor.Processor
+
+	for _, name := range getStringSlice("processors") {
+		switch name {
+		case "redact":
+			opts := processor.NewRedactOptions()
+			opts.Detectors = getStringSlice("redact-detectors")
+			opts.Patterns = getStringMap("redact-patterns")
+			opts.Fields = getStringSlice("redact-fields")
+			opts.Action = viper.GetString("redact-action")
+			opts.HashKey = viper.GetString("redact-hash-key")
+
+			processors = append(processors, processor.NewRedactProcessor(opts))
+		case "sample":
+			opts := processor.NewSampleOptions()
+			opts.KeyFields = getStringSlice("sample-key-fields")
+			opts.Rate = viper.GetFloat64("sample-rate-limit")
+			opts.Burst = viper.GetInt("sample-burst")
+			opts.SampleRate = viper.GetFloat64("sample-rate")
+			opts.SampleMode = viper.GetString("sample-mode")
+			opts.ExemptLevel = viper.GetInt("sample-exempt-level")
+			opts.LevelField = viper.GetString("sample-level-field")
+			opts.SummaryInterval = viper.GetDuration("sample-summary-interval")
+			opts.MaxKeys = viper.GetInt("sample-max-keys")
+
+			processors = append(processors, processor.NewSampleProcessor(opts))
+		case "dedup":
+			opts := processor.NewDedupOptions()
+			opts.Fields = getStringSlice("dedup-fields")
+			opts.IgnoreFields = getStringSlice("dedup-ignore-fields")
+			opts.Window = viper.GetDuration("dedup-window")
+			opts.MaxEntries = viper.GetInt("dedup-max-entries")
+			opts.Collapse = viper.GetBool("dedup-collapse")
+
+			processors = append(processors, processor.NewDedupProcessor(opts))
+		case "multiline":
+			opts := processor.NewMultilineOptions()
+			opts.KeyFields = getStringSlice("multiline-key-fields")
+			opts.StartPattern = viper.GetString("multiline-start-pattern")
+			opts.ContinuationPattern = viper.GetString("multiline-continuation-pattern")
+			opts.FlushTimeout = viper.GetDuration("multiline-flush-timeout")
+			opts.MaxLines = viper.GetInt("multiline-max-lines")
+			opts.MaxBytes = viper.GetInt("multiline-max-bytes")
+
+			processors = append(processors, processor.NewMultilineProcessor(opts))
+		case "geoip":
+			opts := processor.NewGeoIPOptions()
+			opts.Fields = getStringSlice("geoip-fields")
+			opts.CityDatabase = viper.GetString("geoip-city-database")
+			opts.CountryDatabase = viper.GetString("geoip-country-database")
+			opts.ASNDatabase = viper.GetString("geoip-asn-database")
+			opts.CacheSize = viper.GetInt("geoip-cache-size")
+			opts.ReloadInterval = viper.GetDuration("geoip-reload-interval")
+
+			processors = append(processors, processor.NewGeoIPProcessor(opts))
+		case "script":
+			opts := processor.NewScriptOptions()
+			opts.Scripts = getStringSlice("script-files")
+			opts.MaxSteps = viper.GetUint64("script-max-steps")
+			opts.Timeout = viper.GetDuration("script-timeout")
+			opts.OnError = viper.GetString("script-on-error")
+
+			processors = append(processors, processor.NewScriptProcessor(opts))
+		case "kubernetes":
+			opts := processor.NewKubernetesOptions()
+			opts.KubeConfig = viper.GetString("kubernetes-kubeconfig")
+			opts.Namespace = viper.GetString("kubernetes-namespace")
+			opts.NodeName = viper.GetString("kubernetes-node-name")
+			opts.NameField = viper.GetString("kubernetes-name-field")
+			opts.NamespaceField = viper.GetString("kubernetes-namespace-field")
+			opts.ContainerField = viper.GetString("kubernetes-container-field")
+			opts.IPField = viper.GetString("kubernetes-ip-field")
+			opts.Labels = getStringSlice("kubernetes-labels")
+			opts.Annotations = getStringSlice("kubernetes-annotations")
+			opts.Prefix = viper.GetString("kubernetes-prefix")
+			opts.SyncTimeout = viper.GetDuration("kubernetes-sync-timeout")
+
+			if opts.IPField == "remote_addr" && !hasInputMetadata(input.MetadataRemoteAddr) {
+				zap.S().Warnf("Field %v is only set with --input-metadata=%v, pods will be looked up by name only", opts.IPField, input.MetadataRemoteAddr)
+			}
+
+			processors = append(processors, processor.NewKubernetesProcessor(opts))
+		}
+	}
+
+	return processors
+}
+
 func setupOutput() util.Component {
 	outOpts := output.NewGelfOutputOptions()
-	outOpts.Address = viper.GetString("gelf-address")
+	outOpts.Addresses = getStringSlice("gelf-address")
 	outOpts.GracefulTimeoutSeconds = viper.GetInt("graceful-timeout")
 	outOpts.RetryLimit = viper.GetInt("gelf-max-retries")
+	outOpts.Backoff = output.BackoffOptions{
+		InitialInterval: viper.GetDuration("gelf-backoff-initial"),
+		MaxInterval:     viper.GetDuration("gelf-backoff-max-interval"),
+		MaxElapsedTime:  viper.GetDuration("gelf-backoff-max-elapsed"),
+	}
 	outOpts.Compression = viper.GetBool("gelf-compression")
+	outOpts.CompressionType = viper.GetString("gelf-compression-type")
+	outOpts.CompressionLevel = viper.GetInt("gelf-compression-level")
+	outOpts.CompressionThreshold = viper.GetInt("gelf-compression-threshold")
 	outOpts.Proto = viper.GetString("gelf-proto")
+	outOpts.ChunkSize = viper.GetInt("gelf-chunk-size")
+	outOpts.OversizePolicy = viper.GetString("gelf-oversize-policy")
+	outOpts.DeadLetterPath = viper.GetString("gelf-dead-letter-path")
+	outOpts.Enrich = util.EnrichOptions{
+		StaticFields:   getStringMap("gelf-static-fields"),
+		TemplateFields: getStringMap("gelf-template-fields"),
+	}
+	outOpts.TLS = util.TLSOutputOptions{
+		CAPath:             viper.GetString("gelf-tls-ca-path"),
+		CertPath:           viper.GetString("gelf-tls-cert-path"),
+		KeyPath:            viper.GetString("gelf-tls-key-path"),
+		ServerName:         viper.GetString("gelf-tls-server-name"),
+		MinVersion:         viper.GetString("gelf-tls-min-version"),
+		InsecureSkipVerify: viper.GetBool("gelf-tls-insecure-skip-verify"),
+	}
+	outOpts.Workers = viper.GetInt("gelf-workers")
+	outOpts.Connections = viper.GetInt("gelf-connections")
+	outOpts.OrderByHost = viper.GetBool("gelf-order-by-host")
+	outOpts.BatchBytes = viper.GetInt("gelf-batch-bytes")
+	outOpts.BatchMessages = viper.GetInt("gelf-batch-messages")
+	outOpts.BatchLinger = viper.GetDuration("gelf-batch-linger")
+	outOpts.Balancer = output.NewBalancerOptions()
+	outOpts.Balancer.Strategy = viper.GetString("gelf-balance-strategy")
+	outOpts.Balancer.MaxFails = viper.GetInt("gelf-max-fails")
+	outOpts.Balancer.EjectDuration = viper.GetDuration("gelf-eject-duration")
+	outOpts.Balancer.ResolveInterval = viper.GetDuration("gelf-resolve-interval")
+	outOpts.HTTP = output.GelfHTTPOptions{
+		Headers:     getStringMap("gelf-http-headers"),
+		BasicUser:   viper.GetString("gelf-http-basic-user"),
+		BasicPass:   viper.GetString("gelf-http-basic-pass"),
+		BearerToken: viper.GetString("gelf-http-bearer-token"),
+		Proxy:       viper.GetString("gelf-http-proxy"),
+		Timeout:     viper.GetDuration("gelf-http-timeout"),
+	}
+
+	// HTTP keeps the gzip default level unless the level is set explicitly
+	outOpts.HTTP.CompressionLevel = flate.DefaultCompression
+	if viper.IsSet("gelf-compression-level") {
+		outOpts.HTTP.CompressionLevel = outOpts.CompressionLevel
+	}
 
 	return output.NewGelfOutput(outOpts)
 }
 
+// getStringSlice works around viper not splitting comma separated lists provided via environment variables
+func getStringSlice(key string) []string {
+	var out []string
+
+	for _, item := range viper.GetStringSlice(key) {
+		for _, part := range strings.Split(item, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+
+	return out
+}
+
+func createSchemaOptions() input.SchemaOptions {
+	return input.SchemaOptions{
+		MissingHostPolicies:    getStringSlice("missing-host-policy"),
+		DefaultHost:            viper.GetString("default-host"),
+		MissingMessagePolicies: getStringSlice("missing-message-policy"),
+		MessageTemplate:        viper.GetString("message-template"),
+		TimestampLayouts:       getStringSlice("timestamp-layouts"),
+		TimestampUnit:          viper.GetString("timestamp-unit"),
+		TimestampTimezone:      viper.GetString("timestamp-timezone"),
+		TimestampMaxSkew:       viper.GetDuration("timestamp-max-skew"),
+		TimestampSkewPolicy:    viper.GetString("timestamp-skew-policy"),
+		FullMessageField:       getStringSlice("full-message-field"),
+		ShortMessageMaxBytes:   viper.GetInt("short-message-max-bytes"),
+		ShortMessageTruncate:   viper.GetString("short-message-truncate"),
+		FlattenSeparator:       viper.GetString("flatten-separator"),
+		FlattenMaxDepth:        viper.GetInt("flatten-max-depth"),
+		FlattenArrays:          viper.GetString("flatten-arrays"),
+		FlattenArrayDelimiter:  viper.GetString("flatten-array-delimiter"),
+		MaxExtraFields:         viper.GetInt("max-extra-fields"),
+		BoolMode:               viper.GetString("bool-mode"),
+		NullMode:               viper.GetString("null-mode"),
+		TimestampFormat:        viper.GetString("timestamp-format"),
+		FieldTypes:             getStringMap("field-types"),
+		Enrich: util.EnrichOptions{
+			StaticFields:   getStringMap("input-static-fields"),
+			TemplateFields: getStringMap("input-template-fields"),
+		},
+		Metadata:        getStringSlice("input-metadata"),
+		MetadataHeaders: getStringSlice("input-metadata-headers"),
+	}
+}
+
+// getStringMap works around viper not parsing key=value lists provided via environment variables
+func getStringMap(key string) map[string]string {
+	raw, ok := viper.Get(key).(string)
+	if !ok {
+		return viper.GetStringMapString(key)
+	}
+
+	out := make(map[string]string)
+	var last string
+
+	// only commas followed by the next key=value pair separate pairs, others are part of the value
+	for _, part := range strings.Split(raw, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) != "" {
+			last = strings.TrimSpace(kv[0])
+			out[last] = kv[1]
+		} else if last != "" {
+			out[last] += "," + part
+		}
+	}
+
+	return out
+}
+
 func createTLSOptions() util.TLSInputOptions {
 	return util.TLSInputOptions{
 		Enabled:        viper.GetBool("tls-enabled"),
@@ -160,3 +561,14 @@ func createTLSOptions() util.TLSInputOptions {
 		ClientCAPath:   viper.GetString("tls-client-ca-path"),
 	}
 }
+
+// hasInputMetadata returns whether inputs add the request metadata to messages
+func hasInputMetadata(name string) bool {
+	for _, metadata := range getStringSlice("input-metadata") {
+		if metadata == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const (
+	ArraysIndex = "index"
+	ArraysJSON  = "json"
+	ArraysJoin  = "join"
+
+	BoolString = "string"
+	BoolInt    = "int"
+	BoolNative = "native"
+
+	NullString = "string"
+	NullDrop   = "drop"
+
+	TimestampRFC3339 = "rfc3339"
+	TimestampUnix    = "unix"
+	TimestampUnixMs  = "unix-ms"
+
+	TypeString = "string"
+	TypeNumber = "number"
+)
+
+// extraWriter appends additional fields to a single message, enforcing flattening limits.
+type extraWriter struct {
+	schema  *messageSchema
+	msg     *gelf.Message
+	written int
+	dropped int
+}
+
+func (s *messageSchema) newExtraWriter(msg *gelf.Message) *extraWriter {
+	return &extraWriter{
+		schema: s,
+		msg:    msg,
+	}
+}
+
+func (s *messageSchema) validateFlattening() error {
+	switch s.options.FlattenArrays {
+	case "", ArraysIndex, ArraysJSON, ArraysJoin:
+	default:
+		return fmt.Errorf("invalid array flattening mode: %v", s.options.FlattenArrays)
+	}
+
+	if s.options.FlattenMaxDepth < 0 {
+		return fmt.Errorf("flattening depth can't be negative")
+	}
+
+	switch s.options.BoolMode {
+	case "", BoolString, BoolInt, BoolNative:
+	default:
+		return fmt.Errorf("invalid boolean mode: %v", s.options.BoolMode)
+	}
+
+	switch s.options.NullMode {
+	case "", NullString, NullDrop:
+	default:
+		return fmt.Errorf("invalid null mode: %v", s.options.NullMode)
+	}
+
+	switch s.options.TimestampFormat {
+	case "", TimestampRFC3339, TimestampUnix, TimestampUnixMs:
+	default:
+		return fmt.Errorf("invalid timestamp format: %v", s.options.TimestampFormat)
+	}
+
+	if !util.KeyCharsAllowed(s.options.FlattenSeparator) {
+		return fmt.Errorf("flatten separator %q would be replaced in field names, it needs to be added to allowed key characters", s.options.FlattenSeparator)
+	}
+
+	for field, typ := range s.options.FieldTypes {
+		if typ != TypeString && typ != TypeNumber {
+			return fmt.Errorf("invalid type %v of field %v", typ, field)
+		}
+	}
+
+	return nil
+}
+
+func (w *extraWriter) joinKey(parent, child string) string {
+	return parent + w.schema.options.FlattenSeparator + child
+}
+
+// nestingAllowed returns whether nested value at given depth should be flattened further.
+// Depth of top-level fields is 1.
+func (w *extraWriter) nestingAllowed(depth int) bool {
+	return w.schema.options.FlattenMaxDepth == 0 || depth < w.schema.options.FlattenMaxDepth
+}
+
+func (w *extraWriter) arrayMode() string {
+	if w.schema.options.FlattenArrays == "" {
+		return ArraysIndex
+	}
+
+	return w.schema.options.FlattenArrays
+}
+
+func (w *extraWriter) appendBool(key string, value bool) {
+	switch w.schema.options.BoolMode {
+	case BoolInt:
+		if value {
+			w.append(key, int64(1))
+		} else {
+			w.append(key, int64(0))
+		}
+	case BoolNative:
+		w.append(key, value)
+	default:
+		w.append(key, strconv.FormatBool(value))
+	}
+}
+
+func (w *extraWriter) appendNull(key string) {
+	if w.schema.options.NullMode != NullDrop {
+		w.append(key, "null")
+	}
+}
+
+func (w *extraWriter) appendTime(key string, value time.Time) {
+	switch w.schema.options.TimestampFormat {
+	case TimestampUnix:
+		w.append(key, timeToUnix(value))
+	case TimestampUnixMs:
+		w.append(key, value.UnixNano()/int64(time.Millisecond))
+	default:
+		w.append(key, value.Format(time.RFC3339Nano))
+	}
+}
+
+func (w *extraWriter) append(key string, value interface{}) {
+	if typ, ok := w.schema.options.FieldTypes[key]; ok {
+		coerced, ok := coerceType(value, typ)
+		if !ok {
+			util.IncCounter(w.schema.name + ".type_coercion_failed")
+			return
+		}
+		value = coerced
+	}
+
+	// only extracted fields count, fields added by input or enrichment don't take up the limit
+	if limit := w.schema.options.MaxExtraFields; limit > 0 && w.written >= limit {
+		w.dropped++
+		return
+	}
+
+	w.written++
+	util.AppendExtraToGelf(w.msg, key, value)
+}
+
+// finish reports fields dropped due to the limit in _dropped_fields.
+func (w *extraWriter) finish() {
+	if w.dropped > 0 {
+		util.AppendExtraToGelf(w.msg, "dropped_fields", int64(w.dropped))
+		util.AddCounter(w.schema.name+".dropped_fields", int64(w.dropped))
+	}
+}
+
+// coerceType converts value into requested type, false is returned if conversion isn't possible.
+func coerceType(value interface{}, typ string) (interface{}, bool) {
+	switch typ {
+	case TypeString:
+		switch casted := value.(type) {
+		case string:
+			return casted, true
+		case float64:
+			return strconv.FormatFloat(casted, 'f', -1, 64), true
+		case int64:
+			return strconv.FormatInt(casted, 10), true
+		case bool:
+			return strconv.FormatBool(casted), true
+		}
+	case TypeNumber:
+		switch casted := value.(type) {
+		case string:
+			num, err := strconv.ParseFloat(strings.TrimSpace(casted), 64)
+			return num, err == nil
+		case float64, int64:
+			return casted, true
+		case bool:
+			if casted {
+				return int64(1), true
+			}
+			return int64(0), true
+		}
+	}
+
+	return nil, false
+}
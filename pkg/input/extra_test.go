@@ -0,0 +1,267 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const flattenEvent = `{"message": "m", "host": "h", "user": {"name": "john", "roles": ["admin", "dev"], "meta": {"age": 30}}}`
+
+func expectExtra(t *testing.T, extra map[string]interface{}, expected map[string]interface{}) {
+	t.Helper()
+
+	if len(extra) != len(expected) {
+		t.Errorf("extra: expected %v, got %v", expected, extra)
+		return
+	}
+
+	for key, value := range expected {
+		if extra[key] != value {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", key, value, value, extra[key], extra[key])
+		}
+	}
+}
+
+func TestFlattenDefaults(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_user_name":     "john",
+		"_user_roles_0":  "admin",
+		"_user_roles_1":  "dev",
+		"_user_meta_age": 30.0,
+	})
+}
+
+// deeper objects and arrays are kept as JSON
+func TestFlattenMaxDepth(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FlattenMaxDepth: 2}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_user_name":  "john",
+		"_user_roles": `["admin","dev"]`,
+		"_user_meta":  `{"age":30}`,
+	})
+}
+
+func TestFlattenArraysJSON(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FlattenArrays: ArraysJSON}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if msg.Extra["_user_roles"] != `["admin","dev"]` {
+		t.Errorf("_user_roles: expected JSON array, got %v", msg.Extra["_user_roles"])
+	}
+}
+
+func TestFlattenArraysJoin(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FlattenArrays: ArraysJoin, FlattenArrayDelimiter: ","}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if msg.Extra["_user_roles"] != "admin,dev" {
+		t.Errorf("_user_roles: expected admin,dev, got %v", msg.Extra["_user_roles"])
+	}
+}
+
+func TestFlattenSeparator(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FlattenSeparator: "__"}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_user__name":      "john",
+		"_user__roles__0":  "admin",
+		"_user__roles__1":  "dev",
+		"_user__meta__age": 30.0,
+	})
+}
+
+// fields added by input metadata don't count towards the limit
+func TestFlattenMaxExtraFields(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{MaxExtraFields: 2, Metadata: []string{MetadataInput}}, flattenEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_user_name":      "john",
+		"_user_roles_0":   "admin",
+		"_dropped_fields": int64(2),
+		"_input":          "http",
+	})
+}
+
+// separator replaced by the key sanitizer would make flattened keys collide
+func TestFlattenSeparatorSanitized(t *testing.T) {
+	if err := newMessageSchema("test", nil, nil, nil, SchemaOptions{FlattenSeparator: "."}).prepare(); err == nil {
+		t.Errorf("prepare: expected error for separator not allowed in keys")
+		return
+	}
+
+	sanitizer := util.NewKeySanitizerOptions()
+	sanitizer.AllowedChars = "."
+	if err := util.ConfigureKeySanitizer(sanitizer); err != nil {
+		t.Errorf("ConfigureKeySanitizer: %s", err)
+		return
+	}
+	defer util.ConfigureKeySanitizer(util.NewKeySanitizerOptions())
+
+	if err := newMessageSchema("test", nil, nil, nil, SchemaOptions{FlattenSeparator: "."}).prepare(); err != nil {
+		t.Errorf("prepare: %s", err)
+	}
+}
+
+func TestPrepareInvalidFlattening(t *testing.T) {
+	invalid := map[string]SchemaOptions{
+		"array mode":     {FlattenArrays: "csv"},
+		"negative depth": {FlattenMaxDepth: -1},
+	}
+
+	for name, options := range invalid {
+		if err := newMessageSchema("test", nil, nil, nil, options).prepare(); err == nil {
+			t.Errorf("prepare: expected error for %s", name)
+		}
+	}
+}
+
+const typesEvent = `{"message": "m", "host": "h", "ok": true, "failed": false, "gone": null, "count": "42"}`
+
+func TestBoolAndNullDefaults(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{}, typesEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_ok":     "true",
+		"_failed": "false",
+		"_gone":   "null",
+		"_count":  "42",
+	})
+}
+
+func TestBoolNativeAndNullDrop(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{BoolMode: BoolNative, NullMode: NullDrop}, typesEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_ok":     true,
+		"_failed": false,
+		"_count":  "42",
+	})
+}
+
+func TestBoolInt(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{BoolMode: BoolInt}, typesEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if msg.Extra["_ok"] != int64(1) || msg.Extra["_failed"] != int64(0) {
+		t.Errorf("expected booleans as 1/0, got %v/%v", msg.Extra["_ok"], msg.Extra["_failed"])
+	}
+}
+
+// fields that can't be converted to the forced type are dropped
+func TestFieldTypes(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{
+		FieldTypes: map[string]string{"count": TypeNumber, "ok": TypeString, "gone": TypeNumber},
+		BoolMode:   BoolNative,
+	}, typesEvent)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	expectExtra(t, msg.Extra, map[string]interface{}{
+		"_ok":     "true",
+		"_failed": false,
+		"_count":  42.0,
+	})
+}
+
+func TestAppendTime(t *testing.T) {
+	ts := time.Unix(1600000000, 500000000).UTC()
+
+	formats := map[string]interface{}{
+		"":               "2020-09-13T12:26:40.5Z",
+		TimestampUnix:    1600000000.5,
+		TimestampUnixMs:  int64(1600000000500),
+		TimestampRFC3339: "2020-09-13T12:26:40.5Z",
+	}
+
+	for format, expected := range formats {
+		schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{TimestampFormat: format})
+		msg := &gelf.Message{Extra: map[string]interface{}{}}
+
+		schema.newExtraWriter(msg).appendTime("at", ts)
+		if msg.Extra["_at"] != expected {
+			t.Errorf("appendTime %s: expected %v, got %v", format, expected, msg.Extra["_at"])
+		}
+	}
+}
+
+func TestCoerceType(t *testing.T) {
+	for _, value := range []interface{}{"7", 7.0, int64(7)} {
+		if got, ok := coerceType(value, TypeString); !ok || got != "7" {
+			t.Errorf("coerceType %v to string: got %v", value, got)
+		}
+	}
+
+	if got, ok := coerceType(" 2.5 ", TypeNumber); !ok || got != 2.5 {
+		t.Errorf("coerceType to number: expected 2.5, got %v", got)
+	}
+
+	if got, ok := coerceType(int64(3), TypeNumber); !ok || got != int64(3) {
+		t.Errorf("coerceType: expected integers to stay int64, got %T", got)
+	}
+
+	if got, ok := coerceType(true, TypeNumber); !ok || got != int64(1) {
+		t.Errorf("coerceType: expected true to be 1, got %v", got)
+	}
+
+	for _, value := range []interface{}{"abc", nil} {
+		if _, ok := coerceType(value, TypeNumber); ok {
+			t.Errorf("coerceType %v to number: expected failure", value)
+		}
+	}
+}
+
+func TestPrepareInvalidTypes(t *testing.T) {
+	invalid := map[string]SchemaOptions{
+		"bool mode":        {BoolMode: "yes"},
+		"null mode":        {NullMode: "empty"},
+		"timestamp format": {TimestampFormat: "iso"},
+		"field type":       {FieldTypes: map[string]string{"x": "bool"}},
+	}
+
+	for name, options := range invalid {
+		if err := newMessageSchema("test", nil, nil, nil, options).prepare(); err == nil {
+			t.Errorf("prepare: expected error for invalid %s", name)
+		}
+	}
+}
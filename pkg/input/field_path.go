@@ -0,0 +1,112 @@
+package input
+
+import (
+	"strings"
+
+	vector "github.com/eplightning/gelf-forwarder/pkg/vector/event"
+	"github.com/valyala/fastjson"
+)
+
+// fieldPath points to a field that may be nested inside other object fields.
+// Paths are either dotted ("kubernetes.pod_name") or JSON pointers ("/kubernetes/pod_name").
+type fieldPath struct {
+	raw      string
+	segments []string
+	// dotted paths are first looked up as a single key, so "a.b" still matches a flat "a.b" field
+	literal bool
+}
+
+// fieldSelector is a list of paths tried in order, first path that exists wins.
+type fieldSelector []fieldPath
+
+func parseFieldPath(path string) fieldPath {
+	if strings.HasPrefix(path, "/") {
+		segments := strings.Split(path[1:], "/")
+		for i, segment := range segments {
+			segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+		}
+
+		return fieldPath{raw: path, segments: segments}
+	}
+
+	return fieldPath{
+		raw:      path,
+		segments: strings.Split(path, "."),
+		literal:  strings.Contains(path, "."),
+	}
+}
+
+func newFieldSelector(paths []string) fieldSelector {
+	var selector fieldSelector
+
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		selector = append(selector, parseFieldPath(path))
+	}
+
+	return selector
+}
+
+func (p fieldPath) String() string {
+	return p.raw
+}
+
+// lookupJson returns the value together with the object holding it and its key, so the field can be removed later.
+func (p fieldPath) lookupJson(obj *fastjson.Object) (*fastjson.Value, *fastjson.Object, string) {
+	if p.literal {
+		if value := obj.Get(p.raw); value != nil {
+			return value, obj, p.raw
+		}
+	}
+
+	current := obj
+	last := len(p.segments) - 1
+
+	for _, segment := range p.segments[:last] {
+		value := current.Get(segment)
+		if value == nil || value.Type() != fastjson.TypeObject {
+			return nil, nil, ""
+		}
+
+		current, _ = value.Object()
+	}
+
+	value := current.Get(p.segments[last])
+	if value == nil {
+		return nil, nil, ""
+	}
+
+	return value, current, p.segments[last]
+}
+
+// lookupVector returns the value together with the map holding it and its key, so the field can be removed later.
+func (p fieldPath) lookupVector(fields map[string]*vector.Value) (*vector.Value, map[string]*vector.Value, string) {
+	if p.literal {
+		if value, exists := fields[p.raw]; exists {
+			return value, fields, p.raw
+		}
+	}
+
+	current := fields
+	last := len(p.segments) - 1
+
+	for _, segment := range p.segments[:last] {
+		nested := current[segment].GetMap()
+		if nested == nil {
+			return nil, nil, ""
+		}
+
+		current = nested.Fields
+	}
+
+	value, exists := current[p.segments[last]]
+	if !exists {
+		return nil, nil, ""
+	}
+
+	return value, current, p.segments[last]
+}
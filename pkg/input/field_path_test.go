@@ -0,0 +1,144 @@
+package input
+
+import (
+	"testing"
+
+	vector "github.com/eplightning/gelf-forwarder/pkg/vector/event"
+	"github.com/valyala/fastjson"
+)
+
+const nestedEvent = `{
+	"message": "top",
+	"a.b": "flat",
+	"a": {"b": "nested", "c": {"d": "deep"}},
+	"k8s": {"pod/name": "web", "x~y": "tilde"},
+	"list": [1, 2]
+}`
+
+func TestFieldPathDotted(t *testing.T) {
+	obj := fastjson.MustParse(nestedEvent).GetObject()
+
+	value, parent, key := parseFieldPath("a.c.d").lookupJson(obj)
+	if value == nil {
+		t.Errorf("lookupJson: a.c.d not found")
+		return
+	}
+
+	if string(value.GetStringBytes()) != "deep" || key != "d" {
+		t.Errorf("lookupJson: expected deep at d, got %s at %s", value, key)
+		return
+	}
+
+	// parent is returned so that the field can be removed from the event
+	if parent.Get(key) != value {
+		t.Errorf("lookupJson: parent doesn't hold the value")
+	}
+}
+
+// flat keys containing dots take precedence over nested objects
+func TestFieldPathLiteralDot(t *testing.T) {
+	obj := fastjson.MustParse(nestedEvent).GetObject()
+
+	value, _, key := parseFieldPath("a.b").lookupJson(obj)
+	if value == nil || string(value.GetStringBytes()) != "flat" || key != "a.b" {
+		t.Errorf("lookupJson: expected flat at a.b, got %s at %s", value, key)
+	}
+}
+
+func TestFieldPathPointer(t *testing.T) {
+	obj := fastjson.MustParse(nestedEvent).GetObject()
+
+	expected := map[string]string{
+		"/a/b":           "nested",
+		"/a/c/d":         "deep",
+		"/k8s/pod~1name": "web",
+		"/k8s/x~0y":      "tilde",
+	}
+
+	for path, want := range expected {
+		value, _, _ := parseFieldPath(path).lookupJson(obj)
+		if value == nil || string(value.GetStringBytes()) != want {
+			t.Errorf("lookupJson %s: expected %s, got %s", path, want, value)
+		}
+	}
+}
+
+func TestFieldPathMissing(t *testing.T) {
+	obj := fastjson.MustParse(nestedEvent).GetObject()
+
+	// arrays and scalars can't be descended into
+	for _, path := range []string{"a.missing", "list.0", "message.x", "/missing"} {
+		if value, parent, key := parseFieldPath(path).lookupJson(obj); value != nil || parent != nil || key != "" {
+			t.Errorf("lookupJson %s: expected nothing, got %s", path, value)
+		}
+	}
+}
+
+func TestFieldPathVector(t *testing.T) {
+	str := func(s string) *vector.Value {
+		return &vector.Value{Kind: &vector.Value_RawBytes{RawBytes: []byte(s)}}
+	}
+
+	fields := map[string]*vector.Value{
+		"message": str("top"),
+		"a.b":     str("flat"),
+		"a": {Kind: &vector.Value_Map{Map: &vector.ValueMap{Fields: map[string]*vector.Value{
+			"b": str("nested"),
+		}}}},
+	}
+
+	expected := map[string]string{
+		"message": "top",
+		"a.b":     "flat",
+		"/a/b":    "nested",
+	}
+
+	for path, want := range expected {
+		value, parent, key := parseFieldPath(path).lookupVector(fields)
+		if value == nil || vectorValueToString(value) != want {
+			t.Errorf("lookupVector %s: expected %s, got %v", path, want, value)
+			continue
+		}
+
+		if parent[key] != value {
+			t.Errorf("lookupVector %s: parent doesn't hold the value", path)
+		}
+	}
+
+	if value, _, _ := parseFieldPath("message.x").lookupVector(fields); value != nil {
+		t.Errorf("lookupVector: expected nothing for message.x, got %v", value)
+	}
+}
+
+func TestRequireJsonStringFallback(t *testing.T) {
+	obj := fastjson.MustParse(`{"msg": " ", "message": "b", "log": "c"}`).GetObject()
+
+	// blank paths are ignored and empty values skipped
+	str, err := requireJsonString(obj, newFieldSelector([]string{" ", "msg", "message", "log"}))
+	if err != nil {
+		t.Errorf("requireJsonString: %s", err)
+		return
+	}
+
+	if str != "b" {
+		t.Errorf("requireJsonString: expected b, got %s", str)
+		return
+	}
+
+	// selected field is removed, so it isn't added to additional fields
+	if obj.Get("message") != nil || obj.Get("msg") == nil || obj.Get("log") == nil {
+		t.Errorf("requireJsonString: expected only message to be removed, got %s", obj)
+	}
+}
+
+func TestRequireJsonStringErrors(t *testing.T) {
+	obj := fastjson.MustParse(`{"msg": ""}`).GetObject()
+
+	if _, err := requireJsonString(obj, newFieldSelector([]string{"msg"})); err == nil || err.Error() != "field is empty" {
+		t.Errorf("requireJsonString: expected empty field error, got %v", err)
+	}
+
+	if _, err := requireJsonString(obj, newFieldSelector([]string{"message"})); err == nil || err.Error() != "field doesn't exist" {
+		t.Errorf("requireJsonString: expected missing field error, got %v", err)
+	}
+}
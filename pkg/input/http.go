@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,7 +12,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/eplightning/gelf-forwarder/pkg/util"
@@ -20,55 +20,56 @@ import (
 )
 
 type HTTPInput struct {
-	address        string
-	listener       net.Listener
-	msgCh          chan *gelf.Message
-	closed         bool
-	timestampField string
-	messageField   string
-	hostField      string
-	log            *zap.SugaredLogger
-	basicUser      string
-	basicPass      string
-	tls            util.TLSInputOptions
-	backpressure   bool
+	address      string
+	listener     net.Listener
+	msgCh        chan *gelf.Message
+	closed       bool
+	schema       *messageSchema
+	log          *zap.SugaredLogger
+	basicUser    string
+	basicPass    string
+	tls          util.TLSInputOptions
+	backpressure bool
 }
 
 type HTTPInputOptions struct {
 	Address        string
-	TimestampField string
-	MessageField   string
-	HostField      string
+	TimestampField []string
+	MessageField   []string
+	HostField      []string
 	BasicUser      string
 	BasicPass      string
 	TLS            util.TLSInputOptions
 	Backpressure   bool
+	Schema         SchemaOptions
 }
 
 func NewHTTPInputOptions() HTTPInputOptions {
 	return HTTPInputOptions{
 		Address:        ":9000",
-		TimestampField: "timestamp",
-		MessageField:   "message",
-		HostField:      "host",
+		TimestampField: []string{"timestamp"},
+		MessageField:   []string{"message"},
+		HostField:      []string{"host"},
 	}
 }
 
 func NewHTTPInput(options HTTPInputOptions) *HTTPInput {
 	return &HTTPInput{
-		address:        options.Address,
-		timestampField: options.TimestampField,
-		messageField:   options.MessageField,
-		hostField:      options.HostField,
-		basicUser:      options.BasicUser,
-		basicPass:      options.BasicPass,
-		log:            zap.S().With("component", "http-input"),
-		tls:            options.TLS,
-		backpressure:   options.Backpressure,
+		address:      options.Address,
+		schema:       newMessageSchema("http", options.TimestampField, options.MessageField, options.HostField, options.Schema),
+		basicUser:    options.BasicUser,
+		basicPass:    options.BasicPass,
+		log:          zap.S().With("component", "http-input"),
+		tls:          options.TLS,
+		backpressure: options.Backpressure,
 	}
 }
 
 func (h *HTTPInput) Start() error {
+	if err := h.schema.prepare(); err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("tcp", h.address)
 	if err != nil {
 		return err
@@ -117,7 +118,7 @@ func (h *HTTPInput) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	msgs, err := h.readMessages(req)
+	msgs, err := h.readMessages(req, sourceFromRequest(req))
 	if err != nil {
 		h.log.Errorf("Unable to read messages from HTTP request: %v", err)
 		writer.WriteHeader(http.StatusBadRequest)
@@ -153,7 +154,7 @@ func (h *HTTPInput) authenticate(writer http.ResponseWriter, req *http.Request)
 	return true
 }
 
-func (h *HTTPInput) readMessages(req *http.Request) ([]*gelf.Message, error) {
+func (h *HTTPInput) readMessages(req *http.Request, source messageSource) ([]*gelf.Message, error) {
 	var body io.Reader
 	var err error
 
@@ -171,10 +172,10 @@ func (h *HTTPInput) readMessages(req *http.Request) ([]*gelf.Message, error) {
 	}
 
 	// currently JSON is assumed: [{msg},{msg2}] and {msg}\n{msg2}
-	return h.parseJSON(body)
+	return h.parseJSON(body, source)
 }
 
-func (h *HTTPInput) parseJSON(br io.Reader) ([]*gelf.Message, error) {
+func (h *HTTPInput) parseJSON(br io.Reader, source messageSource) ([]*gelf.Message, error) {
 	body, err := ioutil.ReadAll(br)
 	if err != nil {
 		return nil, err
@@ -190,7 +191,7 @@ func (h *HTTPInput) parseJSON(br io.Reader) ([]*gelf.Message, error) {
 		switch t := value.Type(); t {
 		case fastjson.TypeObject:
 			obj, _ := value.Object()
-			msg, err := h.jsonObjectToMessage(obj)
+			msg, err := h.jsonObjectToMessage(obj, source)
 			if err != nil {
 				h.log.Warnf("Unable to create message from JSON object: %v", err)
 				continue
@@ -204,7 +205,7 @@ func (h *HTTPInput) parseJSON(br io.Reader) ([]*gelf.Message, error) {
 					h.log.Warnf("Expected object inside array: %v", err)
 					continue
 				}
-				msg, err := h.jsonObjectToMessage(obj)
+				msg, err := h.jsonObjectToMessage(obj, source)
 				if err != nil {
 					h.log.Warnf("Unable to create message from JSON object: %v", err)
 					continue
@@ -222,62 +223,87 @@ func (h *HTTPInput) parseJSON(br io.Reader) ([]*gelf.Message, error) {
 	return msgs, nil
 }
 
-func (h *HTTPInput) jsonObjectToMessage(obj *fastjson.Object) (*gelf.Message, error) {
+func (h *HTTPInput) jsonObjectToMessage(obj *fastjson.Object, source messageSource) (*gelf.Message, error) {
 	out := util.NewGelfMessage()
 
 	// short_message
-	msg, err := requireJsonString(obj.Get(h.messageField))
+	msg, err := requireJsonString(obj, h.schema.messageField)
+	if err != nil {
+		msg, err = h.schema.fallbackMessage(func() map[string]interface{} {
+			return jsonObjectToInterface(obj)
+		}, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error while setting short_message: %v", err)
 	}
-	obj.Del(h.messageField)
 	out.Short = msg
 
+	// full_message
+	if full, err := requireJsonString(obj, h.schema.fullField); err == nil {
+		out.Full = full
+	}
+	h.schema.truncateShort(out)
+
 	// host
-	host, err := requireJsonString(obj.Get(h.hostField))
+	host, err := requireJsonString(obj, h.schema.hostField)
+	if err != nil {
+		host, err = h.schema.fallbackHost(source, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error while setting host: %v", err)
 	}
-	obj.Del(h.hostField)
 	out.Host = host
 
 	// timestamp
-	tsRaw := obj.Get(h.timestampField)
+	tsRaw := lookupJsonField(obj, h.schema.timestampField)
 	if tsRaw != nil {
-		ts, err := jsonValueToUnixTimestamp(tsRaw)
+		ts, err := h.jsonValueToUnixTimestamp(tsRaw)
 		if err != nil {
 			h.log.Warnf("Unable to parse timestamp: %v", err)
 		} else {
 			out.TimeUnix = ts
+			h.schema.timestamp.applySkew(out, h.schema.name)
 		}
-
-		obj.Del(h.timestampField)
 	}
 
+	extra := h.schema.newExtraWriter(out)
 	obj.Visit(func(key []byte, v *fastjson.Value) {
-		processJsonExtra(out, string(key), v)
+		extra.processJsonExtra(string(key), v, 1)
 	})
+	extra.finish()
+
+	h.schema.enrich(out, source)
 
 	return out, nil
 }
 
-func jsonValueToUnixTimestamp(value *fastjson.Value) (float64, error) {
+func (h *HTTPInput) jsonValueToUnixTimestamp(value *fastjson.Value) (float64, error) {
 	switch t := value.Type(); t {
 	case fastjson.TypeString:
 		str, _ := value.StringBytes()
-		ts, err := time.Parse(time.RFC3339Nano, string(str))
-		if err != nil {
-			return 0, err
-		}
-		return float64(ts.UnixNano()) / float64(time.Second), nil
+		return h.schema.timestamp.parseString(string(str))
 	case fastjson.TypeNumber:
 		float, _ := value.Float64()
-		return float, nil
+		return h.schema.timestamp.parseNumber(float), nil
 	default:
 		return 0, fmt.Errorf("unexpected type: %v", t)
 	}
 }
 
+func jsonObjectToInterface(obj *fastjson.Object) map[string]interface{} {
+	out := make(map[string]interface{})
+
+	// numbers are kept as json.Number, since float64 loses precision of integers above 2^53
+	decoder := json.NewDecoder(strings.NewReader(obj.String()))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&out); err != nil {
+		return map[string]interface{}{}
+	}
+
+	return out
+}
+
 func jsonValueToString(value *fastjson.Value) string {
 	str, err := value.StringBytes()
 	if err != nil {
@@ -287,37 +313,85 @@ func jsonValueToString(value *fastjson.Value) string {
 	return string(str)
 }
 
-func requireJsonString(field *fastjson.Value) (string, error) {
-	if field == nil {
-		return "", fmt.Errorf("field doesn't exist")
+// lookupJsonField removes and returns value of the first existing field from the selector.
+func lookupJsonField(obj *fastjson.Object, selector fieldSelector) *fastjson.Value {
+	for _, path := range selector {
+		value, parent, key := path.lookupJson(obj)
+		if value != nil {
+			parent.Del(key)
+			return value
+		}
+	}
+
+	return nil
+}
+
+// requireJsonString removes and returns the first non-empty field from the selector.
+func requireJsonString(obj *fastjson.Object, selector fieldSelector) (string, error) {
+	found := false
+
+	for _, path := range selector {
+		value, parent, key := path.lookupJson(obj)
+		if value == nil {
+			continue
+		}
+		found = true
+
+		str := jsonValueToString(value)
+		if len(strings.TrimSpace(str)) == 0 {
+			continue
+		}
+
+		parent.Del(key)
+		return str, nil
 	}
 
-	str := jsonValueToString(field)
-	if len(strings.TrimSpace(str)) == 0 {
+	if found {
 		return "", fmt.Errorf("field is empty")
 	}
 
-	return str, nil
+	return "", fmt.Errorf("field doesn't exist")
 }
 
-func processJsonExtra(msg *gelf.Message, key string, value *fastjson.Value) {
+func (w *extraWriter) processJsonExtra(key string, value *fastjson.Value, depth int) {
 	switch value.Type() {
 	case fastjson.TypeNumber:
 		num, _ := value.Float64()
-		util.AppendExtraToGelf(msg, key, num)
+		w.append(key, num)
 	case fastjson.TypeObject:
+		if !w.nestingAllowed(depth) {
+			w.append(key, value.String())
+			return
+		}
+
 		obj, _ := value.Object()
 		obj.Visit(func(subk []byte, subv *fastjson.Value) {
-			newKey := key + "_" + string(subk)
-			processJsonExtra(msg, newKey, subv)
+			newKey := w.joinKey(key, string(subk))
+			w.processJsonExtra(newKey, subv, depth+1)
 		})
 	case fastjson.TypeArray:
 		arr, _ := value.Array()
-		for i, subv := range arr {
-			newKey := key + "_" + strconv.FormatInt(int64(i), 10)
-			processJsonExtra(msg, newKey, subv)
+
+		switch mode := w.arrayMode(); {
+		case mode == ArraysJSON || (mode == ArraysIndex && !w.nestingAllowed(depth)):
+			w.append(key, value.String())
+		case mode == ArraysJoin:
+			items := make([]string, len(arr))
+			for i, subv := range arr {
+				items[i] = jsonValueToString(subv)
+			}
+			w.append(key, strings.Join(items, w.schema.options.FlattenArrayDelimiter))
+		default:
+			for i, subv := range arr {
+				newKey := w.joinKey(key, strconv.FormatInt(int64(i), 10))
+				w.processJsonExtra(newKey, subv, depth+1)
+			}
 		}
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		w.appendBool(key, value.Type() == fastjson.TypeTrue)
+	case fastjson.TypeNull:
+		w.appendNull(key)
 	default:
-		util.AppendExtraToGelf(msg, key, jsonValueToString(value))
+		w.append(key, jsonValueToString(value))
 	}
 }
@@ -0,0 +1,76 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// parseEvent converts single JSON event the way HTTP input does.
+func parseEvent(schema SchemaOptions, event string) (*gelf.Message, error) {
+	options := NewHTTPInputOptions()
+	options.Schema = schema
+
+	input := NewHTTPInput(options)
+	if err := input.schema.prepare(); err != nil {
+		return nil, fmt.Errorf("prepare: %s", err)
+	}
+
+	msgs, err := input.parseJSON(strings.NewReader(event), messageSource{})
+	if err != nil {
+		return nil, fmt.Errorf("parseJSON: %s", err)
+	}
+
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("parseJSON: expected 1 message, got %d", len(msgs))
+	}
+
+	return msgs[0], nil
+}
+
+func TestFullMessageField(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FullMessageField: []string{"stack", "trace"}},
+		`{"message": "m", "host": "h", "trace": "at main()"}`)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if msg.Full != "at main()" {
+		t.Errorf("msg.Full: expected at main(), got %q", msg.Full)
+		return
+	}
+
+	if _, ok := msg.Extra["_trace"]; ok {
+		t.Errorf("expected full message field to be removed from additional fields")
+	}
+}
+
+func TestFullMessageTruncated(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{FullMessageField: []string{"trace"}, ShortMessageMaxBytes: 5},
+		`{"message": "first line\nsecond", "host": "h", "trace": "at main()"}`)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if msg.Short != "first" || msg.Full != "first line\nsecond\nat main()" {
+		t.Errorf("truncation: unexpected %q/%q", msg.Short, msg.Full)
+	}
+}
+
+// tests that large integers aren't rounded when the event is used as message
+func TestMissingMessageEventLargeNumber(t *testing.T) {
+	msg, err := parseEvent(SchemaOptions{MissingMessagePolicies: []string{MessagePolicyEvent}},
+		`{"host": "h", "id": 9007199254740993}`)
+	if err != nil {
+		t.Errorf("parseEvent: %s", err)
+		return
+	}
+
+	if !strings.Contains(msg.Short, `"id":9007199254740993`) {
+		t.Errorf("msg.Short: expected id to be kept, got %q", msg.Short)
+	}
+}
@@ -0,0 +1,259 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.uber.org/zap"
+)
+
+const (
+	HostPolicyStatic        = "static"
+	HostPolicyRemoteAddr    = "remote-addr"
+	HostPolicyTLSCommonName = "tls-cn"
+
+	MessagePolicyTemplate = "template"
+	MessagePolicyEvent    = "event"
+
+	TruncateFirstLine = "first-line"
+	TruncateBytes     = "bytes"
+)
+
+// SchemaOptions are options shared by all inputs controlling how events are converted into GELF messages.
+type SchemaOptions struct {
+	// MissingHostPolicies are tried in order when host field is missing or empty, message is rejected if none succeeds
+	MissingHostPolicies []string
+	DefaultHost         string
+	// MissingMessagePolicies are tried in order when message field is missing or empty, message is rejected if none succeeds
+	MissingMessagePolicies []string
+	MessageTemplate        string
+	// TimestampLayouts are Go layouts or strftime formats (when containing %) tried in order, RFC3339 is used if empty
+	TimestampLayouts []string
+	// TimestampUnit of numeric timestamps: auto, s, ms, us or ns
+	TimestampUnit string
+	// TimestampTimezone is used for layouts without zone information, UTC by default
+	TimestampTimezone string
+	// TimestampMaxSkew is maximum allowed difference from current time, enforced according to TimestampSkewPolicy
+	TimestampMaxSkew    time.Duration
+	TimestampSkewPolicy string
+	// FullMessageField is a list of paths tried in order for full_message
+	FullMessageField []string
+	// ShortMessageMaxBytes is maximum size of short_message, longer messages are truncated and moved to full_message
+	ShortMessageMaxBytes int
+	// ShortMessageTruncate mode: first-line keeps only first line (still limited to max bytes), bytes keeps max bytes
+	ShortMessageTruncate string
+	// FlattenSeparator joins keys of nested fields, "_" by default
+	FlattenSeparator string
+	// FlattenMaxDepth limits nesting of flattened fields, deeper objects and arrays are kept as JSON strings. Unlimited if 0
+	FlattenMaxDepth int
+	// FlattenArrays mode: index creates field per item, json keeps array as JSON string, join joins items with FlattenArrayDelimiter
+	FlattenArrays         string
+	FlattenArrayDelimiter string
+	// MaxExtraFields limits number of additional fields per message, number of dropped fields is reported in _dropped_fields. Unlimited if 0
+	MaxExtraFields int
+	// BoolMode controls how booleans are emitted: string ("true"/"false"), int (1/0) or native
+	BoolMode string
+	// NullMode controls how nulls are emitted: string ("null") or drop
+	NullMode string
+	// TimestampFormat of timestamps nested in additional fields: rfc3339, unix (seconds) or unix-ms
+	TimestampFormat string
+	// FieldTypes forces type (string or number) of additional fields, keyed by flattened field name
+	FieldTypes map[string]string
+	// Enrich adds static and templated fields to every message
+	Enrich util.EnrichOptions
+	// Metadata lists request metadata added to every message: input, remote-addr, tls-subject, http-path, connection-id
+	Metadata []string
+	// MetadataHeaders lists HTTP headers added to every message
+	MetadataHeaders []string
+}
+
+// messageSchema describes how incoming events are mapped onto GELF messages.
+type messageSchema struct {
+	name            string
+	messageField    fieldSelector
+	hostField       fieldSelector
+	timestampField  fieldSelector
+	fullField       fieldSelector
+	options         SchemaOptions
+	messageTemplate *template.Template
+	timestamp       *timestampParser
+	enricher        *util.Enricher
+	log             *zap.SugaredLogger
+}
+
+func newMessageSchema(name string, timestampField, messageField, hostField []string, options SchemaOptions) *messageSchema {
+	if options.FlattenSeparator == "" {
+		options.FlattenSeparator = "_"
+	}
+
+	return &messageSchema{
+		name:           name,
+		timestampField: newFieldSelector(timestampField),
+		messageField:   newFieldSelector(messageField),
+		hostField:      newFieldSelector(hostField),
+		fullField:      newFieldSelector(options.FullMessageField),
+		options:        options,
+		log:            zap.S().With("component", name+"-input"),
+	}
+}
+
+// prepare validates options, it needs to be called before the schema is used.
+func (s *messageSchema) prepare() error {
+	timestamp, err := newTimestampParser(s.options)
+	if err != nil {
+		return err
+	}
+	s.timestamp = timestamp
+
+	if err := s.validateFlattening(); err != nil {
+		return err
+	}
+
+	if err := validateMetadata(s.options.Metadata); err != nil {
+		return err
+	}
+
+	enricher, err := util.NewEnricher(s.options.Enrich)
+	if err != nil {
+		return err
+	}
+	s.enricher = enricher
+
+	switch s.options.ShortMessageTruncate {
+	case "", TruncateFirstLine, TruncateBytes:
+	default:
+		return fmt.Errorf("invalid short message truncate mode: %v", s.options.ShortMessageTruncate)
+	}
+
+	for _, policy := range s.options.MissingHostPolicies {
+		switch policy {
+		case HostPolicyStatic:
+			if strings.TrimSpace(s.options.DefaultHost) == "" {
+				return fmt.Errorf("default host needs to be provided for %v host policy", policy)
+			}
+		case HostPolicyRemoteAddr, HostPolicyTLSCommonName:
+		default:
+			return fmt.Errorf("invalid missing host policy: %v", policy)
+		}
+	}
+
+	for _, policy := range s.options.MissingMessagePolicies {
+		switch policy {
+		case MessagePolicyTemplate:
+			if strings.TrimSpace(s.options.MessageTemplate) == "" {
+				return fmt.Errorf("message template needs to be provided for %v message policy", policy)
+			}
+			// missing fields fail the template, so that the next policy is tried instead of rendering "<no value>"
+			tpl, err := template.New("message").Option("missingkey=error").Parse(s.options.MessageTemplate)
+			if err != nil {
+				return fmt.Errorf("invalid message template: %w", err)
+			}
+			s.messageTemplate = tpl
+		case MessagePolicyEvent:
+		default:
+			return fmt.Errorf("invalid missing message policy: %v", policy)
+		}
+	}
+
+	return nil
+}
+
+// fallbackHost tries configured policies in order, cause is returned if none of them succeeds.
+func (s *messageSchema) fallbackHost(source messageSource, cause error) (string, error) {
+	for _, policy := range s.options.MissingHostPolicies {
+		var host string
+
+		switch policy {
+		case HostPolicyStatic:
+			host = s.options.DefaultHost
+		case HostPolicyRemoteAddr:
+			host = source.remoteAddr
+		case HostPolicyTLSCommonName:
+			host = source.tlsCommonName
+		}
+
+		if strings.TrimSpace(host) != "" {
+			util.IncCounter(s.name + ".missing_host." + policy)
+			return host, nil
+		}
+	}
+
+	return "", cause
+}
+
+// fallbackMessage tries configured policies in order, cause is returned if none of them succeeds.
+// Event is only converted when one of the policies needs it.
+func (s *messageSchema) fallbackMessage(event func() map[string]interface{}, cause error) (string, error) {
+	var fields map[string]interface{}
+
+	for _, policy := range s.options.MissingMessagePolicies {
+		if fields == nil {
+			fields = event()
+		}
+
+		var msg string
+
+		switch policy {
+		case MessagePolicyTemplate:
+			var buf bytes.Buffer
+			if err := s.messageTemplate.Execute(&buf, fields); err != nil {
+				util.IncCounter(s.name + ".missing_message." + policy + "_error")
+				continue
+			}
+			msg = buf.String()
+		case MessagePolicyEvent:
+			encoded, err := json.Marshal(fields)
+			if err != nil {
+				util.IncCounter(s.name + ".missing_message." + policy + "_error")
+				continue
+			}
+			msg = string(encoded)
+		}
+
+		if strings.TrimSpace(msg) != "" {
+			util.IncCounter(s.name + ".missing_message." + policy)
+			return msg, nil
+		}
+	}
+
+	return "", cause
+}
+
+// enrich adds request metadata and configured fields to the message.
+func (s *messageSchema) enrich(msg *gelf.Message, source messageSource) {
+	s.appendMetadata(msg, source)
+	s.enricher.Apply(msg)
+}
+
+// truncateShort enforces maximum size of short_message, moving the whole text to full_message.
+func (s *messageSchema) truncateShort(msg *gelf.Message) {
+	limit := s.options.ShortMessageMaxBytes
+	if limit <= 0 || len(msg.Short) <= limit {
+		return
+	}
+
+	original := msg.Short
+	short := original
+
+	if s.options.ShortMessageTruncate != TruncateBytes {
+		if idx := strings.IndexByte(short, '\n'); idx > 0 {
+			short = strings.TrimRight(short[:idx], "\r")
+		}
+	}
+
+	msg.Short = util.TruncateUTF8(short, limit)
+	if msg.Full == "" {
+		msg.Full = original
+	} else {
+		msg.Full = original + "\n" + msg.Full
+	}
+
+	util.AppendExtraToGelf(msg, "truncated", int64(1))
+	util.IncCounter(s.name + ".short_message_truncated")
+}
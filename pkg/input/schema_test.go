@@ -0,0 +1,211 @@
+package input
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func TestFallbackHostPolicies(t *testing.T) {
+	schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{
+		MissingHostPolicies: []string{HostPolicyTLSCommonName, HostPolicyRemoteAddr, HostPolicyStatic},
+		DefaultHost:         "default",
+	})
+
+	cause := errors.New("field doesn't exist")
+
+	host, err := schema.fallbackHost(messageSource{remoteAddr: "10.0.0.1", tlsCommonName: "client"}, cause)
+	if err != nil || host != "client" {
+		t.Errorf("fallbackHost: expected client, got %s (%v)", host, err)
+		return
+	}
+
+	// policies are tried in order until one of them has a value
+	host, err = schema.fallbackHost(messageSource{remoteAddr: "10.0.0.1"}, cause)
+	if err != nil || host != "10.0.0.1" {
+		t.Errorf("fallbackHost: expected 10.0.0.1, got %s (%v)", host, err)
+		return
+	}
+
+	host, err = schema.fallbackHost(messageSource{}, cause)
+	if err != nil || host != "default" {
+		t.Errorf("fallbackHost: expected default, got %s (%v)", host, err)
+	}
+}
+
+func TestFallbackHostNone(t *testing.T) {
+	schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{
+		MissingHostPolicies: []string{HostPolicyTLSCommonName},
+	})
+
+	cause := errors.New("field doesn't exist")
+
+	if _, err := schema.fallbackHost(messageSource{remoteAddr: "10.0.0.1"}, cause); err != cause {
+		t.Errorf("fallbackHost: expected cause to be returned, got %v", err)
+	}
+}
+
+func TestFallbackMessageTemplate(t *testing.T) {
+	schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{
+		MissingMessagePolicies: []string{MessagePolicyTemplate, MessagePolicyEvent},
+		MessageTemplate:        "{{.user}} {{.action}}",
+	})
+	if err := schema.prepare(); err != nil {
+		t.Errorf("prepare: %s", err)
+		return
+	}
+
+	msg, err := schema.fallbackMessage(func() map[string]interface{} {
+		return map[string]interface{}{"user": "john", "action": "login"}
+	}, errors.New("field doesn't exist"))
+	if err != nil {
+		t.Errorf("fallbackMessage: %s", err)
+		return
+	}
+
+	if msg != "john login" {
+		t.Errorf("fallbackMessage: expected john login, got %s", msg)
+	}
+}
+
+// template referencing missing field fails, so the next policy is used instead of rendering "<no value>"
+func TestFallbackMessageTemplateMissingField(t *testing.T) {
+	schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{
+		MissingMessagePolicies: []string{MessagePolicyTemplate, MessagePolicyEvent},
+		MessageTemplate:        "{{.user}} {{.missing}}",
+	})
+	if err := schema.prepare(); err != nil {
+		t.Errorf("prepare: %s", err)
+		return
+	}
+
+	msg, err := schema.fallbackMessage(func() map[string]interface{} {
+		return map[string]interface{}{"user": "john", "action": "login"}
+	}, errors.New("field doesn't exist"))
+	if err != nil {
+		t.Errorf("fallbackMessage: %s", err)
+		return
+	}
+
+	if msg != `{"action":"login","user":"john"}` {
+		t.Errorf("fallbackMessage: expected event as JSON, got %s", msg)
+	}
+}
+
+func TestFallbackMessageNone(t *testing.T) {
+	schema := newMessageSchema("test", nil, nil, nil, SchemaOptions{})
+	if err := schema.prepare(); err != nil {
+		t.Errorf("prepare: %s", err)
+		return
+	}
+
+	cause := errors.New("field doesn't exist")
+	converted := false
+
+	_, err := schema.fallbackMessage(func() map[string]interface{} {
+		converted = true
+		return nil
+	}, cause)
+
+	if err != cause {
+		t.Errorf("fallbackMessage: expected cause to be returned, got %v", err)
+	}
+
+	if converted {
+		t.Errorf("fallbackMessage: event converted without any policy")
+	}
+}
+
+func TestPrepareInvalidPolicies(t *testing.T) {
+	invalid := map[string]SchemaOptions{
+		"static host without default":      {MissingHostPolicies: []string{HostPolicyStatic}, DefaultHost: " "},
+		"unknown host policy":              {MissingHostPolicies: []string{"dns"}},
+		"template policy without template": {MissingMessagePolicies: []string{MessagePolicyTemplate}},
+		"invalid template":                 {MissingMessagePolicies: []string{MessagePolicyTemplate}, MessageTemplate: "{{"},
+		"unknown message policy":           {MissingMessagePolicies: []string{"empty"}},
+	}
+
+	for name, options := range invalid {
+		if err := newMessageSchema("test", nil, nil, nil, options).prepare(); err == nil {
+			t.Errorf("prepare: expected error for %s", name)
+		}
+	}
+}
+
+func newTruncatingSchema(maxBytes int, mode string) *messageSchema {
+	return newMessageSchema("test", nil, nil, nil, SchemaOptions{
+		ShortMessageMaxBytes: maxBytes,
+		ShortMessageTruncate: mode,
+	})
+}
+
+func TestTruncateShortFirstLine(t *testing.T) {
+	msg := &gelf.Message{Short: "line1\r\nline2", Extra: map[string]interface{}{}}
+	newTruncatingSchema(8, TruncateFirstLine).truncateShort(msg)
+
+	if msg.Short != "line1" {
+		t.Errorf("msg.Short: expected line1, got %q", msg.Short)
+		return
+	}
+
+	if msg.Full != "line1\r\nline2" {
+		t.Errorf("msg.Full: expected whole message, got %q", msg.Full)
+		return
+	}
+
+	if msg.Extra["_truncated"] != int64(1) {
+		t.Errorf("expected _truncated to be set, got %v", msg.Extra)
+	}
+}
+
+// first line is still limited to max bytes
+func TestTruncateShortLongFirstLine(t *testing.T) {
+	msg := &gelf.Message{Short: "line1\nline2", Extra: map[string]interface{}{}}
+	newTruncatingSchema(4, "").truncateShort(msg)
+
+	if msg.Short != "line" || msg.Full != "line1\nline2" {
+		t.Errorf("truncateShort: expected line/line1\\nline2, got %q/%q", msg.Short, msg.Full)
+	}
+}
+
+func TestTruncateShortBytes(t *testing.T) {
+	msg := &gelf.Message{Short: "line1\nline2", Full: "trace", Extra: map[string]interface{}{}}
+	newTruncatingSchema(8, TruncateBytes).truncateShort(msg)
+
+	if msg.Short != "line1\nli" {
+		t.Errorf("msg.Short: expected line1\\nli, got %q", msg.Short)
+		return
+	}
+
+	// existing full message is kept after the original short message
+	if msg.Full != "line1\nline2\ntrace" {
+		t.Errorf("msg.Full: expected original short message before trace, got %q", msg.Full)
+	}
+}
+
+func TestTruncateShortUTF8(t *testing.T) {
+	msg := &gelf.Message{Short: "zażółć", Extra: map[string]interface{}{}}
+	newTruncatingSchema(5, TruncateBytes).truncateShort(msg)
+
+	if msg.Short != "zaż" {
+		t.Errorf("msg.Short: expected zaż, got %q", msg.Short)
+	}
+}
+
+func TestTruncateShortFits(t *testing.T) {
+	for _, maxBytes := range []int{0, 11, 100} {
+		msg := &gelf.Message{Short: "line1\nline2", Extra: map[string]interface{}{}}
+		newTruncatingSchema(maxBytes, TruncateFirstLine).truncateShort(msg)
+
+		if msg.Short != "line1\nline2" || msg.Full != "" || len(msg.Extra) != 0 {
+			t.Errorf("truncateShort %d: expected message to be left intact, got %q/%q", maxBytes, msg.Short, msg.Full)
+		}
+	}
+}
+
+func TestPrepareInvalidTruncateMode(t *testing.T) {
+	if err := newTruncatingSchema(10, "words").prepare(); err == nil {
+		t.Errorf("prepare: expected error for invalid truncate mode")
+	}
+}
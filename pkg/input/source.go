@@ -0,0 +1,133 @@
+package input
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	MetadataInput        = "input"
+	MetadataRemoteAddr   = "remote-addr"
+	MetadataTLSSubject   = "tls-subject"
+	MetadataHTTPPath     = "http-path"
+	MetadataConnectionID = "connection-id"
+)
+
+// messageSource describes the client that sent an event.
+type messageSource struct {
+	remoteAddr    string
+	tlsCommonName string
+	tlsSubject    string
+	httpPath      string
+	httpHeaders   http.Header
+	connectionID  string
+}
+
+func sourceFromConn(conn net.Conn, id int) messageSource {
+	source := messageSource{
+		remoteAddr:   remoteHost(conn.RemoteAddr().String()),
+		connectionID: strconv.Itoa(id),
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		source.tlsCommonName = util.PeerCommonName(&state)
+		source.tlsSubject = util.PeerSubject(&state)
+	}
+
+	return source
+}
+
+func sourceFromRequest(req *http.Request) messageSource {
+	return messageSource{
+		remoteAddr:    remoteHost(req.RemoteAddr),
+		tlsCommonName: util.PeerCommonName(req.TLS),
+		tlsSubject:    util.PeerSubject(req.TLS),
+		httpPath:      req.URL.Path,
+		httpHeaders:   req.Header,
+	}
+}
+
+func sourceFromContext(ctx context.Context) messageSource {
+	var source messageSource
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return source
+	}
+
+	if p.Addr != nil {
+		source.remoteAddr = remoteHost(p.Addr.String())
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		source.tlsCommonName = util.PeerCommonName(&info.State)
+		source.tlsSubject = util.PeerSubject(&info.State)
+	}
+
+	return source
+}
+
+func validateMetadata(metadata []string) error {
+	for _, name := range metadata {
+		switch name {
+		case MetadataInput, MetadataRemoteAddr, MetadataTLSSubject, MetadataHTTPPath, MetadataConnectionID:
+		default:
+			return fmt.Errorf("invalid metadata field: %v", name)
+		}
+	}
+
+	return nil
+}
+
+// appendMetadata adds configured request metadata to the message, metadata not available for the input is skipped.
+func (s *messageSchema) appendMetadata(msg *gelf.Message, source messageSource) {
+	for _, name := range s.options.Metadata {
+		var value string
+
+		switch name {
+		case MetadataInput:
+			value = s.name
+		case MetadataRemoteAddr:
+			value = source.remoteAddr
+		case MetadataTLSSubject:
+			value = source.tlsSubject
+		case MetadataHTTPPath:
+			value = source.httpPath
+		case MetadataConnectionID:
+			value = source.connectionID
+		}
+
+		if value != "" {
+			util.AppendExtraToGelf(msg, strings.ReplaceAll(name, "-", "_"), value)
+		}
+	}
+
+	if source.httpHeaders == nil {
+		return
+	}
+
+	for _, header := range s.options.MetadataHeaders {
+		if value := source.httpHeaders.Get(header); value != "" {
+			util.AppendExtraToGelf(msg, "http_header_"+strings.ToLower(header), value)
+		}
+	}
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,73 @@
+package input
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAppendMetadata(t *testing.T) {
+	options := NewHTTPInputOptions()
+	options.Schema.Metadata = []string{MetadataInput, MetadataRemoteAddr, MetadataTLSSubject, MetadataHTTPPath, MetadataConnectionID}
+	options.Schema.MetadataHeaders = []string{"X-Request-Id", "X-Missing"}
+
+	input := NewHTTPInput(options)
+	if err := input.schema.prepare(); err != nil {
+		t.Errorf("prepare: %s", err)
+		return
+	}
+
+	source := messageSource{
+		remoteAddr:  "10.0.0.1",
+		httpPath:    "/logs",
+		httpHeaders: http.Header{"X-Request-Id": []string{"abc"}},
+	}
+
+	msgs, err := input.parseJSON(strings.NewReader(`{"message": "m", "host": "h"}`), source)
+	if err != nil {
+		t.Errorf("parseJSON: %s", err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"_input":                    "http",
+		"_remote_addr":              "10.0.0.1",
+		"_http_path":                "/logs",
+		"_http_header_x_request_id": "abc",
+	}
+
+	if len(msgs[0].Extra) != len(expected) {
+		t.Errorf("appendMetadata: expected %v, got %v", expected, msgs[0].Extra)
+		return
+	}
+
+	for k, v := range expected {
+		if msgs[0].Extra[k] != v {
+			t.Errorf("appendMetadata %s: expected %v, got %v", k, v, msgs[0].Extra[k])
+		}
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	addrs := map[string]string{
+		"10.0.0.1:1234": "10.0.0.1",
+		"[::1]:1234":    "::1",
+		"@":             "@",
+	}
+
+	for addr, expected := range addrs {
+		if got := remoteHost(addr); got != expected {
+			t.Errorf("remoteHost %s: expected %s, got %s", addr, expected, got)
+		}
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := validateMetadata([]string{MetadataInput, MetadataHTTPPath}); err != nil {
+		t.Errorf("validateMetadata: %s", err)
+	}
+
+	if err := validateMetadata([]string{"user-agent"}); err == nil {
+		t.Errorf("validateMetadata: expected error for invalid field")
+	}
+}
@@ -0,0 +1,262 @@
+package input
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const (
+	TimestampUnitAuto         = "auto"
+	TimestampUnitSeconds      = "s"
+	TimestampUnitMilliseconds = "ms"
+	TimestampUnitMicroseconds = "us"
+	TimestampUnitNanoseconds  = "ns"
+
+	SkewPolicyClamp = "clamp"
+	SkewPolicyFlag  = "flag"
+)
+
+// dayOfYearMarker stands for %j in converted layouts, Go layouts support day of year only since Go 1.20
+const dayOfYearMarker = "\x00j"
+
+// strftime directives and their Go layout equivalents
+var strftimeDirectives = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'e': "_2",
+	'f': "000000",
+	'F': "2006-01-02",
+	'H': "15",
+	'I': "03",
+	'j': dayOfYearMarker,
+	'L': "000",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// timestampParser converts timestamp fields of various formats into unix time.
+type timestampParser struct {
+	layouts    []string
+	unit       string
+	location   *time.Location
+	maxSkew    time.Duration
+	skewPolicy string
+}
+
+func newTimestampParser(options SchemaOptions) (*timestampParser, error) {
+	parser := &timestampParser{
+		unit:       options.TimestampUnit,
+		location:   time.UTC,
+		maxSkew:    options.TimestampMaxSkew,
+		skewPolicy: options.TimestampSkewPolicy,
+	}
+
+	for _, layout := range options.TimestampLayouts {
+		if strings.Contains(layout, "%") {
+			converted, err := strftimeToLayout(layout)
+			if err != nil {
+				return nil, err
+			}
+			layout = converted
+		}
+
+		parser.layouts = append(parser.layouts, layout)
+	}
+	if len(parser.layouts) == 0 {
+		parser.layouts = []string{time.RFC3339Nano}
+	}
+
+	switch parser.unit {
+	case "":
+		parser.unit = TimestampUnitAuto
+	case TimestampUnitAuto, TimestampUnitSeconds, TimestampUnitMilliseconds, TimestampUnitMicroseconds, TimestampUnitNanoseconds:
+	default:
+		return nil, fmt.Errorf("invalid timestamp unit: %v", parser.unit)
+	}
+
+	switch parser.skewPolicy {
+	case "":
+		parser.skewPolicy = SkewPolicyClamp
+	case SkewPolicyClamp, SkewPolicyFlag:
+	default:
+		return nil, fmt.Errorf("invalid timestamp skew policy: %v", parser.skewPolicy)
+	}
+
+	if options.TimestampTimezone != "" {
+		location, err := time.LoadLocation(options.TimestampTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp timezone: %w", err)
+		}
+		parser.location = location
+	}
+
+	return parser, nil
+}
+
+// parseString tries all layouts in order, falling back to numeric epoch.
+func (p *timestampParser) parseString(str string) (float64, error) {
+	str = strings.TrimSpace(str)
+
+	for _, layout := range p.layouts {
+		parse := time.ParseInLocation
+		if strings.Contains(layout, dayOfYearMarker) {
+			parse = parseDayOfYear
+		}
+
+		ts, err := parse(layout, str, p.location)
+		if err == nil {
+			return timeToUnix(ts), nil
+		}
+	}
+
+	if num, err := strconv.ParseFloat(str, 64); err == nil {
+		return p.parseNumber(num), nil
+	}
+
+	return 0, fmt.Errorf("%q doesn't match any of the timestamp layouts", str)
+}
+
+// parseNumber converts epoch in configured unit into unix time.
+func (p *timestampParser) parseNumber(num float64) float64 {
+	unit := p.unit
+	if unit == TimestampUnitAuto {
+		unit = detectEpochUnit(num)
+	}
+
+	switch unit {
+	case TimestampUnitMilliseconds:
+		return num / 1e3
+	case TimestampUnitMicroseconds:
+		return num / 1e6
+	case TimestampUnitNanoseconds:
+		return num / 1e9
+	default:
+		return num
+	}
+}
+
+// applySkew enforces maximum skew policy on timestamp of the message.
+func (p *timestampParser) applySkew(msg *gelf.Message, name string) {
+	if p.maxSkew <= 0 {
+		return
+	}
+
+	now := timeToUnix(time.Now())
+	skew := msg.TimeUnix - now
+	limit := p.maxSkew.Seconds()
+
+	if math.Abs(skew) <= limit {
+		return
+	}
+
+	util.IncCounter(name + ".timestamp_skewed")
+
+	switch p.skewPolicy {
+	case SkewPolicyClamp:
+		msg.TimeUnix = now + math.Copysign(limit, skew)
+	case SkewPolicyFlag:
+		util.AppendExtraToGelf(msg, "timestamp_skew", skew)
+	}
+}
+
+// detectEpochUnit guesses unit based on magnitude, seconds cover dates up to year 5138.
+func detectEpochUnit(num float64) string {
+	abs := math.Abs(num)
+
+	switch {
+	case abs < 1e11:
+		return TimestampUnitSeconds
+	case abs < 1e14:
+		return TimestampUnitMilliseconds
+	case abs < 1e17:
+		return TimestampUnitMicroseconds
+	default:
+		return TimestampUnitNanoseconds
+	}
+}
+
+func timeToUnix(ts time.Time) float64 {
+	return float64(ts.UnixNano()) / float64(time.Second)
+}
+
+// parseDayOfYear parses layout containing dayOfYearMarker. Three digits matching the marker are found by parsing
+// the parts of the layout around it, then they are replaced by January 1 and the day is added to the parsed date.
+func parseDayOfYear(layout, str string, location *time.Location) (time.Time, error) {
+	marker := strings.Index(layout, dayOfYearMarker)
+	before, after := layout[:marker], layout[marker+len(dayOfYearMarker):]
+
+	for i := 0; i+3 <= len(str); i++ {
+		day, err := strconv.Atoi(str[i : i+3])
+		if err != nil || str[i] == '+' || str[i] == '-' {
+			continue
+		}
+
+		if _, err := time.Parse(before, str[:i]); err != nil {
+			continue
+		}
+		if _, err := time.Parse(after, str[i+3:]); err != nil {
+			continue
+		}
+
+		ts, err := time.ParseInLocation(before+"0102"+after, str[:i]+"0101"+str[i+3:], location)
+		if err != nil {
+			continue
+		}
+
+		if dated := ts.AddDate(0, 0, day-1); day > 0 && dated.Year() == ts.Year() {
+			return dated, nil
+		}
+		return time.Time{}, fmt.Errorf("day of year out of range: %v", day)
+	}
+
+	return time.Time{}, fmt.Errorf("%q doesn't match layout with day of year", str)
+}
+
+// strftimeToLayout converts strftime format into Go layout.
+func strftimeToLayout(format string) (string, error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("dangling %% in timestamp format %q", format)
+		}
+		i++
+
+		// %:z
+		if format[i] == ':' && i+1 < len(format) && format[i+1] == 'z' {
+			sb.WriteString("-07:00")
+			i++
+			continue
+		}
+
+		layout, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported directive %%%c in timestamp format %q", format[i], format)
+		}
+		sb.WriteString(layout)
+	}
+
+	return sb.String(), nil
+}
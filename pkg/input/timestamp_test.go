@@ -0,0 +1,233 @@
+package input
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// 2020-09-13T12:26:40Z
+const testEpoch = 1600000000
+
+func newTestTimestampParser(t *testing.T, options SchemaOptions) *timestampParser {
+	t.Helper()
+
+	parser, err := newTimestampParser(options)
+	if err != nil {
+		t.Fatalf("newTimestampParser: %s", err)
+	}
+
+	return parser
+}
+
+func TestParseTimestampRFC3339(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{})
+
+	for _, str := range []string{"2020-09-13T12:26:40Z", "2020-09-13T14:26:40+02:00", " 2020-09-13T12:26:40.000Z\n"} {
+		ts, err := parser.parseString(str)
+		if err != nil {
+			t.Errorf("parseString %s: %s", str, err)
+			continue
+		}
+
+		if ts != testEpoch {
+			t.Errorf("parseString %s: expected %d, got %f", str, testEpoch, ts)
+		}
+	}
+}
+
+func TestParseTimestampLayouts(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{
+		TimestampLayouts: []string{"2006-01-02 15:04:05", "%d/%b/%Y:%H:%M:%S %z", "%FT%T%:z"},
+	})
+
+	// layouts are tried in order
+	for _, str := range []string{"2020-09-13 12:26:40", "13/Sep/2020:14:26:40 +0200", "2020-09-13T14:26:40+02:00"} {
+		ts, err := parser.parseString(str)
+		if err != nil {
+			t.Errorf("parseString %s: %s", str, err)
+			continue
+		}
+
+		if ts != testEpoch {
+			t.Errorf("parseString %s: expected %d, got %f", str, testEpoch, ts)
+		}
+	}
+
+	if _, err := parser.parseString("yesterday"); err == nil {
+		t.Errorf("parseString: expected error for yesterday")
+	}
+}
+
+// tests %j, which Go layouts support only since Go 1.20
+func TestParseTimestampDayOfYear(t *testing.T) {
+	formats := map[string]string{
+		"%Y%j%H%M%S": "2020257122640",
+		"%Y-%j %T":   "2020-257 12:26:40",
+		"%j/%Y %T":   "257/2020 12:26:40",
+	}
+
+	for format, str := range formats {
+		parser := newTestTimestampParser(t, SchemaOptions{TimestampLayouts: []string{format}})
+
+		ts, err := parser.parseString(str)
+		if err != nil {
+			t.Errorf("parseString %s: %s", format, err)
+			continue
+		}
+
+		if ts != testEpoch {
+			t.Errorf("parseString %s: expected %d, got %f", format, testEpoch, ts)
+		}
+	}
+
+	parser := newTestTimestampParser(t, SchemaOptions{TimestampLayouts: []string{"%Y-%j %T"}})
+	for _, str := range []string{"2019-366 12:26:40", "2020-000 12:26:40", "2020-25 12:26:40"} {
+		if _, err := parser.parseString(str); err == nil {
+			t.Errorf("parseString %s: expected error", str)
+		}
+	}
+}
+
+func TestParseTimestampTimezone(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{
+		TimestampLayouts:  []string{"2006-01-02 15:04:05"},
+		TimestampTimezone: "Europe/Warsaw",
+	})
+
+	ts, err := parser.parseString("2020-09-13 14:26:40")
+	if err != nil {
+		t.Errorf("parseString: %s", err)
+		return
+	}
+
+	if ts != testEpoch {
+		t.Errorf("parseString: expected %d, got %f", testEpoch, ts)
+	}
+}
+
+// numeric strings are epochs, unit is guessed from magnitude
+func TestParseTimestampNumericString(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{})
+
+	ts, err := parser.parseString("1600000000500")
+	if err != nil {
+		t.Errorf("parseString: %s", err)
+		return
+	}
+
+	if ts != testEpoch+0.5 {
+		t.Errorf("parseString: expected %f, got %f", testEpoch+0.5, ts)
+	}
+}
+
+func TestParseTimestampAutoUnit(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{TimestampUnit: TimestampUnitAuto})
+
+	for _, num := range []float64{1600000000.25, 1600000000250, 1600000000250000, 1600000000250000000} {
+		if ts := parser.parseNumber(num); math.Abs(ts-(testEpoch+0.25)) > 1e-3 {
+			t.Errorf("parseNumber %f: expected %f, got %f", num, testEpoch+0.25, ts)
+		}
+	}
+}
+
+func TestParseTimestampUnit(t *testing.T) {
+	units := map[string]float64{
+		TimestampUnitSeconds:      1500,
+		TimestampUnitMilliseconds: 1.5,
+		TimestampUnitMicroseconds: 0.0015,
+		TimestampUnitNanoseconds:  0.0000015,
+	}
+
+	for unit, expected := range units {
+		parser := newTestTimestampParser(t, SchemaOptions{TimestampUnit: unit})
+
+		if ts := parser.parseNumber(1500); math.Abs(ts-expected) > 1e-9 {
+			t.Errorf("parseNumber %s: expected %f, got %f", unit, expected, ts)
+		}
+	}
+}
+
+func TestTimestampSkewClamp(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{TimestampMaxSkew: time.Hour})
+
+	now := timeToUnix(time.Now())
+
+	msg := &gelf.Message{TimeUnix: now + 7200, Extra: map[string]interface{}{}}
+	parser.applySkew(msg, "test")
+	if math.Abs(msg.TimeUnix-(now+3600)) > 5 {
+		t.Errorf("applySkew: expected future timestamp clamped to %f, got %f", now+3600, msg.TimeUnix)
+	}
+
+	msg = &gelf.Message{TimeUnix: now - 7200, Extra: map[string]interface{}{}}
+	parser.applySkew(msg, "test")
+	if math.Abs(msg.TimeUnix-(now-3600)) > 5 {
+		t.Errorf("applySkew: expected past timestamp clamped to %f, got %f", now-3600, msg.TimeUnix)
+	}
+
+	msg = &gelf.Message{TimeUnix: now + 60, Extra: map[string]interface{}{}}
+	parser.applySkew(msg, "test")
+	if msg.TimeUnix != now+60 {
+		t.Errorf("applySkew: expected timestamp within limit to be kept, got %f", msg.TimeUnix)
+	}
+}
+
+func TestTimestampSkewFlag(t *testing.T) {
+	parser := newTestTimestampParser(t, SchemaOptions{TimestampMaxSkew: time.Hour, TimestampSkewPolicy: SkewPolicyFlag})
+
+	ts := timeToUnix(time.Now()) + 7200
+	msg := &gelf.Message{TimeUnix: ts, Extra: map[string]interface{}{}}
+	parser.applySkew(msg, "test")
+
+	if msg.TimeUnix != ts {
+		t.Errorf("applySkew: expected timestamp to be kept, got %f", msg.TimeUnix)
+	}
+
+	if skew, ok := msg.Extra["_timestamp_skew"].(float64); !ok || math.Abs(skew-7200) > 5 {
+		t.Errorf("applySkew: expected _timestamp_skew of 7200, got %v", msg.Extra["_timestamp_skew"])
+	}
+}
+
+func TestStrftimeToLayout(t *testing.T) {
+	formats := map[string]string{
+		"%Y-%m-%d %H:%M:%S": "2006-01-02 15:04:05",
+		"%d/%b/%Y:%T %z":    "02/Jan/2006:15:04:05 -0700",
+		"%FT%T.%f%:z":       "2006-01-02T15:04:05.000000-07:00",
+		"%a %e %I %p 100%%": "Mon _2 03 PM 100%",
+	}
+
+	for format, expected := range formats {
+		layout, err := strftimeToLayout(format)
+		if err != nil {
+			t.Errorf("strftimeToLayout %s: %s", format, err)
+			continue
+		}
+
+		if layout != expected {
+			t.Errorf("strftimeToLayout %s: expected %s, got %s", format, expected, layout)
+		}
+	}
+
+	for _, format := range []string{"%Q", "%Y%"} {
+		if _, err := strftimeToLayout(format); err == nil {
+			t.Errorf("strftimeToLayout %s: expected error", format)
+		}
+	}
+}
+
+func TestNewTimestampParserInvalid(t *testing.T) {
+	invalid := map[string]SchemaOptions{
+		"unit":        {TimestampUnit: "min"},
+		"skew policy": {TimestampSkewPolicy: "drop"},
+		"timezone":    {TimestampTimezone: "Mars/Olympus"},
+		"strftime":    {TimestampLayouts: []string{"%Q"}},
+	}
+
+	for name, options := range invalid {
+		if _, err := newTimestampParser(options); err == nil {
+			t.Errorf("newTimestampParser: expected error for invalid %s", name)
+		}
+	}
+}
@@ -1,22 +1,18 @@
 package input
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/eplightning/gelf-forwarder/pkg/util"
 	vector "github.com/eplightning/gelf-forwarder/pkg/vector/event"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-type vectorSchema struct {
-	messageField   string
-	hostField      string
-	timestampField string
-}
-
-func (v *vectorSchema) eventToGelf(wrapper *vector.EventWrapper) (*gelf.Message, error) {
+func (s *messageSchema) eventToGelf(wrapper *vector.EventWrapper, source messageSource) (*gelf.Message, error) {
 	log := wrapper.GetLog()
 	if log == nil {
 		return nil, fmt.Errorf("metrics are not supported")
@@ -25,71 +21,165 @@ func (v *vectorSchema) eventToGelf(wrapper *vector.EventWrapper) (*gelf.Message,
 	out := util.NewGelfMessage()
 
 	// short_message
-	msg, err := requireString(log.Fields[v.messageField])
+	msg, err := requireString(log.Fields, s.messageField)
+	if err != nil {
+		msg, err = s.fallbackMessage(func() map[string]interface{} {
+			return vectorFieldsToInterface(log.Fields)
+		}, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error while setting short_message: %v", err)
 	}
-	delete(log.Fields, v.messageField)
 	out.Short = msg
 
+	// full_message
+	if full, err := requireString(log.Fields, s.fullField); err == nil {
+		out.Full = full
+	}
+	s.truncateShort(out)
+
 	// host
-	host, err := requireString(log.Fields[v.hostField])
+	host, err := requireString(log.Fields, s.hostField)
+	if err != nil {
+		host, err = s.fallbackHost(source, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error while setting host: %v", err)
 	}
-	delete(log.Fields, v.hostField)
 	out.Host = host
 
 	// timestamp
-	tsRaw, exists := log.Fields[v.timestampField]
-	if exists {
-		delete(log.Fields, v.timestampField)
-
-		ts := tsRaw.GetTimestamp()
-		if ts != nil {
-			out.TimeUnix = float64(ts.AsTime().UnixNano()) / float64(time.Second)
+	tsRaw := lookupVectorField(log.Fields, s.timestampField)
+	if tsRaw != nil {
+		ts, err := s.vectorValueToUnixTimestamp(tsRaw)
+		if err != nil {
+			s.log.Warnf("Unable to parse timestamp: %v", err)
+		} else {
+			out.TimeUnix = ts
+			s.timestamp.applySkew(out, s.name)
 		}
 	}
 
-	for k, v := range log.GetFields() {
-		processExtra(out, k, v)
+	extra := s.newExtraWriter(out)
+	fields := log.GetFields()
+	for _, k := range sortedVectorKeys(fields) {
+		extra.processExtra(k, fields[k], 1)
 	}
+	extra.finish()
+
+	s.enrich(out, source)
 
 	return out, nil
 }
 
-func requireString(field *vector.Value) (string, error) {
-	if field == nil {
-		return "", fmt.Errorf("field doesn't exist")
+func (s *messageSchema) vectorValueToUnixTimestamp(value *vector.Value) (float64, error) {
+	switch casted := value.Kind.(type) {
+	case *vector.Value_Timestamp:
+		return timeToUnix(casted.Timestamp.AsTime()), nil
+	case *vector.Value_RawBytes:
+		return s.timestamp.parseString(string(casted.RawBytes))
+	case *vector.Value_Integer:
+		return s.timestamp.parseNumber(float64(casted.Integer)), nil
+	case *vector.Value_Float:
+		return s.timestamp.parseNumber(casted.Float), nil
+	default:
+		return 0, fmt.Errorf("unexpected type: %T", casted)
+	}
+}
+
+// lookupVectorField removes and returns value of the first existing field from the selector.
+func lookupVectorField(fields map[string]*vector.Value, selector fieldSelector) *vector.Value {
+	for _, path := range selector {
+		value, parent, key := path.lookupVector(fields)
+		if value != nil {
+			delete(parent, key)
+			return value
+		}
+	}
+
+	return nil
+}
+
+// requireString removes and returns the first non-empty field from the selector.
+func requireString(fields map[string]*vector.Value, selector fieldSelector) (string, error) {
+	found := false
+
+	for _, path := range selector {
+		value, parent, key := path.lookupVector(fields)
+		if value == nil {
+			continue
+		}
+		found = true
+
+		str := vectorValueToString(value)
+		if len(strings.TrimSpace(str)) == 0 {
+			continue
+		}
+
+		delete(parent, key)
+		return str, nil
 	}
 
-	str := vectorValueToString(field)
-	if len(strings.TrimSpace(str)) == 0 {
+	if found {
 		return "", fmt.Errorf("field is empty")
 	}
 
-	return str, nil
+	return "", fmt.Errorf("field doesn't exist")
 }
 
-func processExtra(msg *gelf.Message, key string, value *vector.Value) {
+func (w *extraWriter) processExtra(key string, value *vector.Value, depth int) {
 	switch casted := value.Kind.(type) {
 	case *vector.Value_Integer:
-		util.AppendExtraToGelf(msg, key, casted.Integer)
+		w.append(key, casted.Integer)
 	case *vector.Value_Float:
-		util.AppendExtraToGelf(msg, key, casted.Float)
+		w.append(key, casted.Float)
 	case *vector.Value_Map:
-		for subk, subv := range casted.Map.Fields {
-			newKey := key + "_" + subk
-			processExtra(msg, newKey, subv)
+		if !w.nestingAllowed(depth) {
+			w.append(key, vectorValueToJson(value))
+			return
+		}
+
+		for _, subk := range sortedVectorKeys(casted.Map.Fields) {
+			newKey := w.joinKey(key, subk)
+			w.processExtra(newKey, casted.Map.Fields[subk], depth+1)
 		}
 	case *vector.Value_Array:
-		for i, subv := range casted.Array.Items {
-			newKey := key + "_" + strconv.FormatInt(int64(i), 10)
-			processExtra(msg, newKey, subv)
+		switch mode := w.arrayMode(); {
+		case mode == ArraysJSON || (mode == ArraysIndex && !w.nestingAllowed(depth)):
+			w.append(key, vectorValueToJson(value))
+		case mode == ArraysJoin:
+			items := make([]string, len(casted.Array.Items))
+			for i, subv := range casted.Array.Items {
+				items[i] = vectorValueToString(subv)
+			}
+			w.append(key, strings.Join(items, w.schema.options.FlattenArrayDelimiter))
+		default:
+			for i, subv := range casted.Array.Items {
+				newKey := w.joinKey(key, strconv.FormatInt(int64(i), 10))
+				w.processExtra(newKey, subv, depth+1)
+			}
 		}
+	case *vector.Value_Boolean:
+		w.appendBool(key, casted.Boolean)
+	case *vector.Value_Null:
+		w.appendNull(key)
+	case *vector.Value_Timestamp:
+		w.appendTime(key, casted.Timestamp.AsTime())
 	default:
-		util.AppendExtraToGelf(msg, key, vectorValueToString(value))
+		w.append(key, vectorValueToString(value))
+	}
+}
+
+// sortedVectorKeys returns keys of fields in sorted order, so that fields dropped due to the limit
+// and key collisions don't depend on random map order.
+func sortedVectorKeys(fields map[string]*vector.Value) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+
+	return keys
 }
 
 func vectorValueToString(value *vector.Value) string {
@@ -110,3 +200,47 @@ func vectorValueToString(value *vector.Value) string {
 		return value.String()
 	}
 }
+
+func vectorValueToJson(value *vector.Value) string {
+	encoded, err := json.Marshal(vectorValueToInterface(value))
+	if err != nil {
+		return vectorValueToString(value)
+	}
+
+	return string(encoded)
+}
+
+func vectorFieldsToInterface(fields map[string]*vector.Value) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+
+	for k, v := range fields {
+		out[k] = vectorValueToInterface(v)
+	}
+
+	return out
+}
+
+func vectorValueToInterface(value *vector.Value) interface{} {
+	switch casted := value.Kind.(type) {
+	case *vector.Value_RawBytes:
+		return string(casted.RawBytes)
+	case *vector.Value_Integer:
+		return casted.Integer
+	case *vector.Value_Float:
+		return casted.Float
+	case *vector.Value_Timestamp:
+		return casted.Timestamp.AsTime().Format(time.RFC3339Nano)
+	case *vector.Value_Boolean:
+		return casted.Boolean
+	case *vector.Value_Map:
+		return vectorFieldsToInterface(casted.Map.Fields)
+	case *vector.Value_Array:
+		out := make([]interface{}, len(casted.Array.Items))
+		for i, item := range casted.Array.Items {
+			out[i] = vectorValueToInterface(item)
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -2,6 +2,7 @@ package input
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"io"
 	"net"
@@ -20,7 +21,7 @@ type VectorInput struct {
 	msgCh       chan *gelf.Message
 	closed      bool
 	connections *util.ConnectionMap
-	schema      *vectorSchema
+	schema      *messageSchema
 	maxMsgSize  uint32
 	log         *zap.SugaredLogger
 	tls         util.TLSInputOptions
@@ -28,19 +29,20 @@ type VectorInput struct {
 
 type VectorInputOptions struct {
 	Address        string
-	TimestampField string
-	MessageField   string
-	HostField      string
+	TimestampField []string
+	MessageField   []string
+	HostField      []string
 	MaxMsgSize     uint32
 	TLS            util.TLSInputOptions
+	Schema         SchemaOptions
 }
 
 func NewVectorInputOptions() VectorInputOptions {
 	return VectorInputOptions{
 		Address:        ":9000",
-		TimestampField: "timestamp",
-		MessageField:   "message",
-		HostField:      "host",
+		TimestampField: []string{"timestamp"},
+		MessageField:   []string{"message"},
+		HostField:      []string{"host"},
 		MaxMsgSize:     DefaultMaxMessageSize,
 	}
 }
@@ -50,17 +52,17 @@ func NewVectorInput(options VectorInputOptions) *VectorInput {
 		address:     options.Address,
 		connections: util.NewConnectionMap(),
 		maxMsgSize:  options.MaxMsgSize,
-		schema: &vectorSchema{
-			timestampField: options.TimestampField,
-			messageField:   options.MessageField,
-			hostField:      options.HostField,
-		},
-		log: zap.S().With("component", "vector-input"),
-		tls: options.TLS,
+		schema:      newMessageSchema("vector", options.TimestampField, options.MessageField, options.HostField, options.Schema),
+		log:         zap.S().With("component", "vector-input"),
+		tls:         options.TLS,
 	}
 }
 
 func (v *VectorInput) Start() error {
+	if err := v.schema.prepare(); err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("tcp", v.address)
 	if err != nil {
 		return err
@@ -126,6 +128,16 @@ func (v *VectorInput) readRoutine(conn net.Conn) {
 
 	v.log.Infof("Accepted connection #%v from %v", id, conn.RemoteAddr().String())
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			v.log.Errorf("TLS handshake failed, dropping connection: %v", err)
+			v.connections.Close(id)
+			return
+		}
+	}
+
+	source := sourceFromConn(conn, id)
+
 	for {
 		_, err := io.ReadAtLeast(conn, buf[0:4], 4)
 		if err != nil {
@@ -156,7 +168,7 @@ func (v *VectorInput) readRoutine(conn net.Conn) {
 			continue
 		}
 
-		msg, err := v.schema.eventToGelf(event)
+		msg, err := v.schema.eventToGelf(event, source)
 		if err != nil {
 			v.log.Errorf("Unable to convert message to GELF, ignoring: %v", err)
 			continue
@@ -10,6 +10,7 @@ import (
 	vtgrpc "github.com/planetscale/vtprotobuf/codec/grpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/encoding"
 	_ "google.golang.org/grpc/encoding/proto"
 )
@@ -23,7 +24,7 @@ type VectorV2Input struct {
 	address  string
 	listener net.Listener
 	msgCh    chan *gelf.Message
-	schema   *vectorSchema
+	schema   *messageSchema
 	log      *zap.SugaredLogger
 	server   *grpc.Server
 	tls      util.TLSInputOptions
@@ -31,48 +32,56 @@ type VectorV2Input struct {
 
 type VectorV2InputOptions struct {
 	Address        string
-	TimestampField string
-	MessageField   string
-	HostField      string
+	TimestampField []string
+	MessageField   []string
+	HostField      []string
 	TLS            util.TLSInputOptions
+	Schema         SchemaOptions
 }
 
 func NewVectorV2InputOptions() VectorV2InputOptions {
 	return VectorV2InputOptions{
 		Address:        ":9000",
-		TimestampField: "timestamp",
-		MessageField:   "message",
-		HostField:      "host",
+		TimestampField: []string{"timestamp"},
+		MessageField:   []string{"message"},
+		HostField:      []string{"host"},
 	}
 }
 
 func NewVectorV2Input(options VectorV2InputOptions) *VectorV2Input {
 	return &VectorV2Input{
 		address: options.Address,
-		schema: &vectorSchema{
-			timestampField: options.TimestampField,
-			messageField:   options.MessageField,
-			hostField:      options.HostField,
-		},
-		log: zap.S().With("component", "vector-v2-input"),
-		tls: options.TLS,
+		schema:  newMessageSchema("vectorv2", options.TimestampField, options.MessageField, options.HostField, options.Schema),
+		log:     zap.S().With("component", "vectorv2-input"),
+		tls:     options.TLS,
 	}
 }
 
 func (v *VectorV2Input) Start() error {
+	if err := v.schema.prepare(); err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("tcp", v.address)
 	if err != nil {
 		return err
 	}
 
-	listener, err = util.WrapInputWithTLS(listener, v.tls)
+	// TLS is handled by gRPC itself, so that client certificates are available to handlers
+	tlsConf, err := util.NewTLSServerConfig(v.tls)
 	if err != nil {
+		listener.Close()
 		return err
 	}
 
+	var serverOpts []grpc.ServerOption
+	if tlsConf != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+
 	v.listener = listener
 
-	v.server = grpc.NewServer()
+	v.server = grpc.NewServer(serverOpts...)
 	api.RegisterVectorServer(v.server, v)
 
 	return nil
@@ -96,8 +105,10 @@ func (v *VectorV2Input) Listen(msgCh chan *gelf.Message, stopCh chan interface{}
 
 func (v *VectorV2Input) PushEvents(ctx context.Context, req *api.PushEventsRequest) (*api.PushEventsResponse, error) {
 	// TODO: for now this will always block if full, should we error out instead?
+	source := sourceFromContext(ctx)
+
 	for _, e := range req.Events {
-		msg, err := v.schema.eventToGelf(e)
+		msg, err := v.schema.eventToGelf(e, source)
 		if err != nil {
 			v.log.Errorf("Unable to convert message to GELF, ignoring: %v", err)
 		} else {
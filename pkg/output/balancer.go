@@ -0,0 +1,440 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.uber.org/zap"
+)
+
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastOutstanding = "least-outstanding"
+	BalanceHash             = "hash"
+	BalanceFailover         = "failover"
+)
+
+// writerFactory creates writer for the endpoint, host is the configured name the address was resolved from.
+type writerFactory func(address, host string) (gelf.Writer, error)
+
+type BalancerOptions struct {
+	// Strategy is one of round-robin, least-outstanding, hash (by message host) or failover
+	Strategy string
+	// MaxFails is the number of consecutive failures after which endpoint is ejected
+	MaxFails int
+	// EjectDuration is how long ejected endpoint is skipped before a message is tried again
+	EjectDuration time.Duration
+	// ResolveInterval controls how often addresses are resolved again, disabled if 0
+	ResolveInterval time.Duration
+	// Resolve enables resolving of hosts into all their IP addresses
+	Resolve bool
+}
+
+func NewBalancerOptions() BalancerOptions {
+	return BalancerOptions{
+		Strategy:        BalanceRoundRobin,
+		MaxFails:        3,
+		EjectDuration:   30 * time.Second,
+		ResolveInterval: 30 * time.Second,
+		Resolve:         true,
+	}
+}
+
+type endpoint struct {
+	address      string
+	host         string
+	order        int
+	writer       gelf.Writer
+	outstanding  int
+	failures     int
+	ejectedUntil time.Time
+	removed      bool
+	// connecting is closed when writer being created by another caller is ready or failed with connectErr
+	connecting chan struct{}
+	connectErr error
+}
+
+// balancedWriter spreads messages across endpoints, skipping those failing recently.
+type balancedWriter struct {
+	mu        sync.Mutex
+	options   BalancerOptions
+	addresses []string
+	factory   writerFactory
+	endpoints []*endpoint
+	next      int
+	// lost are batched messages of removed endpoints that failed to be written when their writer was closed
+	lost   *gelf.BatchError
+	cancel context.CancelFunc
+	log    *zap.SugaredLogger
+}
+
+func newBalancedWriter(addresses []string, factory writerFactory, options BalancerOptions) (*balancedWriter, error) {
+	switch options.Strategy {
+	case BalanceRoundRobin, BalanceLeastOutstanding, BalanceHash, BalanceFailover:
+	default:
+		return nil, fmt.Errorf("invalid balancing strategy: %v", options.Strategy)
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("at least one GELF address is required")
+	}
+
+	b := &balancedWriter{
+		options:   options,
+		addresses: addresses,
+		factory:   factory,
+		log:       zap.S().With("component", "gelf-balancer"),
+	}
+
+	if err := b.resolve(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	if options.Resolve && options.ResolveInterval > 0 {
+		go b.resolveLoop(ctx)
+	}
+
+	return b, nil
+}
+
+func (b *balancedWriter) WriteMessage(msg *gelf.Message) error {
+	// like TCPWriter, batch lost in the background is returned with the message being written, so that both are sent again
+	if lost := b.takeLost(); lost != nil {
+		lost.Messages = append(lost.Messages, msg)
+		return lost
+	}
+
+	ep, err := b.acquire(msg)
+	if err != nil {
+		return err
+	}
+
+	if err = ep.writer.WriteMessage(msg); err != nil {
+		err = fmt.Errorf("%v: %w", ep.address, err)
+	}
+
+	b.release(ep, err)
+
+	return err
+}
+
+func (b *balancedWriter) Write(p []byte) (int, error) {
+	msg := util.NewGelfMessage()
+	msg.Short = string(p)
+
+	if err := b.WriteMessage(msg); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (b *balancedWriter) Close() error {
+	b.cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for _, ep := range b.endpoints {
+		if ep.writer != nil {
+			if err := ep.writer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%v: %w", ep.address, err))
+			}
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// acquire picks endpoint for the message, creating its writer if needed.
+func (b *balancedWriter) acquire(msg *gelf.Message) (*endpoint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ep := b.pick(msg, time.Now())
+	if ep == nil {
+		return nil, fmt.Errorf("no GELF endpoints available")
+	}
+
+	// counted before connecting, so that endpoint removed meanwhile isn't closed under the caller
+	ep.outstanding++
+
+	if err := b.connect(ep); err != nil {
+		ep.outstanding--
+		return nil, fmt.Errorf("%v: %w", ep.address, err)
+	}
+
+	return ep, nil
+}
+
+// connect creates writer of the endpoint if it has none. The lock is released while connecting, so that
+// endpoint slow to connect doesn't block the others, callers picking it meanwhile wait for the result.
+func (b *balancedWriter) connect(ep *endpoint) error {
+	if ep.writer != nil {
+		return nil
+	}
+
+	if connecting := ep.connecting; connecting != nil {
+		b.mu.Unlock()
+		<-connecting
+		b.mu.Lock()
+
+		if ep.writer == nil {
+			return ep.connectErr
+		}
+		return nil
+	}
+
+	connecting := make(chan struct{})
+	ep.connecting = connecting
+
+	b.mu.Unlock()
+	writer, err := b.factory(ep.address, ep.host)
+	b.mu.Lock()
+
+	ep.connecting = nil
+	ep.connectErr = err
+	close(connecting)
+
+	if err != nil {
+		b.failed(ep, time.Now())
+		return err
+	}
+
+	ep.writer = writer
+	return nil
+}
+
+func (b *balancedWriter) release(ep *endpoint, err error) {
+	b.mu.Lock()
+	ep.outstanding--
+	b.updateHealth(ep, err)
+	closing := ep.removed && ep.outstanding == 0
+	b.mu.Unlock()
+
+	if closing {
+		b.closeRemoved(ep)
+	}
+}
+
+func (b *balancedWriter) updateHealth(ep *endpoint, err error) {
+	// message rejected for its content says nothing about endpoint's health
+	if gelf.IsPermanent(err) {
+		return
+	}
+
+	if err != nil {
+		b.failed(ep, time.Now())
+		return
+	}
+
+	if ep.failures >= b.options.MaxFails {
+		b.log.Infof("Endpoint %v is healthy again", ep.address)
+	}
+	ep.failures = 0
+}
+
+// closeRemoved closes writer of endpoint no longer resolved, batched messages it fails to write are kept
+// and returned by the next write. It's called without the lock, since closing flushes the batch.
+func (b *balancedWriter) closeRemoved(ep *endpoint) {
+	err := ep.writer.Close()
+	if err == nil {
+		return
+	}
+
+	var batchErr *gelf.BatchError
+	if !errors.As(err, &batchErr) {
+		b.log.Warnf("Error while closing writer of removed endpoint %v: %v", ep.address, err)
+		return
+	}
+
+	b.log.Warnf("Error while closing writer of removed endpoint %v, messages will be sent again: %v", ep.address, err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.lost == nil {
+		b.lost = &gelf.BatchError{Err: fmt.Errorf("%v: %w", ep.address, batchErr.Err)}
+	}
+	b.lost.Messages = append(b.lost.Messages, batchErr.Messages...)
+}
+
+func (b *balancedWriter) takeLost() *gelf.BatchError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lost := b.lost
+	b.lost = nil
+
+	return lost
+}
+
+func (b *balancedWriter) failed(ep *endpoint, now time.Time) {
+	ep.failures++
+
+	// failures are reset only by success, so endpoint failing its probe after ejection is ejected right away
+	if ep.failures >= b.options.MaxFails {
+		b.log.Warnf("Ejecting endpoint %v for %v after %v failures", ep.address, b.options.EjectDuration, ep.failures)
+		util.IncCounter("output.endpoint_ejections")
+		ep.ejectedUntil = now.Add(b.options.EjectDuration)
+	}
+}
+
+// pick selects endpoint according to the strategy, falling back to the one ejected for the shortest time.
+func (b *balancedWriter) pick(msg *gelf.Message, now time.Time) *endpoint {
+	healthy := make([]*endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			healthy = append(healthy, ep)
+		}
+	}
+
+	if len(healthy) == 0 {
+		var soonest *endpoint
+		for _, ep := range b.endpoints {
+			if soonest == nil || ep.ejectedUntil.Before(soonest.ejectedUntil) {
+				soonest = ep
+			}
+		}
+		return soonest
+	}
+
+	switch b.options.Strategy {
+	case BalanceLeastOutstanding:
+		best := healthy[0]
+		for _, ep := range healthy[1:] {
+			if ep.outstanding < best.outstanding {
+				best = ep
+			}
+		}
+		return best
+	case BalanceHash:
+		// rendezvous hashing keeps most hosts on their endpoint when endpoints change
+		var best *endpoint
+		var bestScore uint64
+		for _, ep := range healthy {
+			h := fnv.New64a()
+			h.Write([]byte(msg.Host))
+			h.Write([]byte(ep.address))
+			if score := h.Sum64(); best == nil || score > bestScore {
+				best, bestScore = ep, score
+			}
+		}
+		return best
+	case BalanceFailover:
+		best := healthy[0]
+		for _, ep := range healthy[1:] {
+			if ep.order < best.order {
+				best = ep
+			}
+		}
+		return best
+	}
+
+	b.next = (b.next + 1) % len(healthy)
+	return healthy[b.next]
+}
+
+func (b *balancedWriter) resolveLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.options.ResolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.resolve(); err != nil {
+				b.log.Warnf("Could not resolve GELF addresses, keeping previous endpoints: %v", err)
+			}
+		}
+	}
+}
+
+// resolve updates endpoints to match current DNS records, state of endpoints that remain is kept.
+func (b *balancedWriter) resolve() error {
+	type resolved struct {
+		host  string
+		order int
+	}
+
+	addresses := make(map[string]resolved)
+
+	for i, address := range b.addresses {
+		host, port, err := net.SplitHostPort(address)
+		if !b.options.Resolve || err != nil || net.ParseIP(host) != nil {
+			addresses[address] = resolved{host: host, order: i}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not resolve %v: %w", host, err)
+		}
+
+		sort.Strings(ips)
+		for _, ip := range ips {
+			addresses[net.JoinHostPort(ip, port)] = resolved{host: host, order: i}
+		}
+	}
+
+	b.mu.Lock()
+
+	endpoints := make([]*endpoint, 0, len(addresses))
+	existing := make(map[string]bool)
+	var closing []*endpoint
+
+	for _, ep := range b.endpoints {
+		if _, ok := addresses[ep.address]; ok {
+			endpoints = append(endpoints, ep)
+			existing[ep.address] = true
+			continue
+		}
+
+		b.log.Infof("Removing endpoint %v", ep.address)
+		ep.removed = true
+		if ep.writer != nil && ep.outstanding == 0 {
+			closing = append(closing, ep)
+		}
+	}
+
+	for address, res := range addresses {
+		if !existing[address] {
+			if b.endpoints != nil {
+				b.log.Infof("Adding endpoint %v", address)
+			}
+			endpoints = append(endpoints, &endpoint{address: address, host: res.host, order: res.order})
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].order != endpoints[j].order {
+			return endpoints[i].order < endpoints[j].order
+		}
+		return strings.Compare(endpoints[i].address, endpoints[j].address) < 0
+	})
+
+	b.endpoints = endpoints
+	b.mu.Unlock()
+
+	for _, ep := range closing {
+		b.closeRemoved(ep)
+	}
+
+	return nil
+}
@@ -0,0 +1,400 @@
+package output
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+var testAddresses = []string{"10.0.0.1:12201", "10.0.0.2:12201", "10.0.0.3:12201"}
+
+func newTestBalancer(t *testing.T, addresses []string, factory writerFactory, configure func(options *BalancerOptions)) *balancedWriter {
+	t.Helper()
+
+	options := NewBalancerOptions()
+	options.Resolve = false
+	if configure != nil {
+		configure(&options)
+	}
+
+	balancer, err := newBalancedWriter(addresses, factory, options)
+	if err != nil {
+		t.Fatalf("newBalancedWriter: %s", err)
+	}
+	t.Cleanup(func() {
+		balancer.Close()
+	})
+
+	return balancer
+}
+
+// writeHosts writes a message for every host, returning number of messages written to each of test addresses.
+func writeHosts(t *testing.T, strategy string, hosts ...string) []int {
+	t.Helper()
+
+	factory := newFakeFactory(nil)
+	balancer := newTestBalancer(t, testAddresses, factory.create, func(options *BalancerOptions) {
+		options.Strategy = strategy
+	})
+
+	for _, host := range hosts {
+		if err := balancer.WriteMessage(&gelf.Message{Host: host}); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+	}
+
+	counts := make([]int, len(testAddresses))
+	for i, address := range testAddresses {
+		counts[i] = factory.count(address)
+	}
+
+	return counts
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	counts := writeHosts(t, BalanceRoundRobin, "a", "a", "a", "a", "a", "a")
+
+	if counts[0] != 2 || counts[1] != 2 || counts[2] != 2 {
+		t.Errorf("WriteMessage: expected messages spread evenly, got %v", counts)
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	counts := writeHosts(t, BalanceFailover, "a", "b", "c", "d")
+
+	if counts[0] != 4 {
+		t.Errorf("WriteMessage: expected all messages on first endpoint, got %v", counts)
+	}
+}
+
+// tests that without concurrent writes all endpoints have no outstanding messages, so the first one wins
+func TestBalancerLeastOutstanding(t *testing.T) {
+	counts := writeHosts(t, BalanceLeastOutstanding, "a", "b", "c")
+
+	if counts[0] != 3 {
+		t.Errorf("WriteMessage: expected all messages on first endpoint, got %v", counts)
+	}
+}
+
+func TestBalancerHash(t *testing.T) {
+	balancer := newTestBalancer(t, testAddresses, newFakeFactory(nil).create, func(options *BalancerOptions) {
+		options.Strategy = BalanceHash
+	})
+
+	now := time.Now()
+	picked := make(map[string]string)
+	for _, host := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		picked[host] = balancer.pick(&gelf.Message{Host: host}, now).address
+
+		for i := 0; i < 3; i++ {
+			if got := balancer.pick(&gelf.Message{Host: host}, now).address; got != picked[host] {
+				t.Errorf("pick: expected host %s to stay on %s, got %s", host, picked[host], got)
+				return
+			}
+		}
+	}
+
+	// ejecting an endpoint moves only hosts that were on it
+	ejected := balancer.endpoints[0]
+	ejected.ejectedUntil = now.Add(time.Minute)
+
+	for host, address := range picked {
+		got := balancer.pick(&gelf.Message{Host: host}, now).address
+		if address != ejected.address && got != address {
+			t.Errorf("pick: expected host %s to stay on %s after other endpoint was ejected, got %s", host, address, got)
+		}
+		if got == ejected.address {
+			t.Errorf("pick: expected host %s to move away from ejected endpoint", host)
+		}
+	}
+}
+
+func TestBalancerPick(t *testing.T) {
+	now := time.Now()
+
+	balancers := map[string]*balancedWriter{
+		"b": {
+			options:   BalancerOptions{Strategy: BalanceLeastOutstanding},
+			endpoints: []*endpoint{{address: "a", outstanding: 2}, {address: "b"}, {address: "c", outstanding: 1}},
+		},
+		// failover skips ejected endpoint
+		"d": {
+			options:   BalancerOptions{Strategy: BalanceFailover},
+			endpoints: []*endpoint{{address: "c", order: 0, ejectedUntil: now.Add(time.Second)}, {address: "d", order: 1}, {address: "e", order: 2}},
+		},
+		// ejection expired
+		"f": {
+			options:   BalancerOptions{Strategy: BalanceFailover},
+			endpoints: []*endpoint{{address: "f", order: 0, ejectedUntil: now}, {address: "g", order: 1}},
+		},
+		// all ejected falls back to the one ejected for the shortest time
+		"i": {
+			options:   BalancerOptions{Strategy: BalanceRoundRobin},
+			endpoints: []*endpoint{{address: "h", ejectedUntil: now.Add(time.Minute)}, {address: "i", ejectedUntil: now.Add(time.Second)}},
+		},
+	}
+
+	for expected, balancer := range balancers {
+		if got := balancer.pick(&gelf.Message{Host: "h"}, now); got.address != expected {
+			t.Errorf("pick %s: expected %s, got %s", balancer.options.Strategy, expected, got.address)
+		}
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	factory := newFakeFactory(func(w *fakeWriter) {
+		if w.address == testAddresses[0] {
+			w.fail = func(msg *gelf.Message) error {
+				return errors.New("connection refused")
+			}
+		}
+	})
+
+	balancer := newTestBalancer(t, testAddresses[:2], factory.create, func(options *BalancerOptions) {
+		options.Strategy = BalanceFailover
+		options.MaxFails = 3
+		options.EjectDuration = time.Minute
+	})
+
+	for i := 0; i < 3; i++ {
+		err := balancer.WriteMessage(&gelf.Message{Host: "h"})
+		if err == nil || err.Error() != testAddresses[0]+": connection refused" {
+			t.Errorf("WriteMessage: expected error of %s, got %v", testAddresses[0], err)
+			return
+		}
+
+		if factory.count(testAddresses[1]) != 0 {
+			t.Errorf("WriteMessage: expected endpoint to be ejected only after %d failures", 3)
+			return
+		}
+	}
+
+	if err := balancer.WriteMessage(&gelf.Message{Host: "h"}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+		return
+	}
+
+	if factory.count(testAddresses[1]) != 1 {
+		t.Errorf("WriteMessage: expected message to be written to the next endpoint")
+	}
+}
+
+// tests that single endpoint is still tried while ejected and success resets its failures
+func TestBalancerRecovery(t *testing.T) {
+	failing := true
+	factory := newFakeFactory(func(w *fakeWriter) {
+		w.fail = func(msg *gelf.Message) error {
+			if failing {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+	})
+
+	balancer := newTestBalancer(t, testAddresses[:1], factory.create, func(options *BalancerOptions) {
+		options.MaxFails = 2
+	})
+
+	balancer.WriteMessage(&gelf.Message{})
+	balancer.WriteMessage(&gelf.Message{})
+
+	ep := balancer.endpoints[0]
+	if ep.ejectedUntil.IsZero() {
+		t.Errorf("WriteMessage: expected endpoint to be ejected")
+		return
+	}
+
+	failing = false
+	if err := balancer.WriteMessage(&gelf.Message{}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+		return
+	}
+
+	if ep.failures != 0 {
+		t.Errorf("WriteMessage: expected failures to be reset, got %d", ep.failures)
+	}
+}
+
+func TestBalancerFactoryError(t *testing.T) {
+	factory := func(address, host string) (gelf.Writer, error) {
+		return nil, errors.New("certificate expired")
+	}
+
+	balancer := newTestBalancer(t, testAddresses[:1], factory, func(options *BalancerOptions) {
+		options.MaxFails = 1
+	})
+
+	err := balancer.WriteMessage(&gelf.Message{})
+	if err == nil || err.Error() != "10.0.0.1:12201: certificate expired" {
+		t.Errorf("WriteMessage: unexpected error %v", err)
+		return
+	}
+
+	if balancer.endpoints[0].ejectedUntil.IsZero() {
+		t.Errorf("WriteMessage: expected endpoint failing to create writer to be ejected")
+	}
+}
+
+// tests that endpoint slow to connect doesn't block writes to other endpoints
+func TestBalancerSlowConnect(t *testing.T) {
+	fake := newFakeFactory(nil)
+	dialing := make(chan struct{})
+	connected := make(chan struct{})
+
+	factory := func(address, host string) (gelf.Writer, error) {
+		if address == testAddresses[1] {
+			close(dialing)
+			<-connected
+		}
+		return fake.create(address, host)
+	}
+
+	balancer := newTestBalancer(t, testAddresses[:2], factory, nil)
+
+	done := make(chan error)
+	go func() {
+		done <- balancer.WriteMessage(&gelf.Message{})
+	}()
+	<-dialing
+
+	if err := balancer.WriteMessage(&gelf.Message{}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+	if fake.count(testAddresses[0]) != 1 {
+		t.Errorf("WriteMessage: expected message written to %s while %s is connecting", testAddresses[0], testAddresses[1])
+	}
+
+	close(connected)
+	if err := <-done; err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+	if fake.count(testAddresses[1]) != 1 {
+		t.Errorf("WriteMessage: expected message written to %s once connected", testAddresses[1])
+	}
+}
+
+func TestNewBalancedWriterInvalid(t *testing.T) {
+	options := NewBalancerOptions()
+	options.Strategy = "random"
+
+	if _, err := newBalancedWriter(testAddresses, newFakeFactory(nil).create, options); err == nil {
+		t.Errorf("newBalancedWriter: expected error for invalid strategy")
+	}
+
+	if _, err := newBalancedWriter(nil, newFakeFactory(nil).create, NewBalancerOptions()); err == nil {
+		t.Errorf("newBalancedWriter: expected error for no addresses")
+	}
+}
+
+func TestBalancerClose(t *testing.T) {
+	factory := newFakeFactory(func(w *fakeWriter) {
+		if w.address != testAddresses[1] {
+			w.closeErr = errors.New("batch lost")
+		}
+	})
+
+	options := NewBalancerOptions()
+	options.Resolve = false
+
+	balancer, err := newBalancedWriter(testAddresses, factory.create, options)
+	if err != nil {
+		t.Errorf("newBalancedWriter: %s", err)
+		return
+	}
+
+	for range testAddresses {
+		balancer.WriteMessage(&gelf.Message{})
+	}
+
+	expected := "10.0.0.1:12201: batch lost; 10.0.0.3:12201: batch lost"
+	if err = balancer.Close(); err == nil || err.Error() != expected {
+		t.Errorf("Close: expected %q, got %v", expected, err)
+	}
+
+	for address, writer := range factory.writers {
+		if !writer.closed {
+			t.Errorf("Close: expected writer of %s to be closed", address)
+		}
+	}
+}
+
+// tests that batch lost when writer of removed endpoint is closed is returned by the next write
+func TestBalancerRemovedEndpoint(t *testing.T) {
+	lost := &gelf.Message{Short: "lost"}
+	factory := newFakeFactory(func(w *fakeWriter) {
+		if w.address == testAddresses[0] {
+			w.closeErr = &gelf.BatchError{Messages: []*gelf.Message{lost}, Err: errors.New("connection reset")}
+		}
+	})
+
+	options := NewBalancerOptions()
+	options.Resolve = false
+
+	balancer, err := newBalancedWriter(testAddresses, factory.create, options)
+	if err != nil {
+		t.Errorf("newBalancedWriter: %s", err)
+		return
+	}
+	defer balancer.Close()
+
+	for range testAddresses {
+		if err = balancer.WriteMessage(&gelf.Message{}); err != nil {
+			t.Errorf("WriteMessage: %s", err)
+			return
+		}
+	}
+
+	balancer.addresses = testAddresses[1:]
+	if err = balancer.resolve(); err != nil {
+		t.Errorf("resolve: %s", err)
+		return
+	}
+
+	if !factory.writers[testAddresses[0]].closed {
+		t.Errorf("resolve: expected writer of removed endpoint to be closed")
+	}
+
+	msg := &gelf.Message{Short: "next"}
+	var batchErr *gelf.BatchError
+	if err = balancer.WriteMessage(msg); !errors.As(err, &batchErr) {
+		t.Errorf("WriteMessage: expected batch error, got %v", err)
+		return
+	}
+
+	if len(batchErr.Messages) != 2 || batchErr.Messages[0] != lost || batchErr.Messages[1] != msg {
+		t.Errorf("WriteMessage: expected lost and written message, got %v", batchErr.Messages)
+	}
+
+	if err = balancer.WriteMessage(msg); err != nil {
+		t.Errorf("WriteMessage: expected lost batch to be returned only once, got %s", err)
+	}
+}
+
+// tests that message rejected for its content doesn't count as endpoint failure
+func TestBalancerPermanentError(t *testing.T) {
+	permanent := &gelf.TooLargeError{Size: 10, Limit: 5}
+
+	factory := newFakeFactory(func(w *fakeWriter) {
+		w.fail = func(msg *gelf.Message) error {
+			return permanent
+		}
+	})
+
+	balancer := newTestBalancer(t, testAddresses[:2], factory.create, func(options *BalancerOptions) {
+		options.Strategy = BalanceFailover
+		options.MaxFails = 1
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := balancer.WriteMessage(&gelf.Message{}); !errors.Is(err, permanent) {
+			t.Errorf("WriteMessage: expected wrapped %s, got %v", permanent, err)
+			return
+		}
+	}
+
+	if ep := balancer.endpoints[0]; ep.failures != 0 || !ep.ejectedUntil.IsZero() {
+		t.Errorf("WriteMessage: expected endpoint not to be ejected, got %d failures", ep.failures)
+	}
+}
@@ -0,0 +1,80 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+// deadLetter appends messages that can't be sent to a file, one JSON-encoded GELF message per line.
+type deadLetter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newDeadLetter(path string) (*deadLetter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open dead letter file: %w", err)
+	}
+
+	return &deadLetter{file: file}, nil
+}
+
+// Write appends the message with the reason it couldn't be sent in _dead_letter_reason field.
+func (d *deadLetter) Write(msg *gelf.Message, reason error) error {
+	copied := *msg
+	copied.Extra = make(map[string]interface{}, len(msg.Extra)+1)
+	for key, value := range msg.Extra {
+		copied.Extra[key] = value
+	}
+	copied.Extra["_dead_letter_reason"] = reason.Error()
+
+	buf := &bytes.Buffer{}
+	if err := copied.MarshalJSONBuf(buf); err != nil {
+		// message which can't be encoded is kept with offending values as strings
+		buf.Reset()
+		sanitizeMessage(&copied)
+		if err = copied.MarshalJSONBuf(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	util.IncCounter("output.dead_letters")
+
+	return nil
+}
+
+func (d *deadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+// sanitizeMessage replaces values that can't be encoded as JSON with their string representation.
+func sanitizeMessage(msg *gelf.Message) {
+	if math.IsNaN(msg.TimeUnix) || math.IsInf(msg.TimeUnix, 0) {
+		msg.TimeUnix = 0
+	}
+
+	for key, value := range msg.Extra {
+		if _, err := json.Marshal(value); err != nil {
+			msg.Extra[key] = fmt.Sprint(value)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// writeDeadLetters writes the message once for every reason and returns decoded lines of the file.
+func writeDeadLetters(t *testing.T, msg *gelf.Message, reasons ...string) []map[string]interface{} {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "dead.log")
+
+	dl, err := newDeadLetter(path)
+	if err != nil {
+		t.Fatalf("newDeadLetter: %s", err)
+	}
+
+	for _, reason := range reasons {
+		if err := dl.Write(msg, errors.New(reason)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	if err := dl.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer file.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestDeadLetterWrite(t *testing.T) {
+	msg := &gelf.Message{Version: "1.1", Host: "h", Short: "m", TimeUnix: 1.5, Extra: map[string]interface{}{"_app": "web"}}
+
+	lines := writeDeadLetters(t, msg, "rejected", "rejected again")
+	if len(lines) != 2 {
+		t.Errorf("Write: expected 2 lines, got %d", len(lines))
+		return
+	}
+
+	if lines[0]["_dead_letter_reason"] != "rejected" || lines[1]["_dead_letter_reason"] != "rejected again" {
+		t.Errorf("Write: unexpected reasons %v and %v", lines[0]["_dead_letter_reason"], lines[1]["_dead_letter_reason"])
+	}
+
+	if lines[0]["timestamp"] != 1.5 || lines[0]["_app"] != "web" {
+		t.Errorf("Write: unexpected line %v", lines[0])
+	}
+
+	if _, ok := msg.Extra["_dead_letter_reason"]; ok {
+		t.Errorf("Write: expected original message to be left intact")
+	}
+}
+
+// tests that message which can't be encoded is kept with offending values as strings
+func TestDeadLetterWriteSanitized(t *testing.T) {
+	msg := &gelf.Message{Version: "1.1", Host: "h", Short: "m", TimeUnix: math.NaN(), Extra: map[string]interface{}{"_ratio": math.Inf(1)}}
+
+	lines := writeDeadLetters(t, msg, "rejected")
+	if len(lines) != 1 {
+		t.Errorf("Write: expected 1 line, got %d", len(lines))
+		return
+	}
+
+	if lines[0]["timestamp"] != 0.0 || lines[0]["_ratio"] != "+Inf" {
+		t.Errorf("Write: unexpected line %v", lines[0])
+	}
+}
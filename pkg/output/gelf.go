@@ -1,135 +1,596 @@
 package output
 
 import (
+	"compress/flate"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/cenkalti/backoff/v4"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
 	"go.uber.org/zap"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type GelfOutput struct {
 	proto           string
-	address         string
+	addresses       []string
 	compression     bool
+	compressType    gelf.CompressType
+	compressName    string
+	compressLevel   int
+	minCompressSize int
+	batch           gelfBatchOptions
+	retry           BackoffOptions
 	retryLimit      int
 	gracefulTimeout time.Duration
 	writer          gelf.Writer
+	enrich          util.EnrichOptions
+	enricher        *util.Enricher
+	tls             util.TLSOutputOptions
+	http            GelfHTTPOptions
+	balancer        BalancerOptions
+	workers         int
+	connections     int
+	orderByHost     bool
+	chunkSize       int
+	oversizePolicy  string
+	deadLetterPath  string
+	deadLetter      *deadLetter
 	log             *zap.SugaredLogger
 }
 
 type GelfOutputOptions struct {
 	Proto                  string
-	Address                string
+	Addresses              []string
 	Compression            bool
 	RetryLimit             int
 	GracefulTimeoutSeconds int
+	Enrich                 util.EnrichOptions
+	TLS                    util.TLSOutputOptions
+	HTTP                   GelfHTTPOptions
+	Balancer               BalancerOptions
+	// Workers is the number of messages sent concurrently
+	Workers int
+	// Connections is the number of TCP/TLS connections to each server, defaults to Workers
+	Connections int
+	// OrderByHost sends all messages of a host through the same worker, keeping their order
+	OrderByHost bool
+	// ChunkSize is the maximum size of UDP datagram
+	ChunkSize int
+	// OversizePolicy is one of drop, truncate, drop-fields or dead-letter, applied to messages exceeding UDP chunk limit
+	OversizePolicy string
+	// DeadLetterPath is the file where messages that can't be sent are appended
+	DeadLetterPath string
+	// CompressionType is one of gzip, zlib or none, HTTP output always uses gzip
+	CompressionType string
+	// CompressionLevel of UDP messages is one of compress/flate levels, from -2 (Huffman only) to 9 (best compression)
+	CompressionLevel int
+	// CompressionThreshold is the size of UDP message below which it's sent uncompressed
+	CompressionThreshold int
+	// BatchBytes and BatchMessages enable batching of TCP/TLS messages when either is set
+	BatchBytes    int
+	BatchMessages int
+	// BatchLinger is how long messages wait for the batch to fill up
+	BatchLinger time.Duration
+	// Backoff controls delays between retries of failed messages
+	Backoff BackoffOptions
+}
+
+type BackoffOptions struct {
+	// InitialInterval is the delay before the first retry, it grows exponentially with following retries
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries
+	MaxInterval time.Duration
+	// MaxElapsedTime is how long a message may be retried, unlimited if 0
+	MaxElapsedTime time.Duration
+}
+
+func NewBackoffOptions() BackoffOptions {
+	return BackoffOptions{
+		InitialInterval: backoff.DefaultInitialInterval,
+		MaxInterval:     backoff.DefaultMaxInterval,
+		MaxElapsedTime:  backoff.DefaultMaxElapsedTime,
+	}
+}
+
+type gelfBatchOptions struct {
+	bytes    int
+	messages int
+	linger   time.Duration
 }
 
 func NewGelfOutputOptions() GelfOutputOptions {
 	return GelfOutputOptions{
 		Proto:                  "udp",
-		Address:                "127.0.0.1:12201",
+		Addresses:              []string{"127.0.0.1:12201"},
 		Compression:            true,
+		CompressionType:        "gzip",
+		CompressionLevel:       flate.BestSpeed,
+		BatchLinger:            100 * time.Millisecond,
+		Backoff:                NewBackoffOptions(),
 		RetryLimit:             3,
 		GracefulTimeoutSeconds: 10,
+		HTTP: GelfHTTPOptions{
+			Timeout:          10 * time.Second,
+			CompressionLevel: flate.DefaultCompression,
+		},
+		Balancer:       NewBalancerOptions(),
+		Workers:        1,
+		ChunkSize:      gelf.ChunkSize,
+		OversizePolicy: OversizeDrop,
 	}
 }
 
 func NewGelfOutput(options GelfOutputOptions) *GelfOutput {
 	return &GelfOutput{
 		proto:           options.Proto,
-		address:         options.Address,
+		addresses:       options.Addresses,
 		compression:     options.Compression,
+		compressName:    options.CompressionType,
+		compressLevel:   options.CompressionLevel,
+		minCompressSize: options.CompressionThreshold,
+		batch: gelfBatchOptions{
+			bytes:    options.BatchBytes,
+			messages: options.BatchMessages,
+			linger:   options.BatchLinger,
+		},
+		retry:           options.Backoff,
 		retryLimit:      options.RetryLimit,
 		gracefulTimeout: time.Duration(options.GracefulTimeoutSeconds) * time.Second,
+		enrich:          options.Enrich,
+		tls:             options.TLS,
+		http:            options.HTTP,
+		balancer:        options.Balancer,
+		workers:         options.Workers,
+		connections:     options.Connections,
+		orderByHost:     options.OrderByHost,
+		chunkSize:       options.ChunkSize,
+		oversizePolicy:  options.OversizePolicy,
+		deadLetterPath:  options.DeadLetterPath,
 		log:             zap.S().With("component", "gelf-output"),
 	}
 }
 
 func (o *GelfOutput) Start() error {
+	enricher, err := util.NewEnricher(o.enrich)
+	if err != nil {
+		return err
+	}
+	o.enricher = enricher
+
+	if o.workers < 1 {
+		return fmt.Errorf("at least one GELF worker is required")
+	}
+	if o.connections < 1 {
+		o.connections = o.workers
+	}
+
+	if o.compressType, err = parseCompressionType(o.compressName); err != nil {
+		return err
+	}
+	if o.compressType == gelf.CompressNone {
+		o.compression = false
+	}
+	if !o.compression {
+		o.compressType = gelf.CompressNone
+	}
+	if o.compressLevel < flate.HuffmanOnly || o.compressLevel > flate.BestCompression {
+		return fmt.Errorf("GELF compression level must be between %v and %v", flate.HuffmanOnly, flate.BestCompression)
+	}
+
+	if o.chunkSize < gelf.MinChunkSize || o.chunkSize > gelf.MaxChunkSize {
+		return fmt.Errorf("GELF chunk size must be between %v and %v", gelf.MinChunkSize, gelf.MaxChunkSize)
+	}
+
+	if (o.batch.bytes > 0 || o.batch.messages > 0) && o.batch.linger <= 0 {
+		return fmt.Errorf("GELF batch linger must be positive when batching is enabled")
+	}
+
+	if o.retry.InitialInterval <= 0 || o.retry.MaxInterval < o.retry.InitialInterval || o.retry.MaxElapsedTime < 0 {
+		return fmt.Errorf("invalid GELF backoff, initial interval must be positive and not above max interval")
+	}
+
+	switch o.oversizePolicy {
+	case OversizeDrop, OversizeTruncate, OversizeDropFields:
+	case OversizeDeadLetter:
+		if o.deadLetterPath == "" {
+			return fmt.Errorf("dead letter path is required by %v oversize policy", OversizeDeadLetter)
+		}
+	default:
+		return fmt.Errorf("invalid oversize policy: %v", o.oversizePolicy)
+	}
+
+	if o.deadLetterPath != "" {
+		if o.deadLetter, err = newDeadLetter(o.deadLetterPath); err != nil {
+			return err
+		}
+	}
+
+	factory, err := o.writerFactory()
+	if err != nil {
+		return err
+	}
+
+	// HTTP requests need host names for virtual hosts and proxies, Go resolves them per connection anyway
+	if o.proto == "http" {
+		o.balancer.Resolve = false
+	}
+
+	writer, err := newBalancedWriter(o.addresses, factory, o.balancer)
+	if err != nil {
+		return err
+	}
+	o.writer = writer
+
+	return nil
+}
+
+// writerFactory returns function creating writers for single GELF server.
+func (o *GelfOutput) writerFactory() (writerFactory, error) {
 	switch o.proto {
 	case "tcp":
-		writer, err := gelf.NewTCPWriter(o.address)
-		if err != nil {
-			return fmt.Errorf("unable to initialize TCP GELF writer: %v", err)
+		return func(address, _ string) (gelf.Writer, error) {
+			return newWriterPool(o.connections, func() (gelf.Writer, error) {
+				writer, err := gelf.NewTCPWriter(address)
+				if err != nil {
+					return nil, fmt.Errorf("unable to initialize TCP GELF writer: %v", err)
+				}
+				o.batch.apply(writer, o.batchFailed(address))
+				return writer, nil
+			}), nil
+		}, nil
+	case "tls":
+		if _, err := util.NewTLSClientConfig(o.tls); err != nil {
+			return nil, fmt.Errorf("unable to load TLS configuration: %v", err)
 		}
-		o.writer = writer
+
+		return func(address, host string) (gelf.Writer, error) {
+			// addresses are resolved to IPs, certificate still needs to be verified against the name
+			options := o.tls
+			if options.ServerName == "" {
+				options.ServerName = host
+			}
+
+			endpointConf, err := util.NewTLSClientConfig(options)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load TLS configuration: %v", err)
+			}
+
+			return newWriterPool(o.connections, func() (gelf.Writer, error) {
+				writer, err := gelf.NewTLSWriter(address, endpointConf)
+				if err != nil {
+					return nil, fmt.Errorf("unable to initialize TLS GELF writer: %v", err)
+				}
+				o.batch.apply(writer, o.batchFailed(address))
+				return writer, nil
+			}), nil
+		}, nil
+	case "http":
+		return func(address, _ string) (gelf.Writer, error) {
+			writer, err := newHTTPWriter(address, o.compression, o.connections, o.http, o.tls)
+			if err != nil {
+				return nil, fmt.Errorf("unable to initialize HTTP GELF writer: %v", err)
+			}
+			return writer, nil
+		}, nil
 	case "udp":
-		writer, err := gelf.NewUDPWriter(o.address, o.compression)
-		if err != nil {
-			return fmt.Errorf("unable to initialize UDP GELF writer: %v", err)
-		}
-		o.writer = writer
+		return func(address, _ string) (gelf.Writer, error) {
+			writer, err := gelf.NewUDPWriter(address, o.compression)
+			if err != nil {
+				return nil, fmt.Errorf("unable to initialize UDP GELF writer: %v", err)
+			}
+			writer.ChunkSize = o.chunkSize
+			writer.CompressionType = o.compressType
+			writer.CompressionLevel = o.compressLevel
+			writer.CompressionThreshold = o.minCompressSize
+			return writer, nil
+		}, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("invalid GELF protocol: %v", o.proto)
+}
+
+func (b gelfBatchOptions) apply(writer *gelf.TCPWriter, onError func(err *gelf.BatchError)) {
+	writer.BatchBytes = b.bytes
+	writer.BatchMessages = b.messages
+	writer.BatchLinger = b.linger
+	writer.OnFlushError = onError
+}
+
+// batchFailed reports batch that failed to be written in the background, its messages are returned
+// by the next write to the endpoint and sent again.
+func (o *GelfOutput) batchFailed(address string) func(err *gelf.BatchError) {
+	return func(err *gelf.BatchError) {
+		util.IncCounter("output.batch_failures")
+		o.log.Warnf("Error while writing GELF batch to %v, messages will be sent again: %v", address, err)
+	}
+}
+
+func parseCompressionType(name string) (gelf.CompressType, error) {
+	switch name {
+	case "gzip":
+		return gelf.CompressGzip, nil
+	case "zlib":
+		return gelf.CompressZlib, nil
+	case "none":
+		return gelf.CompressNone, nil
+	}
+
+	return 0, fmt.Errorf("invalid GELF compression type: %v", name)
 }
 
 func (o *GelfOutput) Listen(msgCh chan *gelf.Message, stopCh chan interface{}) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	// stopCtx ends reading of new messages, sendCtx aborts sending once graceful timeout passes
+	stopCtx, stop := context.WithCancel(context.Background())
+	sendCtx, abort := context.WithCancel(context.Background())
+	defer abort()
 
 	go func() {
-		select {
-		case <-stopCh:
-			cancel()
+		<-stopCh
+		o.log.Infof("Graceful shutdown initiated, forcing shutdown after %v", o.gracefulTimeout)
+		time.AfterFunc(o.gracefulTimeout, abort)
+		stop()
+	}()
+
+	wg := &sync.WaitGroup{}
+	unsent := new(int64)
+
+	if !o.orderByHost {
+		for i := 0; i < o.workers; i++ {
+			wg.Add(1)
+			go o.worker(wg, msgCh, stopCtx, sendCtx, unsent)
+		}
+	} else {
+		queues := make([]chan *gelf.Message, o.workers)
+		for i := range queues {
+			queues[i] = make(chan *gelf.Message, 64)
+
+			// workers stop once their queue is closed, so that no message dispatched to them is lost
+			wg.Add(1)
+			go o.worker(wg, queues[i], context.Background(), sendCtx, unsent)
+		}
+
+		o.dispatch(msgCh, queues, stopCtx, sendCtx)
+	}
+
+	wg.Wait()
+
+	if remaining := atomic.LoadInt64(unsent) + int64(len(msgCh)); remaining > 0 {
+		o.log.Warnf("Forcing shutdown with %v messages unsent", remaining)
+	}
+
+	// flushes batched messages
+	if err := o.writer.Close(); err != nil {
+		o.log.Warnf("Error while closing GELF writer: %v", err)
+	}
+
+	if o.deadLetter != nil {
+		o.deadLetter.Close()
+	}
+
+	return nil
+}
+
+// dispatch passes messages to workers by their host, keeping order of messages from the same host.
+func (o *GelfOutput) dispatch(msgCh chan *gelf.Message, queues []chan *gelf.Message, stopCtx, sendCtx context.Context) {
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
 		}
 	}()
 
-	for {
+	push := func(ctx context.Context, msg *gelf.Message) bool {
+		h := fnv.New32a()
+		h.Write([]byte(msg.Host))
+
 		select {
+		case queues[h.Sum32()%uint32(len(queues))] <- msg:
+			return true
 		case <-ctx.Done():
-			o.gracefulStop(msgCh)
-			return nil
-		case msg := <-msgCh:
-			if err := o.send(ctx, msg); err != nil {
-				o.log.Errorf("Max attempts reached, dropping: %v", err)
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-stopCtx.Done():
+			o.drain(msgCh, sendCtx, func(msg *gelf.Message) {
+				push(sendCtx, msg)
+			})
+			return
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			if !push(stopCtx, msg) {
+				push(sendCtx, msg)
 			}
 		}
 	}
 }
 
-func (o *GelfOutput) gracefulStop(msgCh chan *gelf.Message) {
-	o.log.Infof("Graceful shutdown initiated, forcing shutdown after %v", o.gracefulTimeout)
+func (o *GelfOutput) worker(wg *sync.WaitGroup, queue chan *gelf.Message, stopCtx, sendCtx context.Context, unsent *int64) {
+	defer wg.Done()
 
-	ctx, cancel := context.WithTimeout(context.Background(), o.gracefulTimeout)
+	deliver := func(msg *gelf.Message) {
+		if sendCtx.Err() != nil {
+			atomic.AddInt64(unsent, 1)
+			return
+		}
+
+		if err := o.send(sendCtx, msg); err != nil {
+			if gelf.IsPermanent(err) {
+				o.log.Errorf("Message can't be sent, dropping: %v", err)
+			} else {
+				o.log.Errorf("Max attempts reached, dropping: %v", err)
+			}
+		}
+	}
 
 	for {
 		select {
-		case <-ctx.Done():
-			if remaining := len(msgCh); remaining > 0 {
-				o.log.Warnf("Forcing shutdown with %v messages unsent", remaining)
+		case <-stopCtx.Done():
+			o.drain(queue, sendCtx, deliver)
+			return
+		case msg, ok := <-queue:
+			if !ok {
+				return
 			}
-			cancel()
+			deliver(msg)
+		}
+	}
+}
+
+// drain handles messages remaining in the queue until it's empty or graceful timeout passes.
+func (o *GelfOutput) drain(queue chan *gelf.Message, sendCtx context.Context, handle func(msg *gelf.Message)) {
+	for {
+		select {
+		case <-sendCtx.Done():
 			return
-		case msg := <-msgCh:
-			if err := o.send(ctx, msg); err != nil {
-				o.log.Errorf("Max attempts reached, dropping: %v", err)
+		case msg, ok := <-queue:
+			if !ok {
+				return
 			}
+			handle(msg)
 		default:
-			cancel()
 			return
 		}
 	}
 }
 
 func (o *GelfOutput) send(ctx context.Context, msg *gelf.Message) error {
-	var bo backoff.BackOff = backoff.WithContext(backoff.NewExponentialBackOff(), ctx)
+	o.enricher.Apply(msg)
+
+	return o.deliver(ctx, msg)
+}
+
+// deliver writes already enriched message until it succeeds or backoff gives up.
+func (o *GelfOutput) deliver(ctx context.Context, msg *gelf.Message) error {
+	// messages of failed batches are collected and sent after msg, instead of nesting their delivery in its retries
+	var requeued []*gelf.Message
+
+	err := o.deliverOne(ctx, msg, &requeued)
+
+	for i := 0; i < len(requeued); i++ {
+		if err := o.deliverOne(ctx, requeued[i], &requeued); err != nil {
+			o.log.Errorf("Max attempts reached for message of failed batch, dropping: %v", err)
+		}
+	}
+
+	return err
+}
+
+// deliverOne writes the message, retrying it until it's written or dead lettered. Messages of a batch failed
+// meanwhile are added to requeued, they go through the balancer again, so they may be sent by another endpoint.
+func (o *GelfOutput) deliverOne(ctx context.Context, msg *gelf.Message, requeued *[]*gelf.Message) error {
+	exponential := backoff.NewExponentialBackOff()
+	exponential.InitialInterval = o.retry.InitialInterval
+	exponential.MaxInterval = o.retry.MaxInterval
+	exponential.MaxElapsedTime = o.retry.MaxElapsedTime
+	exponential.Reset()
+
+	retryAfter := &retryAfterBackOff{BackOff: exponential, max: o.retry.MaxInterval}
+	var bo backoff.BackOff = backoff.WithContext(retryAfter, ctx)
 
 	if o.retryLimit > -1 {
 		bo = backoff.WithMaxRetries(bo, uint64(o.retryLimit))
 	}
 
 	operation := func() error {
-		err := o.writer.WriteMessage(msg)
+		err := o.writeShrinking(msg)
 		if err != nil {
 			o.log.Warnf("Error while writing GELF message: %v", err)
+			*requeued = append(*requeued, batchFailures(msg, err)...)
+			retryAfter.requested(err)
+
+			// errors caused by the message itself won't go away by retrying
+			if gelf.IsPermanent(err) {
+				return backoff.Permanent(fmt.Errorf("error while writing GELF message: %w", err))
+			}
+
 			return fmt.Errorf("error while writing GELF message: %v", err)
 		}
 
 		return nil
 	}
 
-	return backoff.Retry(operation, bo)
+	err := backoff.Retry(operation, bo)
+	if err == nil || !o.deadLettered(err) {
+		return err
+	}
+
+	if dlErr := o.deadLetter.Write(msg, err); dlErr != nil {
+		return fmt.Errorf("unable to write dead letter: %v, original error: %w", dlErr, err)
+	}
+
+	o.log.Warnf("Message written to dead letter file: %v", err)
+	return nil
+}
+
+// retryAfterBackOff waits at least as long as the server asked in Retry-After header of the last response,
+// up to max so that a single response can't stall the worker for long.
+type retryAfterBackOff struct {
+	backoff.BackOff
+	max   time.Duration
+	delay time.Duration
+}
+
+func (b *retryAfterBackOff) requested(err error) {
+	b.delay = 0
+
+	var httpErr *gelf.HTTPError
+	if errors.As(err, &httpErr) {
+		b.delay = httpErr.RetryAfter
+		if b.delay > b.max {
+			b.delay = b.max
+		}
+	}
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next != backoff.Stop && next < b.delay {
+		next = b.delay
+	}
+
+	return next
+}
+
+func (b *retryAfterBackOff) Reset() {
+	b.delay = 0
+	b.BackOff.Reset()
+}
+
+// batchFailures returns messages of a failed batch to send again, except msg which is retried by the caller.
+func batchFailures(msg *gelf.Message, err error) []*gelf.Message {
+	var batchErr *gelf.BatchError
+	if !errors.As(err, &batchErr) {
+		return nil
+	}
+
+	util.AddCounter("output.batch_requeued", int64(len(batchErr.Messages)))
+
+	failed := make([]*gelf.Message, 0, len(batchErr.Messages))
+	for _, m := range batchErr.Messages {
+		if m != msg {
+			failed = append(failed, m)
+		}
+	}
+
+	return failed
+}
+
+// deadLettered returns whether message that failed with err goes to dead letter file. Oversize messages are
+// handled according to the oversize policy, other permanent errors whenever dead letter file is configured.
+func (o *GelfOutput) deadLettered(err error) bool {
+	var tooLarge *gelf.TooLargeError
+	if errors.As(err, &tooLarge) {
+		return o.oversizePolicy == OversizeDeadLetter
+	}
+
+	return o.deadLetter != nil && gelf.IsPermanent(err)
 }
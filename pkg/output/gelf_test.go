@@ -0,0 +1,243 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/cenkalti/backoff/v4"
+)
+
+func newTestOutput(writer gelf.Writer, retryLimit int) *GelfOutput {
+	options := NewGelfOutputOptions()
+	options.RetryLimit = retryLimit
+	options.Backoff.InitialInterval = time.Millisecond
+	options.Backoff.MaxInterval = time.Millisecond
+
+	o := NewGelfOutput(options)
+	o.writer = writer
+
+	return o
+}
+
+// written returns short messages written to the writer joined with commas.
+func written(writer *fakeWriter) string {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	shorts := make([]string, len(writer.written))
+	for i, msg := range writer.written {
+		shorts[i] = msg.Short
+	}
+
+	return strings.Join(shorts, ",")
+}
+
+// tests that messages of a failed batch, reported with the message being written, are sent again
+func TestGelfOutputRequeue(t *testing.T) {
+	batched := []*gelf.Message{{Short: "1"}, {Short: "2"}}
+	failed := false
+
+	writer := &fakeWriter{fail: func(msg *gelf.Message) error {
+		if !failed && msg.Short == "3" {
+			failed = true
+			return &gelf.BatchError{Messages: append(batched, msg), Err: errors.New("broken pipe")}
+		}
+		return nil
+	}}
+
+	o := newTestOutput(writer, 3)
+
+	if err := o.deliver(context.Background(), &gelf.Message{Short: "3"}); err != nil {
+		t.Errorf("deliver: %s", err)
+		return
+	}
+
+	// failed batch is sent after the message being retried
+	if written(writer) != "3,1,2" {
+		t.Errorf("deliver: expected 3,1,2 to be written, got %s", written(writer))
+	}
+}
+
+// tests that message of failed batch failing with another batch is sent again only once
+func TestGelfOutputRequeueNested(t *testing.T) {
+	first := &gelf.Message{Short: "1"}
+	second := &gelf.Message{Short: "2"}
+	failed := make(map[string]bool)
+
+	writer := &fakeWriter{fail: func(msg *gelf.Message) error {
+		if failed[msg.Short] {
+			return nil
+		}
+		failed[msg.Short] = true
+
+		switch msg.Short {
+		case "3":
+			return &gelf.BatchError{Messages: []*gelf.Message{first, msg}, Err: errors.New("broken pipe")}
+		case "1":
+			return &gelf.BatchError{Messages: []*gelf.Message{second, msg}, Err: errors.New("broken pipe")}
+		}
+		return nil
+	}}
+
+	if err := newTestOutput(writer, 3).deliver(context.Background(), &gelf.Message{Short: "3"}); err != nil {
+		t.Errorf("deliver: %s", err)
+		return
+	}
+
+	if written(writer) != "3,1,2" {
+		t.Errorf("deliver: expected 3,1,2 to be written, got %s", written(writer))
+	}
+}
+
+// failingWriter returns writer failing with the errors in order, counting write attempts.
+func failingWriter(attempts *int, errs ...error) *fakeWriter {
+	return &fakeWriter{fail: func(msg *gelf.Message) error {
+		*attempts++
+		if *attempts <= len(errs) {
+			return errs[*attempts-1]
+		}
+		return nil
+	}}
+}
+
+func TestGelfOutputDeliverRetry(t *testing.T) {
+	transient := errors.New("connection reset")
+
+	attempts := 0
+	writer := failingWriter(&attempts, transient, transient)
+
+	if err := newTestOutput(writer, 3).deliver(context.Background(), &gelf.Message{Short: "m"}); err != nil {
+		t.Errorf("deliver: %s", err)
+		return
+	}
+
+	if attempts != 3 || writer.count() != 1 {
+		t.Errorf("deliver: expected 3 attempts and 1 write, got %d and %d", attempts, writer.count())
+	}
+}
+
+func TestGelfOutputDeliverRetryLimit(t *testing.T) {
+	transient := errors.New("connection reset")
+
+	attempts := 0
+	writer := failingWriter(&attempts, transient, transient, transient)
+
+	if err := newTestOutput(writer, 2).deliver(context.Background(), &gelf.Message{Short: "m"}); err == nil {
+		t.Errorf("deliver: expected error after retry limit")
+		return
+	}
+
+	if attempts != 3 {
+		t.Errorf("deliver: expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestGelfOutputDeliverPermanent(t *testing.T) {
+	attempts := 0
+	writer := failingWriter(&attempts, &gelf.HTTPError{StatusCode: 400, Status: "400 Bad Request"})
+
+	if err := newTestOutput(writer, 3).deliver(context.Background(), &gelf.Message{Short: "m"}); err == nil {
+		t.Errorf("deliver: expected permanent error")
+		return
+	}
+
+	if attempts != 1 {
+		t.Errorf("deliver: expected permanent error not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestGelfOutputDeliverDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.log")
+
+	dl, err := newDeadLetter(path)
+	if err != nil {
+		t.Errorf("newDeadLetter: %s", err)
+		return
+	}
+	defer dl.Close()
+
+	attempts := 0
+	writer := failingWriter(&attempts, &gelf.HTTPError{StatusCode: 400, Status: "400 Bad Request"})
+
+	o := newTestOutput(writer, 3)
+	o.deadLetter = dl
+
+	if err := o.deliver(context.Background(), &gelf.Message{Short: "m"}); err != nil {
+		t.Errorf("deliver: expected permanent error to be dead lettered, got %s", err)
+		return
+	}
+
+	if attempts != 1 || writer.count() != 0 {
+		t.Errorf("deliver: expected single failed attempt, got %d attempts and %d writes", attempts, writer.count())
+	}
+
+	// transient errors aren't dead lettered even when retries run out
+	attempts = 0
+	writer = failingWriter(&attempts, errors.New("timeout"), errors.New("timeout"))
+	o.writer = writer
+	o.retryLimit = 1
+
+	if err := o.deliver(context.Background(), &gelf.Message{Short: "m"}); err == nil {
+		t.Errorf("deliver: expected transient error to be returned")
+	}
+}
+
+func TestGelfOutputDeadLettered(t *testing.T) {
+	tooLarge := &gelf.TooLargeError{Size: 10, Limit: 5}
+	permanent := &gelf.EncodeError{Err: errors.New("NaN")}
+
+	o := &GelfOutput{oversizePolicy: OversizeDrop, deadLetter: &deadLetter{}}
+
+	if !o.deadLettered(permanent) {
+		t.Errorf("deadLettered: expected permanent error to be dead lettered")
+	}
+
+	if o.deadLettered(tooLarge) {
+		t.Errorf("deadLettered: expected oversize message to be dropped with drop policy")
+	}
+
+	if o.deadLettered(errors.New("timeout")) {
+		t.Errorf("deadLettered: expected transient error not to be dead lettered")
+	}
+
+	o.oversizePolicy = OversizeDeadLetter
+	if !o.deadLettered(tooLarge) {
+		t.Errorf("deadLettered: expected oversize message to be dead lettered with dead-letter policy")
+	}
+
+	o.deadLetter = nil
+	if o.deadLettered(permanent) {
+		t.Errorf("deadLettered: expected nothing to be dead lettered without dead letter file")
+	}
+}
+
+// tests that delay requested by Retry-After header is used when longer than the backoff, up to its max
+func TestRetryAfterBackOff(t *testing.T) {
+	bo := &retryAfterBackOff{BackOff: backoff.NewConstantBackOff(time.Millisecond), max: time.Second}
+
+	bo.requested(&gelf.HTTPError{StatusCode: 429, RetryAfter: 500 * time.Millisecond})
+	if next := bo.NextBackOff(); next != 500*time.Millisecond {
+		t.Errorf("NextBackOff: expected 500ms, got %v", next)
+	}
+
+	bo.requested(&gelf.HTTPError{StatusCode: 429, RetryAfter: time.Minute})
+	if next := bo.NextBackOff(); next != time.Second {
+		t.Errorf("NextBackOff: expected delay capped at 1s, got %v", next)
+	}
+
+	bo.requested(errors.New("connection reset"))
+	if next := bo.NextBackOff(); next != time.Millisecond {
+		t.Errorf("NextBackOff: expected backoff interval, got %v", next)
+	}
+
+	bo = &retryAfterBackOff{BackOff: &backoff.StopBackOff{}, max: time.Second}
+	bo.requested(&gelf.HTTPError{StatusCode: 429, RetryAfter: time.Second})
+	if next := bo.NextBackOff(); next != backoff.Stop {
+		t.Errorf("NextBackOff: expected stop, got %v", next)
+	}
+}
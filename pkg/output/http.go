@@ -0,0 +1,82 @@
+package output
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+type GelfHTTPOptions struct {
+	Headers     map[string]string
+	BasicUser   string
+	BasicPass   string
+	BearerToken string
+	// Proxy is URL of HTTP proxy, environment variables (HTTP_PROXY etc.) are used if empty
+	Proxy   string
+	Timeout time.Duration
+	// CompressionLevel of gzip, independent of UDP level so that HTTP keeps the gzip default
+	CompressionLevel int
+}
+
+// newHTTPWriter creates writer for GELF HTTP input, address is either full URL or host:port of the input.
+func newHTTPWriter(address string, compression bool, connections int, options GelfHTTPOptions, tlsOptions util.TLSOutputOptions) (*gelf.HTTPWriter, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address + "/gelf"
+	}
+
+	target, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GELF HTTP address: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = connections
+
+	if options.Proxy != "" {
+		proxy, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if target.Scheme == "https" {
+		if tlsOptions.ServerName == "" {
+			tlsOptions.ServerName = target.Hostname()
+		}
+		if transport.TLSClientConfig, err = util.NewTLSClientConfig(tlsOptions); err != nil {
+			return nil, fmt.Errorf("unable to load TLS configuration: %w", err)
+		}
+	}
+
+	writer, err := gelf.NewHTTPWriter(target.String(), &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	writer.Compress = compression
+	writer.CompressionLevel = options.CompressionLevel
+
+	for key, value := range options.Headers {
+		writer.Header.Set(key, value)
+	}
+
+	switch {
+	case options.BearerToken != "":
+		writer.Header.Set("Authorization", "Bearer "+options.BearerToken)
+	case options.BasicUser != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(options.BasicUser + ":" + options.BasicPass))
+		writer.Header.Set("Authorization", "Basic "+credentials)
+	}
+
+	return writer, nil
+}
@@ -0,0 +1,87 @@
+package output
+
+import (
+	"sync"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// fakeWriter records written messages, failing writes with errors returned by fail.
+type fakeWriter struct {
+	mu       sync.Mutex
+	address  string
+	written  []*gelf.Message
+	fail     func(msg *gelf.Message) error
+	closeErr error
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessage(msg *gelf.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fail != nil {
+		if err := w.fail(msg); err != nil {
+			return err
+		}
+	}
+
+	w.written = append(w.written, msg)
+	return nil
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	return len(p), w.WriteMessage(&gelf.Message{Short: string(p)})
+}
+
+func (w *fakeWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	return w.closeErr
+}
+
+func (w *fakeWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.written)
+}
+
+// fakeFactory creates fakeWriter for every address, keeping them for inspection.
+type fakeFactory struct {
+	mu      sync.Mutex
+	writers map[string]*fakeWriter
+	setup   func(w *fakeWriter)
+}
+
+func newFakeFactory(setup func(w *fakeWriter)) *fakeFactory {
+	return &fakeFactory{writers: make(map[string]*fakeWriter), setup: setup}
+}
+
+func (f *fakeFactory) create(address, host string) (gelf.Writer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writer := &fakeWriter{address: address}
+	if f.setup != nil {
+		f.setup(writer)
+	}
+	f.writers[address] = writer
+
+	return writer, nil
+}
+
+// count returns number of messages written to the address.
+func (f *fakeFactory) count(address string) int {
+	f.mu.Lock()
+	writer, ok := f.writers[address]
+	f.mu.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	return writer.count()
+}
@@ -0,0 +1,114 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const (
+	OversizeDrop       = "drop"
+	OversizeTruncate   = "truncate"
+	OversizeDropFields = "drop-fields"
+	OversizeDeadLetter = "dead-letter"
+)
+
+// maxShrinkAttempts bounds how many times a too large message is made smaller and sent again.
+const maxShrinkAttempts = 16
+
+// writeShrinking writes the message, making it smaller according to the oversize policy while it's too large.
+func (o *GelfOutput) writeShrinking(msg *gelf.Message) error {
+	err := o.writer.WriteMessage(msg)
+
+	for i := 0; i < maxShrinkAttempts; i++ {
+		var tooLarge *gelf.TooLargeError
+		if !errors.As(err, &tooLarge) {
+			return err
+		}
+
+		var shrunk bool
+		switch o.oversizePolicy {
+		case OversizeTruncate:
+			shrunk = truncateMessage(msg, float64(tooLarge.Limit)/float64(tooLarge.Size))
+		case OversizeDropFields:
+			shrunk = dropLargestField(msg)
+		}
+
+		if !shrunk {
+			return err
+		}
+
+		util.IncCounter("output.oversize_shrunk")
+		err = o.writer.WriteMessage(msg)
+	}
+
+	return err
+}
+
+// truncateMessage shortens the longer of full and short message by the ratio, with a margin since
+// compressed size doesn't shrink proportionally.
+func truncateMessage(msg *gelf.Message, ratio float64) bool {
+	field := &msg.Full
+	if len(msg.Short) > len(msg.Full) {
+		field = &msg.Short
+	}
+
+	if len(*field) == 0 {
+		return false
+	}
+
+	size := int(float64(len(*field)) * ratio * 0.9)
+	if size >= len(*field) {
+		size = len(*field) - 1
+	}
+
+	*field = util.TruncateUTF8(*field, size)
+
+	markTruncated(msg)
+
+	return true
+}
+
+// dropLargestField removes the extra field with the longest value.
+func dropLargestField(msg *gelf.Message) bool {
+	keys := make([]string, 0, len(msg.Extra))
+	sizes := make(map[string]int, len(msg.Extra))
+
+	for key, value := range msg.Extra {
+		if key == "_truncated" {
+			continue
+		}
+		keys = append(keys, key)
+		sizes[key] = len(fmt.Sprint(value))
+	}
+
+	if len(keys) == 0 {
+		return false
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if sizes[keys[i]] != sizes[keys[j]] {
+			return sizes[keys[i]] > sizes[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	delete(msg.Extra, keys[0])
+	markTruncated(msg)
+
+	return true
+}
+
+// markTruncated adds _truncated field the same way inputs do, once even if message is shrunk repeatedly.
+func markTruncated(msg *gelf.Message) {
+	if msg.Extra == nil {
+		msg.Extra = make(map[string]interface{})
+	}
+
+	if _, ok := msg.Extra["_truncated"]; !ok {
+		util.AppendExtraToGelf(msg, "truncated", int64(1))
+	}
+}
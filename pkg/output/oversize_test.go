@@ -0,0 +1,162 @@
+package output
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	// short and full message before truncation, keyed by expected result
+	msgData := map[string][2]string{
+		"short|" + strings.Repeat("x", 45): {"short", strings.Repeat("x", 100)},
+		strings.Repeat("x", 45) + "|full":  {strings.Repeat("x", 100), "full"},
+		strings.Repeat("ż", 4) + "|":       {strings.Repeat("ż", 10), ""},
+	}
+
+	for expected, data := range msgData {
+		msg := &gelf.Message{Short: data[0], Full: data[1]}
+
+		if !truncateMessage(msg, 0.5) {
+			t.Errorf("truncateMessage: expected message to be truncated")
+			continue
+		}
+
+		if got := msg.Short + "|" + msg.Full; got != expected {
+			t.Errorf("truncateMessage: expected %q, got %q", expected, got)
+		}
+
+		if msg.Extra["_truncated"] != int64(1) {
+			t.Errorf("truncateMessage: expected _truncated field, got %v", msg.Extra)
+		}
+	}
+}
+
+// tests that truncation always makes progress, even if the ratio says message already fits
+func TestTruncateMessageProgress(t *testing.T) {
+	msg := &gelf.Message{Short: strings.Repeat("x", 10)}
+
+	if !truncateMessage(msg, 1) || msg.Short != strings.Repeat("x", 9) {
+		t.Errorf("truncateMessage: expected 9 bytes, got %q", msg.Short)
+	}
+
+	empty := &gelf.Message{}
+	if truncateMessage(empty, 0.5) {
+		t.Errorf("truncateMessage: expected empty message not to be truncated")
+	}
+}
+
+func TestDropLargestField(t *testing.T) {
+	msg := &gelf.Message{Extra: map[string]interface{}{"_a": "xx", "_b": "xxxx", "_c": 1.0, "_d": "yyyy"}}
+
+	// ties are broken by name
+	for _, dropped := range []string{"_b", "_d"} {
+		if !dropLargestField(msg) {
+			t.Errorf("dropLargestField: expected field to be dropped")
+			return
+		}
+
+		if _, ok := msg.Extra[dropped]; ok {
+			t.Errorf("dropLargestField: expected %s to be dropped, got %v", dropped, msg.Extra)
+			return
+		}
+	}
+
+	if len(msg.Extra) != 3 || msg.Extra["_truncated"] != int64(1) {
+		t.Errorf("dropLargestField: expected _a, _c and _truncated, got %v", msg.Extra)
+	}
+}
+
+// tests that _truncated marker itself is never dropped
+func TestDropLargestFieldMarker(t *testing.T) {
+	msg := &gelf.Message{Extra: map[string]interface{}{"_truncated": int64(1)}}
+
+	if dropLargestField(msg) || len(msg.Extra) != 1 {
+		t.Errorf("dropLargestField: expected marker to be kept, got %v", msg.Extra)
+	}
+
+	if dropLargestField(&gelf.Message{Extra: map[string]interface{}{}}) {
+		t.Errorf("dropLargestField: expected nothing to drop")
+	}
+}
+
+// newLimitedWriter returns writer rejecting messages whose size, as measured by size, exceeds the limit.
+func newLimitedWriter(limit int, size func(msg *gelf.Message) int) *fakeWriter {
+	return &fakeWriter{fail: func(msg *gelf.Message) error {
+		if s := size(msg); s > limit {
+			return &gelf.TooLargeError{Size: s, Limit: limit}
+		}
+		return nil
+	}}
+}
+
+func newOversizedMessage() *gelf.Message {
+	return &gelf.Message{
+		Short: strings.Repeat("x", 200),
+		Extra: map[string]interface{}{"_a": strings.Repeat("a", 40), "_b": strings.Repeat("b", 30)},
+	}
+}
+
+func TestWriteShrinkingTruncate(t *testing.T) {
+	writer := newLimitedWriter(50, func(msg *gelf.Message) int {
+		return len(msg.Short)
+	})
+
+	o := &GelfOutput{writer: writer, oversizePolicy: OversizeTruncate}
+	msg := newOversizedMessage()
+
+	if err := o.writeShrinking(msg); err != nil {
+		t.Errorf("writeShrinking: %s", err)
+		return
+	}
+
+	if len(msg.Short) > 50 || writer.count() != 1 {
+		t.Errorf("writeShrinking: expected truncated message to be written once, got %d bytes and %d writes", len(msg.Short), writer.count())
+	}
+}
+
+func TestWriteShrinkingDropFields(t *testing.T) {
+	// only additional fields count, so that dropping them can make message fit
+	writer := newLimitedWriter(50, func(msg *gelf.Message) int {
+		size := 0
+		for _, value := range msg.Extra {
+			if str, ok := value.(string); ok {
+				size += len(str)
+			}
+		}
+		return size
+	})
+
+	o := &GelfOutput{writer: writer, oversizePolicy: OversizeDropFields}
+	msg := newOversizedMessage()
+
+	if err := o.writeShrinking(msg); err != nil {
+		t.Errorf("writeShrinking: %s", err)
+		return
+	}
+
+	if _, ok := msg.Extra["_a"]; ok || msg.Extra["_b"] == nil || writer.count() != 1 {
+		t.Errorf("writeShrinking: expected only the largest field to be dropped, got %v", msg.Extra)
+	}
+}
+
+func TestWriteShrinkingDrop(t *testing.T) {
+	writer := newLimitedWriter(50, func(msg *gelf.Message) int {
+		return len(msg.Short)
+	})
+
+	o := &GelfOutput{writer: writer, oversizePolicy: OversizeDrop}
+	msg := newOversizedMessage()
+
+	var tooLarge *gelf.TooLargeError
+	if err := o.writeShrinking(msg); !errors.As(err, &tooLarge) {
+		t.Errorf("writeShrinking: expected TooLargeError, got %v", err)
+		return
+	}
+
+	if len(msg.Short) != 200 || writer.count() != 0 {
+		t.Errorf("writeShrinking: expected message to be left intact and not written")
+	}
+}
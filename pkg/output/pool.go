@@ -0,0 +1,128 @@
+package output
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+// writerPool spreads concurrent writes across up to size writers, each having its own connection.
+// Writers are created on demand, so idle pools keep a single connection.
+type writerPool struct {
+	mu      sync.Mutex
+	size    int
+	created int
+	create  func() (gelf.Writer, error)
+	idle    chan gelf.Writer
+	all     []gelf.Writer
+}
+
+func newWriterPool(size int, create func() (gelf.Writer, error)) *writerPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &writerPool{
+		size:   size,
+		create: create,
+		idle:   make(chan gelf.Writer, size),
+	}
+}
+
+func (p *writerPool) WriteMessage(msg *gelf.Message) error {
+	writer, err := p.get()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p.idle <- writer
+	}()
+
+	return writer.WriteMessage(msg)
+}
+
+func (p *writerPool) Write(b []byte) (int, error) {
+	msg := util.NewGelfMessage()
+	msg.Short = string(b)
+
+	if err := p.WriteMessage(msg); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (p *writerPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	var failed []*gelf.Message
+	for _, writer := range p.all {
+		if err := writer.Close(); err != nil {
+			var batchErr *gelf.BatchError
+			if errors.As(err, &batchErr) {
+				failed = append(failed, batchErr.Messages...)
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// messages of all failed batches are kept together, so that callers can send them again
+	if len(errs) > 1 && len(failed) > 0 {
+		return &gelf.BatchError{Messages: failed, Err: joinErrors(errs)}
+	}
+
+	return joinErrors(errs)
+}
+
+// get returns idle writer, creating a new one if all are busy and the pool isn't full yet.
+func (p *writerPool) get() (gelf.Writer, error) {
+	select {
+	case writer := <-p.idle:
+		return writer, nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.created < p.size {
+		p.created++
+		p.mu.Unlock()
+
+		writer, err := p.create()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if err != nil {
+			p.created--
+			return nil, err
+		}
+
+		p.all = append(p.all, writer)
+		return writer, nil
+	}
+	p.mu.Unlock()
+
+	return <-p.idle, nil
+}
+
+// joinErrors combines errors into one, nil if there are none.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,168 @@
+package output
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// writeConcurrently writes messages from the given number of goroutines at once,
+// returning writers created by the pool.
+func writeConcurrently(t *testing.T, size, concurrency int) []*fakeWriter {
+	t.Helper()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var writers []*fakeWriter
+
+	pool := newWriterPool(size, func() (gelf.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		writer := &fakeWriter{fail: func(msg *gelf.Message) error {
+			<-release
+			return nil
+		}}
+		writers = append(writers, writer)
+		return writer, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pool.WriteMessage(&gelf.Message{}); err != nil {
+				t.Errorf("WriteMessage: %s", err)
+			}
+		}()
+	}
+	for i := 0; i < concurrency; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+
+	written := 0
+	for _, writer := range writers {
+		written += writer.count()
+	}
+	if written != concurrency {
+		t.Errorf("WriteMessage: expected %d messages, got %d", concurrency, written)
+	}
+
+	return writers
+}
+
+func TestWriterPoolSequential(t *testing.T) {
+	if writers := writeConcurrently(t, 4, 1); len(writers) != 1 {
+		t.Errorf("WriteMessage: expected one writer to be reused, got %d", len(writers))
+	}
+}
+
+func TestWriterPoolConcurrent(t *testing.T) {
+	if writers := writeConcurrently(t, 2, 8); len(writers) > 2 {
+		t.Errorf("WriteMessage: expected at most 2 writers, got %d", len(writers))
+	}
+}
+
+func TestWriterPoolSizeBelowOne(t *testing.T) {
+	if writers := writeConcurrently(t, 0, 4); len(writers) != 1 {
+		t.Errorf("WriteMessage: expected single writer, got %d", len(writers))
+	}
+}
+
+// tests that failed creation doesn't take up the slot
+func TestWriterPoolCreateError(t *testing.T) {
+	fail := true
+	pool := newWriterPool(1, func() (gelf.Writer, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeWriter{}, nil
+	})
+
+	if err := pool.WriteMessage(&gelf.Message{}); err == nil {
+		t.Errorf("WriteMessage: expected error")
+		return
+	}
+
+	fail = false
+	if err := pool.WriteMessage(&gelf.Message{}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+}
+
+func TestWriterPoolClose(t *testing.T) {
+	errs := []error{errors.New("first"), nil, errors.New("third")}
+	created := 0
+
+	pool := newWriterPool(len(errs), func() (gelf.Writer, error) {
+		writer := &fakeWriter{closeErr: errs[created]}
+		created++
+		return writer, nil
+	})
+
+	// writers are only created when all are busy, so take them all before returning them
+	for range errs {
+		if _, err := pool.get(); err != nil {
+			t.Errorf("get: %s", err)
+			return
+		}
+	}
+
+	if err := pool.Close(); err == nil || err.Error() != "first; third" {
+		t.Errorf("Close: expected joined errors, got %v", err)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	if err := joinErrors(nil); err != nil {
+		t.Errorf("joinErrors: expected nil, got %s", err)
+	}
+
+	single := errors.New("a")
+	if err := joinErrors([]error{single}); err != single {
+		t.Errorf("joinErrors: expected single error to be returned as is, got %s", err)
+	}
+
+	if err := joinErrors([]error{errors.New("a"), errors.New("b"), errors.New("c")}); err.Error() != "a; b; c" {
+		t.Errorf("joinErrors: expected a; b; c, got %s", err)
+	}
+}
+
+// tests that messages of failed batches are kept when errors of several writers are joined
+func TestWriterPoolCloseBatches(t *testing.T) {
+	first := &gelf.Message{Short: "1"}
+	second := &gelf.Message{Short: "2"}
+	errs := []error{
+		&gelf.BatchError{Messages: []*gelf.Message{first}, Err: errors.New("first")},
+		errors.New("second"),
+		&gelf.BatchError{Messages: []*gelf.Message{second}, Err: errors.New("third")},
+	}
+	created := 0
+
+	pool := newWriterPool(len(errs), func() (gelf.Writer, error) {
+		writer := &fakeWriter{closeErr: errs[created]}
+		created++
+		return writer, nil
+	})
+
+	for range errs {
+		if _, err := pool.get(); err != nil {
+			t.Errorf("get: %s", err)
+			return
+		}
+	}
+
+	var batchErr *gelf.BatchError
+	if err := pool.Close(); !errors.As(err, &batchErr) {
+		t.Errorf("Close: expected batch error, got %v", err)
+		return
+	}
+
+	if len(batchErr.Messages) != 2 || batchErr.Messages[0] != first || batchErr.Messages[1] != second {
+		t.Errorf("Close: expected messages of both batches, got %v", batchErr.Messages)
+	}
+}
@@ -0,0 +1,197 @@
+package processor
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+type fingerprint [16]byte
+
+type dedupEntry struct {
+	fingerprint fingerprint
+	firstSeen   time.Time
+	count       int64
+	// held is the first duplicate, only used in collapse mode
+	held *gelf.Message
+}
+
+// DedupProcessor drops messages repeated within a time window, optionally collapsing them into one.
+type DedupProcessor struct {
+	options DedupOptions
+	ignored map[string]bool
+	entries map[fingerprint]*list.Element
+	lru     *list.List
+}
+
+type DedupOptions struct {
+	// Fields used to compute fingerprint, whole message is used if empty
+	Fields []string
+	// IgnoreFields are excluded from whole message fingerprint, for example timestamp
+	IgnoreFields []string
+	// Window is how long fingerprint is remembered since the message was first seen
+	Window time.Duration
+	// MaxEntries limits number of remembered fingerprints, least recently seen are evicted first
+	MaxEntries int
+	// Collapse emits the first duplicate once the window passes with _repeat_count field set to the number of duplicates
+	Collapse bool
+}
+
+func NewDedupOptions() DedupOptions {
+	return DedupOptions{
+		Window:     time.Minute,
+		MaxEntries: 10000,
+	}
+}
+
+func NewDedupProcessor(options DedupOptions) *DedupProcessor {
+	return &DedupProcessor{
+		options: options,
+		ignored: make(map[string]bool),
+		entries: make(map[fingerprint]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (d *DedupProcessor) Start() error {
+	if d.options.Window <= 0 {
+		return fmt.Errorf("dedup window needs to be positive")
+	}
+	if d.options.MaxEntries < 1 {
+		return fmt.Errorf("dedup max entries needs to be positive")
+	}
+
+	for _, field := range d.options.IgnoreFields {
+		d.ignored[normalizeField(field)] = true
+	}
+
+	return nil
+}
+
+func (d *DedupProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	now := time.Now()
+	fp := d.fingerprint(msg)
+
+	if elem, ok := d.entries[fp]; ok {
+		entry := elem.Value.(*dedupEntry)
+
+		if now.Sub(entry.firstSeen) < d.options.Window {
+			util.IncCounter("dedup.duplicates")
+			entry.count++
+			if d.options.Collapse && entry.held == nil {
+				entry.held = msg
+			}
+			d.lru.MoveToFront(elem)
+			return
+		}
+
+		d.remove(elem, emit)
+	}
+
+	entry := &dedupEntry{fingerprint: fp, firstSeen: now, count: 1}
+	d.entries[fp] = d.lru.PushFront(entry)
+
+	for d.lru.Len() > d.options.MaxEntries {
+		util.IncCounter("dedup.evicted")
+		d.remove(d.lru.Back(), emit)
+	}
+
+	emit(msg)
+}
+
+func (d *DedupProcessor) Tick(now time.Time, emit EmitFunc) {
+	for elem := d.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.Sub(elem.Value.(*dedupEntry).firstSeen) >= d.options.Window {
+			d.remove(elem, emit)
+		}
+		elem = prev
+	}
+}
+
+func (d *DedupProcessor) Flush(emit EmitFunc) {
+	for elem := d.lru.Back(); elem != nil; elem = d.lru.Back() {
+		d.remove(elem, emit)
+	}
+}
+
+// remove forgets the entry, emitting held duplicate in collapse mode.
+func (d *DedupProcessor) remove(elem *list.Element, emit EmitFunc) {
+	entry := d.lru.Remove(elem).(*dedupEntry)
+	delete(d.entries, entry.fingerprint)
+
+	if entry.held == nil {
+		return
+	}
+
+	util.AppendExtraToGelf(entry.held, "repeat_count", entry.count-1)
+	emit(entry.held)
+}
+
+func (d *DedupProcessor) fingerprint(msg *gelf.Message) fingerprint {
+	h := fnv.New128a()
+
+	if len(d.options.Fields) > 0 {
+		for _, field := range d.options.Fields {
+			value, ok := fieldValue(msg, field)
+			writeFingerprintValue(h, field, value, ok)
+		}
+	} else {
+		d.writeMessage(h, msg)
+	}
+
+	var fp fingerprint
+	copy(fp[:], h.Sum(nil))
+
+	return fp
+}
+
+func (d *DedupProcessor) writeMessage(w io.Writer, msg *gelf.Message) {
+	core := []string{"host", "short_message", "full_message", "timestamp", "level", "facility"}
+	for _, field := range core {
+		if !d.ignored[field] {
+			value, ok := fieldValue(msg, field)
+			writeFingerprintValue(w, field, value, ok)
+		}
+	}
+
+	keys := make([]string, 0, len(msg.Extra))
+	for key := range msg.Extra {
+		if !d.ignored[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeFingerprintValue(w, key, msg.Extra[key], true)
+	}
+}
+
+func writeFingerprintValue(w io.Writer, field string, value interface{}, ok bool) {
+	if !ok {
+		fmt.Fprintf(w, "%s\x00-\x00", field)
+		return
+	}
+
+	fmt.Fprintf(w, "%s\x00%T:%v\x00", field, value, value)
+}
+
+// normalizeField returns name of the field as used in fingerprint, core fields keep their GELF names.
+func normalizeField(field string) string {
+	switch field {
+	case "host", "short_message", "full_message", "timestamp", "level", "facility":
+		return field
+	case "message":
+		return "short_message"
+	}
+
+	return "_" + strings.TrimPrefix(field, "_")
+}
@@ -0,0 +1,218 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func newTestDedup(t *testing.T, configure func(options *DedupOptions)) *DedupProcessor {
+	t.Helper()
+
+	options := NewDedupOptions()
+	if configure != nil {
+		configure(&options)
+	}
+
+	proc := NewDedupProcessor(options)
+	startProcessor(t, proc)
+
+	return proc
+}
+
+func TestDedupWholeMessage(t *testing.T) {
+	proc := newTestDedup(t, nil)
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("b", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "2", map[string]interface{}{"_n": 1.0}), emitted.emit)
+	proc.Process(testMessage("a", "2", map[string]interface{}{"_n": 2.0}), emitted.emit)
+
+	if emitted.shorts() != "1,1,2,2" {
+		t.Errorf("Process: expected 1,1,2,2, got %s", emitted.shorts())
+	}
+}
+
+func TestDedupIgnoreFields(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.IgnoreFields = []string{"timestamp", "request_id"}
+	})
+
+	var emitted collector
+	proc.Process(&gelf.Message{Host: "a", Short: "1", TimeUnix: 1, Extra: map[string]interface{}{"_request_id": "x"}}, emitted.emit)
+	proc.Process(&gelf.Message{Host: "a", Short: "1", TimeUnix: 2, Extra: map[string]interface{}{"_request_id": "y"}}, emitted.emit)
+
+	if emitted.shorts() != "1" {
+		t.Errorf("Process: expected 1, got %s", emitted.shorts())
+	}
+}
+
+func TestDedupFields(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Fields = []string{"message"}
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("b", "1", nil), emitted.emit)
+	proc.Process(testMessage("b", "2", nil), emitted.emit)
+
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected 1,2, got %s", emitted.shorts())
+	}
+}
+
+// tests that missing field has different fingerprint than an empty one
+func TestDedupMissingField(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Fields = []string{"app"}
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "2", map[string]interface{}{"_app": ""}), emitted.emit)
+	proc.Process(testMessage("a", "3", nil), emitted.emit)
+
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected 1,2, got %s", emitted.shorts())
+	}
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.MaxEntries = 1
+	})
+
+	var emitted collector
+	for _, short := range []string{"1", "2", "1"} {
+		proc.Process(testMessage("a", short, nil), emitted.emit)
+	}
+
+	if emitted.shorts() != "1,2,1" {
+		t.Errorf("Process: expected evicted entry to be forgotten, got %s", emitted.shorts())
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Window = time.Millisecond
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	time.Sleep(2 * time.Millisecond)
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+
+	if len(emitted.msgs) != 2 {
+		t.Errorf("Process: expected message to pass again after window, got %d", len(emitted.msgs))
+	}
+}
+
+func TestDedupCollapseFlush(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Collapse = true
+	})
+
+	var emitted collector
+	for _, short := range []string{"1", "1", "1", "2"} {
+		proc.Process(testMessage("a", short, nil), emitted.emit)
+	}
+
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected first messages to be emitted right away, got %s", emitted.shorts())
+		return
+	}
+
+	proc.Flush(emitted.emit)
+
+	if emitted.shorts() != "1,2,1" {
+		t.Errorf("Flush: expected 1,2,1, got %s", emitted.shorts())
+		return
+	}
+
+	if _, ok := emitted.msgs[0].Extra["_repeat_count"]; ok {
+		t.Errorf("Process: expected no repeat count on first message")
+	}
+
+	if count := emitted.msgs[2].Extra["_repeat_count"]; count != int64(2) {
+		t.Errorf("Flush: expected repeat count 2, got %v", count)
+	}
+}
+
+func TestDedupCollapseTick(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Collapse = true
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+
+	proc.Tick(time.Now(), emitted.emit)
+	if emitted.shorts() != "1" {
+		t.Errorf("Tick: expected duplicate to be held until window passes, got %s", emitted.shorts())
+		return
+	}
+
+	proc.Tick(time.Now().Add(time.Minute), emitted.emit)
+	if emitted.shorts() != "1,1" || emitted.msgs[1].Extra["_repeat_count"] != int64(1) {
+		t.Errorf("Tick: expected collapsed duplicate, got %s %v", emitted.shorts(), emitted.msgs)
+	}
+}
+
+// tests that message without duplicates isn't held in collapse mode
+func TestDedupCollapseUnique(t *testing.T) {
+	proc := newTestDedup(t, func(options *DedupOptions) {
+		options.Collapse = true
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+
+	if emitted.shorts() != "1" {
+		t.Errorf("Process: expected message to be emitted right away, got %s", emitted.shorts())
+		return
+	}
+
+	proc.Tick(time.Now().Add(time.Minute), emitted.emit)
+	proc.Flush(emitted.emit)
+
+	if emitted.shorts() != "1" {
+		t.Errorf("Tick: expected nothing more to be emitted, got %s", emitted.shorts())
+	}
+}
+
+func TestDedupStartInvalid(t *testing.T) {
+	invalid := map[string]func(options *DedupOptions){
+		"window":      func(options *DedupOptions) { options.Window = 0 },
+		"max entries": func(options *DedupOptions) { options.MaxEntries = 0 },
+	}
+
+	for name, configure := range invalid {
+		options := NewDedupOptions()
+		configure(&options)
+
+		if err := NewDedupProcessor(options).Start(); err == nil {
+			t.Errorf("Start: expected error for invalid %s", name)
+		}
+	}
+}
+
+func TestNormalizeField(t *testing.T) {
+	fields := map[string]string{
+		"host":      "host",
+		"message":   "short_message",
+		"timestamp": "timestamp",
+		"app":       "_app",
+		"_app":      "_app",
+	}
+
+	for field, expected := range fields {
+		if got := normalizeField(field); got != expected {
+			t.Errorf("normalizeField %s: expected %s, got %s", field, expected, got)
+		}
+	}
+}
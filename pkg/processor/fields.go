@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+var levelNames = map[string]int32{
+	"emerg":     gelf.LOG_EMERG,
+	"emergency": gelf.LOG_EMERG,
+	"panic":     gelf.LOG_EMERG,
+	"alert":     gelf.LOG_ALERT,
+	"crit":      gelf.LOG_CRIT,
+	"critical":  gelf.LOG_CRIT,
+	"fatal":     gelf.LOG_CRIT,
+	"err":       gelf.LOG_ERR,
+	"error":     gelf.LOG_ERR,
+	"warn":      gelf.LOG_WARNING,
+	"warning":   gelf.LOG_WARNING,
+	"notice":    gelf.LOG_NOTICE,
+	"info":      gelf.LOG_INFO,
+	"debug":     gelf.LOG_DEBUG,
+	"trace":     gelf.LOG_DEBUG,
+}
+
+// fieldValue returns value of a message field, core fields are referenced by their GELF names
+// and additional fields with or without leading underscore.
+func fieldValue(msg *gelf.Message, field string) (interface{}, bool) {
+	switch field {
+	case "host":
+		return msg.Host, true
+	case "short_message", "message":
+		return msg.Short, true
+	case "full_message":
+		return msg.Full, msg.Full != ""
+	case "facility":
+		return msg.Facility, msg.Facility != ""
+	case "level":
+		return msg.Level, true
+	case "timestamp":
+		return msg.TimeUnix, true
+	}
+
+	value, ok := msg.Extra["_"+strings.TrimPrefix(field, "_")]
+	return value, ok
+}
+
+// fieldString returns value of a message field formatted as string, empty if field doesn't exist.
+func fieldString(msg *gelf.Message, field string) string {
+	value, ok := fieldValue(msg, field)
+	if !ok {
+		return ""
+	}
+
+	switch casted := value.(type) {
+	case string:
+		return casted
+	case float64:
+		return strconv.FormatFloat(casted, 'f', -1, 64)
+	default:
+		return fmt.Sprint(casted)
+	}
+}
+
+// fieldsKey joins values of the fields into a single key.
+func fieldsKey(msg *gelf.Message, fields []string) string {
+	if len(fields) == 1 {
+		return fieldString(msg, fields[0])
+	}
+
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = fieldString(msg, field)
+	}
+
+	return strings.Join(values, "/")
+}
+
+// messageLevel returns syslog level of the message, either from GELF level or additional field.
+// Field may contain either a number or level name such as "error".
+func messageLevel(msg *gelf.Message, field string) (int32, bool) {
+	if field == "" {
+		// inputs don't set GELF level, so 0 (emergency) means it's unknown
+		return msg.Level, msg.Level != 0
+	}
+
+	value, ok := fieldValue(msg, field)
+	if !ok {
+		return 0, false
+	}
+
+	switch casted := value.(type) {
+	case float64:
+		return int32(casted), true
+	case int64:
+		return int32(casted), true
+	case int32:
+		return casted, true
+	case string:
+		if level, ok := levelNames[strings.ToLower(strings.TrimSpace(casted))]; ok {
+			return level, true
+		}
+		if level, err := strconv.Atoi(casted); err == nil {
+			return int32(level), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func TestFieldString(t *testing.T) {
+	msg := testMessage("h", "short", map[string]interface{}{
+		"_app":   "web",
+		"_count": 3.0,
+		"_ratio": 0.25,
+		"_ok":    true,
+	})
+	msg.Level = gelf.LOG_ERR
+	msg.TimeUnix = 1.5
+
+	fields := map[string]string{
+		"host":          "h",
+		"message":       "short",
+		"short_message": "short",
+		"level":         "3",
+		"timestamp":     "1.5",
+		"app":           "web",
+		"_app":          "web",
+		"count":         "3",
+		"ratio":         "0.25",
+		"ok":            "true",
+	}
+
+	for field, expected := range fields {
+		if got := fieldString(msg, field); got != expected {
+			t.Errorf("fieldString %s: expected %q, got %q", field, expected, got)
+		}
+	}
+
+	for _, field := range []string{"full_message", "facility", "missing"} {
+		if _, ok := fieldValue(msg, field); ok {
+			t.Errorf("fieldValue %s: expected field to be missing", field)
+		}
+	}
+}
+
+func TestFieldsKey(t *testing.T) {
+	msg := testMessage("h", "m", map[string]interface{}{"_app": "web"})
+
+	keys := map[string][]string{
+		"h":      {"host"},
+		"h/web":  {"host", "app"},
+		"h//web": {"host", "missing", "app"},
+	}
+
+	for expected, fields := range keys {
+		if got := fieldsKey(msg, fields); got != expected {
+			t.Errorf("fieldsKey %v: expected %q, got %q", fields, expected, got)
+		}
+	}
+}
+
+func TestMessageLevelField(t *testing.T) {
+	values := map[interface{}]int32{
+		3.0:         gelf.LOG_ERR,
+		int64(6):    gelf.LOG_INFO,
+		" Warning ": gelf.LOG_WARNING,
+		"fatal":     gelf.LOG_CRIT,
+		"7":         gelf.LOG_DEBUG,
+	}
+
+	for value, expected := range values {
+		msg := testMessage("h", "m", map[string]interface{}{"_severity": value})
+
+		if level, ok := messageLevel(msg, "severity"); !ok || level != expected {
+			t.Errorf("messageLevel %v: expected %d, got %d", value, expected, level)
+		}
+	}
+
+	msg := testMessage("h", "m", map[string]interface{}{"_severity": "loud"})
+	if _, ok := messageLevel(msg, "severity"); ok {
+		t.Errorf("messageLevel: expected unknown level name to be ignored")
+	}
+
+	if _, ok := messageLevel(msg, "missing"); ok {
+		t.Errorf("messageLevel: expected missing field to be ignored")
+	}
+}
+
+// tests that unset GELF level is treated as unknown, since inputs don't set it
+func TestMessageLevelGelf(t *testing.T) {
+	msg := testMessage("h", "m", nil)
+
+	if _, ok := messageLevel(msg, ""); ok {
+		t.Errorf("messageLevel: expected unset level to be unknown")
+		return
+	}
+
+	msg.Level = gelf.LOG_WARNING
+	if level, ok := messageLevel(msg, ""); !ok || level != gelf.LOG_WARNING {
+		t.Errorf("messageLevel: expected %d, got %d", gelf.LOG_WARNING, level)
+	}
+}
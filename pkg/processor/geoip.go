@@ -0,0 +1,347 @@
+package processor
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+type geoCityRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type geoASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+type geoResult struct {
+	country  string
+	city     string
+	location bool
+	lat      float64
+	lon      float64
+	asn      uint
+	asOrg    string
+}
+
+// geoDatabase is a MMDB file reopened whenever it changes on disk.
+type geoDatabase struct {
+	path    string
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+func openGeoDatabase(path string) (*geoDatabase, error) {
+	db := &geoDatabase{path: path}
+	if _, err := db.reload(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// reload reopens the database if its modification time or size changed, returning whether it did.
+func (d *geoDatabase) reload() (bool, error) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false, err
+	}
+
+	if d.reader != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return false, nil
+	}
+
+	reader, err := maxminddb.Open(d.path)
+	if err != nil {
+		return false, fmt.Errorf("could not open %v: %w", d.path, err)
+	}
+
+	if d.reader != nil {
+		d.reader.Close()
+	}
+
+	d.reader = reader
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+
+	return true, nil
+}
+
+func (d *geoDatabase) lookup(ip net.IP, result interface{}) bool {
+	if d == nil {
+		return false
+	}
+
+	_, ok, err := d.reader.LookupNetwork(ip, result)
+	if err != nil {
+		util.IncCounter("geoip.errors")
+		return false
+	}
+
+	return ok
+}
+
+func (d *geoDatabase) close() {
+	if d != nil && d.reader != nil {
+		d.reader.Close()
+	}
+}
+
+type geoCacheEntry struct {
+	ip     string
+	result geoResult
+}
+
+// geoCache is a LRU cache of lookup results.
+type geoCache struct {
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newGeoCache(size int) *geoCache {
+	return &geoCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *geoCache) get(ip string) (geoResult, bool) {
+	elem, ok := c.entries[ip]
+	if !ok {
+		return geoResult{}, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*geoCacheEntry).result, true
+}
+
+func (c *geoCache) put(ip string, result geoResult) {
+	if c.size < 1 {
+		return
+	}
+
+	c.entries[ip] = c.lru.PushFront(&geoCacheEntry{ip: ip, result: result})
+
+	for c.lru.Len() > c.size {
+		entry := c.lru.Remove(c.lru.Back()).(*geoCacheEntry)
+		delete(c.entries, entry.ip)
+	}
+}
+
+func (c *geoCache) clear() {
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// GeoIPProcessor adds location and ASN of IP addresses found in message fields using MaxMind databases.
+type GeoIPProcessor struct {
+	options    GeoIPOptions
+	city       *geoDatabase
+	country    *geoDatabase
+	asn        *geoDatabase
+	cache      *geoCache
+	lastReload time.Time
+	log        *zap.SugaredLogger
+}
+
+type GeoIPOptions struct {
+	// Fields containing IP addresses
+	Fields []string
+	// Paths to GeoLite2 / GeoIP2 City, Country and ASN databases, at least one is required
+	CityDatabase    string
+	CountryDatabase string
+	ASNDatabase     string
+	// CacheSize is the number of lookup results cached, caching is disabled if 0
+	CacheSize int
+	// ReloadInterval controls how often database files are checked for changes, disabled if 0
+	ReloadInterval time.Duration
+}
+
+func NewGeoIPOptions() GeoIPOptions {
+	return GeoIPOptions{
+		CacheSize:      10000,
+		ReloadInterval: time.Minute,
+	}
+}
+
+func NewGeoIPProcessor(options GeoIPOptions) *GeoIPProcessor {
+	return &GeoIPProcessor{
+		options: options,
+		cache:   newGeoCache(options.CacheSize),
+		log:     zap.S().With("component", "geoip-processor"),
+	}
+}
+
+func (g *GeoIPProcessor) Start() error {
+	if len(g.options.Fields) == 0 {
+		return fmt.Errorf("at least one GeoIP field is required")
+	}
+	if g.options.CityDatabase == "" && g.options.CountryDatabase == "" && g.options.ASNDatabase == "" {
+		return fmt.Errorf("at least one GeoIP database is required")
+	}
+
+	var err error
+	if g.options.CityDatabase != "" {
+		if g.city, err = openGeoDatabase(g.options.CityDatabase); err != nil {
+			return err
+		}
+	}
+	if g.options.CountryDatabase != "" {
+		if g.country, err = openGeoDatabase(g.options.CountryDatabase); err != nil {
+			return err
+		}
+	}
+	if g.options.ASNDatabase != "" {
+		if g.asn, err = openGeoDatabase(g.options.ASNDatabase); err != nil {
+			return err
+		}
+	}
+	g.lastReload = time.Now()
+
+	return nil
+}
+
+func (g *GeoIPProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	for _, field := range g.options.Fields {
+		value, ok := fieldValue(msg, field)
+		if !ok {
+			continue
+		}
+
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		ip := parseIP(str)
+		if ip == nil {
+			continue
+		}
+
+		g.appendResult(msg, strings.TrimPrefix(field, "_"), g.lookup(ip))
+	}
+
+	emit(msg)
+}
+
+func (g *GeoIPProcessor) Tick(now time.Time, emit EmitFunc) {
+	if g.options.ReloadInterval <= 0 || now.Sub(g.lastReload) < g.options.ReloadInterval {
+		return
+	}
+	g.lastReload = now
+
+	for _, db := range []*geoDatabase{g.city, g.country, g.asn} {
+		if db == nil {
+			continue
+		}
+
+		reloaded, err := db.reload()
+		if err != nil {
+			g.log.Warnw("Could not reload GeoIP database, keeping the previous one", "path", db.path, "err", err)
+			continue
+		}
+
+		if reloaded {
+			g.log.Infow("Reloaded GeoIP database", "path", db.path)
+			util.IncCounter("geoip.reloads")
+			g.cache.clear()
+		}
+	}
+}
+
+func (g *GeoIPProcessor) Stop() {
+	g.city.close()
+	g.country.close()
+	g.asn.close()
+}
+
+func (g *GeoIPProcessor) lookup(ip net.IP) geoResult {
+	key := ip.String()
+	if result, ok := g.cache.get(key); ok {
+		util.IncCounter("geoip.cache_hits")
+		return result
+	}
+	util.IncCounter("geoip.lookups")
+
+	result := geoResult{}
+
+	var country geoCityRecord
+	if g.country.lookup(ip, &country) {
+		result.country = country.Country.IsoCode
+	}
+
+	var city geoCityRecord
+	if g.city.lookup(ip, &city) {
+		if city.Country.IsoCode != "" {
+			result.country = city.Country.IsoCode
+		}
+		result.city = city.City.Names["en"]
+		result.location = city.Location.Latitude != 0 || city.Location.Longitude != 0
+		result.lat = city.Location.Latitude
+		result.lon = city.Location.Longitude
+	}
+
+	var asn geoASNRecord
+	if g.asn.lookup(ip, &asn) {
+		result.asn = asn.Number
+		result.asOrg = asn.Organization
+	}
+
+	g.cache.put(key, result)
+
+	return result
+}
+
+func (g *GeoIPProcessor) appendResult(msg *gelf.Message, prefix string, result geoResult) {
+	if result.country != "" {
+		util.AppendExtraToGelf(msg, prefix+"_geo_country", result.country)
+	}
+	if result.city != "" {
+		util.AppendExtraToGelf(msg, prefix+"_geo_city", result.city)
+	}
+	if result.location {
+		util.AppendExtraToGelf(msg, prefix+"_geo_lat", result.lat)
+		util.AppendExtraToGelf(msg, prefix+"_geo_lon", result.lon)
+	}
+	if result.asn != 0 {
+		util.AppendExtraToGelf(msg, prefix+"_geo_asn", int64(result.asn))
+	}
+	if result.asOrg != "" {
+		util.AppendExtraToGelf(msg, prefix+"_geo_as_org", result.asOrg)
+	}
+}
+
+// parseIP parses IP address, optionally followed by a port.
+func parseIP(str string) net.IP {
+	str = strings.TrimSpace(str)
+	if ip := net.ParseIP(str); ip != nil {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(str); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return nil
+}
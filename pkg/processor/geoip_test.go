@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newCachedGeoIP returns processor serving results from cache, so no database is needed.
+func newCachedGeoIP() *GeoIPProcessor {
+	options := NewGeoIPOptions()
+	options.Fields = []string{"remote_addr", "client_ip", "count"}
+
+	proc := NewGeoIPProcessor(options)
+	proc.cache.put("81.2.69.142", geoResult{country: "GB", city: "London", location: true, lat: 51.5, lon: -0.1})
+	proc.cache.put("1.1.1.1", geoResult{country: "AU", asn: 13335, asOrg: "Cloudflare"})
+
+	return proc
+}
+
+func TestGeoIPCity(t *testing.T) {
+	var emitted collector
+	msg := testMessage("h", "m", map[string]interface{}{"_remote_addr": "81.2.69.142:4211"})
+	newCachedGeoIP().Process(msg, emitted.emit)
+
+	expected := map[string]interface{}{
+		"_remote_addr":             "81.2.69.142:4211",
+		"_remote_addr_geo_country": "GB",
+		"_remote_addr_geo_city":    "London",
+		"_remote_addr_geo_lat":     51.5,
+		"_remote_addr_geo_lon":     -0.1,
+	}
+
+	if len(msg.Extra) != len(expected) {
+		t.Errorf("Process: expected %v, got %v", expected, msg.Extra)
+		return
+	}
+
+	for k, v := range expected {
+		if msg.Extra[k] != v {
+			t.Errorf("Process %s: expected %v, got %v", k, v, msg.Extra[k])
+		}
+	}
+}
+
+func TestGeoIPASN(t *testing.T) {
+	var emitted collector
+	msg := testMessage("h", "m", map[string]interface{}{"_client_ip": " 1.1.1.1 "})
+	newCachedGeoIP().Process(msg, emitted.emit)
+
+	expected := map[string]interface{}{
+		"_client_ip":             " 1.1.1.1 ",
+		"_client_ip_geo_country": "AU",
+		"_client_ip_geo_asn":     int64(13335),
+		"_client_ip_geo_as_org":  "Cloudflare",
+	}
+
+	if len(msg.Extra) != len(expected) {
+		t.Errorf("Process: expected %v, got %v", expected, msg.Extra)
+		return
+	}
+
+	for k, v := range expected {
+		if msg.Extra[k] != v {
+			t.Errorf("Process %s: expected %v, got %v", k, v, msg.Extra[k])
+		}
+	}
+}
+
+// tests that fields without known IP address are left alone
+func TestGeoIPSkipped(t *testing.T) {
+	fields := map[string]interface{}{
+		"_remote_addr": "localhost",
+		"_client_ip":   "10.0.0.1",
+		"_count":       1.0,
+	}
+
+	proc := newCachedGeoIP()
+
+	for k, v := range fields {
+		var emitted collector
+		msg := testMessage("h", "m", map[string]interface{}{k: v})
+		proc.Process(msg, emitted.emit)
+
+		if len(emitted.msgs) != 1 || len(msg.Extra) != 1 {
+			t.Errorf("Process %s: expected no fields to be added, got %v", k, msg.Extra)
+		}
+	}
+}
+
+func TestGeoCache(t *testing.T) {
+	cache := newGeoCache(2)
+	cache.put("a", geoResult{country: "A"})
+	cache.put("b", geoResult{country: "B"})
+
+	// a becomes the most recently used, so b is evicted
+	cache.get("a")
+	cache.put("c", geoResult{country: "C"})
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("get: expected least recently used entry to be evicted")
+	}
+
+	for ip, country := range map[string]string{"a": "A", "c": "C"} {
+		if result, ok := cache.get(ip); !ok || result.country != country {
+			t.Errorf("get %s: expected %s, got %s", ip, country, result.country)
+		}
+	}
+
+	cache.clear()
+	if _, ok := cache.get("a"); ok {
+		t.Errorf("get: expected cleared cache to be empty")
+	}
+
+	disabled := newGeoCache(0)
+	disabled.put("a", geoResult{country: "A"})
+	if _, ok := disabled.get("a"); ok {
+		t.Errorf("get: expected disabled cache to be empty")
+	}
+}
+
+func TestParseIP(t *testing.T) {
+	addrs := map[string]string{
+		"10.0.0.1":          "10.0.0.1",
+		" 10.0.0.1\n":       "10.0.0.1",
+		"10.0.0.1:80":       "10.0.0.1",
+		"2001:db8::1":       "2001:db8::1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"example.com:80":    "<nil>",
+		"":                  "<nil>",
+	}
+
+	for addr, expected := range addrs {
+		if got := parseIP(addr).String(); got != expected {
+			t.Errorf("parseIP %q: expected %s, got %s", addr, expected, got)
+		}
+	}
+}
+
+func TestGeoIPStartInvalid(t *testing.T) {
+	invalid := map[string]GeoIPOptions{
+		"fields":        {CityDatabase: "city.mmdb"},
+		"database":      {Fields: []string{"remote_addr"}},
+		"database path": {Fields: []string{"remote_addr"}, CityDatabase: filepath.Join(t.TempDir(), "missing.mmdb")},
+	}
+
+	for name, options := range invalid {
+		if err := NewGeoIPProcessor(options).Start(); err == nil {
+			t.Errorf("Start: expected error for invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const podIPIndex = "ip"
+
+// KubernetesProcessor adds metadata of pods that sent the message, pods are watched using Kubernetes API.
+type KubernetesProcessor struct {
+	options  KubernetesOptions
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+	labels   map[string]bool
+	annots   map[string]bool
+	log      *zap.SugaredLogger
+}
+
+type KubernetesOptions struct {
+	// Client is used instead of one created from KubeConfig if set
+	Client kubernetes.Interface
+	// KubeConfig is path to kubeconfig file, in-cluster configuration is used if empty
+	KubeConfig string
+	// Namespace limits watched pods to a single namespace, all namespaces are watched if empty
+	Namespace string
+	// NodeName limits watched pods to a single node, useful when running next to the senders
+	NodeName string
+	// Fields identifying the pod, IPField is used if name or namespace is missing
+	NameField      string
+	NamespaceField string
+	ContainerField string
+	IPField        string
+	// Labels and Annotations added to messages, * means all
+	Labels      []string
+	Annotations []string
+	// Prefix of added fields
+	Prefix      string
+	SyncTimeout time.Duration
+}
+
+func NewKubernetesOptions() KubernetesOptions {
+	return KubernetesOptions{
+		NameField:      "pod_name",
+		NamespaceField: "namespace",
+		ContainerField: "container_name",
+		IPField:        "remote_addr",
+		Labels:         []string{"*"},
+		Prefix:         "k8s_",
+		SyncTimeout:    time.Minute,
+	}
+}
+
+func NewKubernetesProcessor(options KubernetesOptions) *KubernetesProcessor {
+	return &KubernetesProcessor{
+		options: options,
+		stopCh:  make(chan struct{}),
+		labels:  make(map[string]bool),
+		annots:  make(map[string]bool),
+		log:     zap.S().With("component", "kubernetes-processor"),
+	}
+}
+
+func (k *KubernetesProcessor) Start() error {
+	client := k.options.Client
+	if client == nil {
+		config, err := k.restConfig()
+		if err != nil {
+			return fmt.Errorf("could not load Kubernetes configuration: %w", err)
+		}
+
+		if client, err = kubernetes.NewForConfig(config); err != nil {
+			return fmt.Errorf("could not create Kubernetes client: %w", err)
+		}
+	}
+
+	for _, label := range k.options.Labels {
+		k.labels[label] = true
+	}
+	for _, annotation := range k.options.Annotations {
+		k.annots[annotation] = true
+	}
+
+	factoryOpts := []informers.SharedInformerOption{informers.WithNamespace(k.options.Namespace)}
+	if k.options.NodeName != "" {
+		selector := fields.OneTermEqualSelector("spec.nodeName", k.options.NodeName).String()
+		factoryOpts = append(factoryOpts, informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = selector
+		}))
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, factoryOpts...)
+	k.informer = factory.Core().V1().Pods().Informer()
+
+	err := k.informer.AddIndexers(cache.Indexers{podIPIndex: indexPodIPs})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(k.stopCh)
+
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(k.options.SyncTimeout, func() {
+		close(timeoutCh)
+	})
+	defer timer.Stop()
+
+	if !cache.WaitForCacheSync(timeoutCh, k.informer.HasSynced) {
+		close(k.stopCh)
+		return fmt.Errorf("timed out waiting for pods to be listed from Kubernetes API")
+	}
+
+	k.log.Infof("Watching %v pods", len(k.informer.GetStore().ListKeys()))
+
+	return nil
+}
+
+func (k *KubernetesProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	if pod := k.findPod(msg); pod != nil {
+		util.IncCounter("kubernetes.found")
+		k.appendPod(msg, pod)
+	} else {
+		util.IncCounter("kubernetes.not_found")
+	}
+
+	emit(msg)
+}
+
+func (k *KubernetesProcessor) Stop() {
+	close(k.stopCh)
+}
+
+func (k *KubernetesProcessor) restConfig() (*rest.Config, error) {
+	if k.options.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+
+	return clientcmd.BuildConfigFromFlags("", k.options.KubeConfig)
+}
+
+func (k *KubernetesProcessor) findPod(msg *gelf.Message) *corev1.Pod {
+	name := fieldString(msg, k.options.NameField)
+	namespace := fieldString(msg, k.options.NamespaceField)
+
+	if name != "" && namespace != "" {
+		obj, exists, err := k.informer.GetIndexer().GetByKey(namespace + "/" + name)
+		if err == nil && exists {
+			return obj.(*corev1.Pod)
+		}
+	}
+
+	if k.options.IPField == "" {
+		return nil
+	}
+
+	ip := parseIP(fieldString(msg, k.options.IPField))
+	if ip == nil {
+		return nil
+	}
+
+	objs, err := k.informer.GetIndexer().ByIndex(podIPIndex, ip.String())
+	if err != nil {
+		return nil
+	}
+
+	// IPs of finished pods may be reused, prefer the running one
+	var found *corev1.Pod
+	for _, obj := range objs {
+		pod := obj.(*corev1.Pod)
+		if found == nil || pod.Status.Phase == corev1.PodRunning {
+			found = pod
+		}
+	}
+
+	return found
+}
+
+func (k *KubernetesProcessor) appendPod(msg *gelf.Message, pod *corev1.Pod) {
+	prefix := k.options.Prefix
+
+	util.AppendExtraToGelf(msg, prefix+"namespace", pod.Namespace)
+	util.AppendExtraToGelf(msg, prefix+"pod_name", pod.Name)
+
+	if pod.Spec.NodeName != "" {
+		util.AppendExtraToGelf(msg, prefix+"node", pod.Spec.NodeName)
+	}
+
+	if kind, name := podOwner(pod); kind != "" {
+		util.AppendExtraToGelf(msg, prefix+"owner_kind", kind)
+		util.AppendExtraToGelf(msg, prefix+"owner_name", name)
+	}
+
+	if image := podImage(pod, fieldString(msg, k.options.ContainerField)); image != "" {
+		util.AppendExtraToGelf(msg, prefix+"container_image", image)
+	}
+
+	for key, value := range pod.Labels {
+		if k.labels["*"] || k.labels[key] {
+			util.AppendExtraToGelf(msg, prefix+"label_"+key, value)
+		}
+	}
+
+	for key, value := range pod.Annotations {
+		if k.annots["*"] || k.annots[key] {
+			util.AppendExtraToGelf(msg, prefix+"annotation_"+key, value)
+		}
+	}
+}
+
+// podOwner returns the workload controlling the pod, ReplicaSets created by Deployments are resolved to the Deployment.
+func podOwner(pod *corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		hash := pod.Labels["pod-template-hash"]
+		if hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+
+	return owner.Kind, owner.Name
+}
+
+// podImage returns image of the named container, or the first one if name is empty or unknown.
+func podImage(pod *corev1.Pod, container string) string {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return c.Image
+		}
+	}
+
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Image
+	}
+
+	return ""
+}
+
+func indexPodIPs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+
+	ips := make([]string, 0, len(pod.Status.PodIPs))
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,196 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(namespace, name, ip string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"app": name, "pod-template-hash": "5d8f"},
+			Annotations: map[string]string{
+				"team": "infra",
+				"note": "ignored",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "ReplicaSet",
+				Name:       name + "-5d8f",
+				Controller: boolPtr(true),
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{
+				{Name: "app", Image: "app:1.0"},
+				{Name: "sidecar", Image: "sidecar:2.0"},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			PodIP: ip,
+		},
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func startKubernetes(t *testing.T, options KubernetesOptions, pods ...*corev1.Pod) *KubernetesProcessor {
+	t.Helper()
+
+	client := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		if err := client.Tracker().Add(pod); err != nil {
+			t.Fatalf("adding pod: %s", err)
+		}
+	}
+
+	options.Client = client
+	options.SyncTimeout = 5 * time.Second
+
+	proc := NewKubernetesProcessor(options)
+	if err := proc.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	t.Cleanup(proc.Stop)
+
+	return proc
+}
+
+func TestKubernetesFindPod(t *testing.T) {
+	hostNetwork := testPod("default", "node-agent", "10.0.0.5", corev1.PodRunning)
+	hostNetwork.Spec.HostNetwork = true
+
+	proc := startKubernetes(t, NewKubernetesOptions(),
+		testPod("default", "web", "10.1.0.1", corev1.PodRunning),
+		testPod("jobs", "finished", "10.1.0.2", corev1.PodSucceeded),
+		testPod("jobs", "reused", "10.1.0.2", corev1.PodRunning),
+		hostNetwork,
+	)
+
+	// additional fields of messages keyed by name of the pod they should be matched with
+	lookups := map[string][]map[string]interface{}{
+		"web": {
+			{"_pod_name": "web", "_namespace": "default"},
+			{"_remote_addr": "10.1.0.1"},
+			{"_remote_addr": "10.1.0.1:53211"},
+			// name missing in namespace falls back to IP
+			{"_pod_name": "gone", "_namespace": "default", "_remote_addr": "10.1.0.1"},
+		},
+		// reused IP prefers running pod
+		"reused": {
+			{"_remote_addr": "10.1.0.2"},
+		},
+		"": {
+			// host network pods aren't indexed by IP
+			{"_remote_addr": "10.0.0.5"},
+			{"_remote_addr": "10.9.9.9"},
+			{},
+		},
+	}
+
+	for expected, extras := range lookups {
+		for _, extra := range extras {
+			got := ""
+			if pod := proc.findPod(&gelf.Message{Host: "h", Short: "m", Extra: extra}); pod != nil {
+				got = pod.Name
+			}
+
+			if got != expected {
+				t.Errorf("findPod %v: expected %q, got %q", extra, expected, got)
+			}
+		}
+	}
+}
+
+func TestKubernetesProcess(t *testing.T) {
+	options := NewKubernetesOptions()
+	options.Labels = []string{"app"}
+	options.Annotations = []string{"team"}
+
+	proc := startKubernetes(t, options, testPod("default", "web", "10.1.0.1", corev1.PodRunning))
+
+	msg := &gelf.Message{
+		Host:  "h",
+		Short: "m",
+		Extra: map[string]interface{}{"_remote_addr": "10.1.0.1", "_container_name": "sidecar"},
+	}
+
+	var emitted collector
+	proc.Process(msg, emitted.emit)
+
+	if len(emitted.msgs) != 1 {
+		t.Errorf("Process: expected 1 message, got %d", len(emitted.msgs))
+		return
+	}
+
+	want := map[string]interface{}{
+		"_k8s_namespace":       "default",
+		"_k8s_pod_name":        "web",
+		"_k8s_node":            "node-1",
+		"_k8s_owner_kind":      "Deployment",
+		"_k8s_owner_name":      "web",
+		"_k8s_container_image": "sidecar:2.0",
+		"_k8s_label_app":       "web",
+		"_k8s_annotation_team": "infra",
+	}
+
+	for key, value := range want {
+		if got := emitted.msgs[0].Extra[key]; got != value {
+			t.Errorf("Process %s: expected %v, got %v", key, value, got)
+		}
+	}
+
+	for _, key := range []string{"_k8s_label_pod-template-hash", "_k8s_annotation_note"} {
+		if _, ok := emitted.msgs[0].Extra[key]; ok {
+			t.Errorf("Process %s: expected field to be filtered out", key)
+		}
+	}
+}
+
+// ownerOf returns kind and name of pod owner as kind/name.
+func ownerOf(kind, name string, controller bool, hash string) string {
+	pod := &corev1.Pod{}
+	if kind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: name, Controller: boolPtr(controller)}}
+	}
+	if hash != "" {
+		pod.Labels = map[string]string{"pod-template-hash": hash}
+	}
+
+	ownerKind, ownerName := podOwner(pod)
+	return ownerKind + "/" + ownerName
+}
+
+func TestPodOwner(t *testing.T) {
+	owners := map[string]string{
+		"deployment":       ownerOf("ReplicaSet", "web-5d8f", true, "5d8f"),
+		"bare replica set": ownerOf("ReplicaSet", "web", true, ""),
+		"stateful set":     ownerOf("StatefulSet", "db", true, ""),
+		"not controller":   ownerOf("StatefulSet", "db", false, ""),
+		"no owner":         ownerOf("", "", false, ""),
+	}
+
+	expected := map[string]string{
+		"deployment":       "Deployment/web",
+		"bare replica set": "ReplicaSet/web",
+		"stateful set":     "StatefulSet/db",
+		"not controller":   "/",
+		"no owner":         "/",
+	}
+
+	for name, got := range owners {
+		if got != expected[name] {
+			t.Errorf("podOwner %s: expected %s, got %s", name, expected[name], got)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const (
+	// matches indented lines and chained exceptions of Java and Python stack traces
+	DefaultContinuationPattern = `^[\t ]+|^Caused by:|^\.\.\. \d+ more`
+)
+
+type multilineGroup struct {
+	msg      *gelf.Message
+	lines    []string
+	bytes    int
+	lastSeen time.Time
+}
+
+// MultilineProcessor joins consecutive messages of the same stream, such as lines of a stack trace, into one message.
+type MultilineProcessor struct {
+	options      MultilineOptions
+	keyFields    []string
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	groups       map[string]*multilineGroup
+}
+
+type MultilineOptions struct {
+	// KeyFields identify streams on top of host
+	KeyFields []string
+	// StartPattern matches first line of a message, other lines are appended to the previous one
+	StartPattern string
+	// ContinuationPattern matches lines appended to the previous one, used if StartPattern is empty
+	ContinuationPattern string
+	// FlushTimeout is how long to wait for more lines before message is emitted, every message is delayed by up
+	// to this long, even one that has no continuation lines, unless the next line of its stream starts a new one
+	FlushTimeout time.Duration
+	MaxLines     int
+	MaxBytes     int
+}
+
+func NewMultilineOptions() MultilineOptions {
+	return MultilineOptions{
+		ContinuationPattern: DefaultContinuationPattern,
+		FlushTimeout:        time.Second,
+		MaxLines:            500,
+		MaxBytes:            65536,
+	}
+}
+
+func NewMultilineProcessor(options MultilineOptions) *MultilineProcessor {
+	return &MultilineProcessor{
+		options:   options,
+		keyFields: append([]string{"host"}, options.KeyFields...),
+		groups:    make(map[string]*multilineGroup),
+	}
+}
+
+func (m *MultilineProcessor) Start() error {
+	var err error
+
+	switch {
+	case m.options.StartPattern != "":
+		if m.start, err = regexp.Compile(m.options.StartPattern); err != nil {
+			return fmt.Errorf("invalid multiline start pattern: %w", err)
+		}
+	case m.options.ContinuationPattern != "":
+		if m.continuation, err = regexp.Compile(m.options.ContinuationPattern); err != nil {
+			return fmt.Errorf("invalid multiline continuation pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("either multiline start or continuation pattern is required")
+	}
+
+	if m.options.FlushTimeout <= 0 || m.options.MaxLines < 1 || m.options.MaxBytes < 1 {
+		return fmt.Errorf("multiline flush timeout, max lines and max bytes need to be positive")
+	}
+
+	return nil
+}
+
+func (m *MultilineProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	key := fieldsKey(msg, m.keyFields)
+	group, ok := m.groups[key]
+
+	if ok && m.continues(msg.Short) && m.fits(group, msg.Short) {
+		group.lines = append(group.lines, msg.Short)
+		group.bytes += len(msg.Short) + 1
+		group.lastSeen = time.Now()
+		return
+	}
+
+	if ok {
+		m.emit(key, group, emit)
+	}
+
+	m.groups[key] = &multilineGroup{
+		msg:      msg,
+		lines:    []string{msg.Short},
+		bytes:    len(msg.Short),
+		lastSeen: time.Now(),
+	}
+}
+
+func (m *MultilineProcessor) Tick(now time.Time, emit EmitFunc) {
+	for _, key := range m.sortedKeys() {
+		group := m.groups[key]
+		if now.Sub(group.lastSeen) >= m.options.FlushTimeout {
+			m.emit(key, group, emit)
+		}
+	}
+}
+
+func (m *MultilineProcessor) Flush(emit EmitFunc) {
+	for _, key := range m.sortedKeys() {
+		m.emit(key, m.groups[key], emit)
+	}
+}
+
+// continues returns whether line belongs to the previous message.
+func (m *MultilineProcessor) continues(line string) bool {
+	if m.start != nil {
+		return !m.start.MatchString(line)
+	}
+
+	return m.continuation.MatchString(line)
+}
+
+func (m *MultilineProcessor) fits(group *multilineGroup, line string) bool {
+	return len(group.lines) < m.options.MaxLines && group.bytes+len(line)+1 <= m.options.MaxBytes
+}
+
+func (m *MultilineProcessor) emit(key string, group *multilineGroup, emit EmitFunc) {
+	delete(m.groups, key)
+
+	if len(group.lines) > 1 {
+		util.IncCounter("multiline.merged")
+		util.AddCounter("multiline.lines", int64(len(group.lines)))
+		// full_message of the first line, such as original text of oversized one, is kept in place of its short version
+		lines := group.lines
+		if group.msg.Full != "" {
+			lines = append([]string{group.msg.Full}, lines[1:]...)
+		}
+		group.msg.Full = strings.Join(lines, "\n")
+	}
+
+	emit(group.msg)
+}
+
+func (m *MultilineProcessor) sortedKeys() []string {
+	keys := make([]string, 0, len(m.groups))
+	for key := range m.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,203 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMultiline(t *testing.T, configure func(options *MultilineOptions)) *MultilineProcessor {
+	t.Helper()
+
+	options := NewMultilineOptions()
+	if configure != nil {
+		configure(&options)
+	}
+
+	proc := NewMultilineProcessor(options)
+	startProcessor(t, proc)
+
+	return proc
+}
+
+// fulls returns full messages of collected messages separated with |, short message if lines weren't joined.
+func fulls(c *collector) string {
+	fulls := make([]string, len(c.msgs))
+	for i, msg := range c.msgs {
+		fulls[i] = msg.Full
+		if fulls[i] == "" {
+			fulls[i] = msg.Short
+		}
+	}
+
+	return strings.Join(fulls, "|")
+}
+
+func TestMultilineStackTrace(t *testing.T) {
+	proc := newTestMultiline(t, nil)
+
+	var emitted collector
+	for _, line := range []string{
+		"Exception in thread main",
+		"\tat Main.main(Main.java:1)",
+		"Caused by: java.io.IOException",
+		"\t... 3 more",
+		"next",
+	} {
+		proc.Process(testMessage("a", line, nil), emitted.emit)
+	}
+	proc.Flush(emitted.emit)
+
+	expected := "Exception in thread main\n\tat Main.main(Main.java:1)\nCaused by: java.io.IOException\n\t... 3 more|next"
+	if fulls(&emitted) != expected {
+		t.Errorf("Process: expected %q, got %q", expected, fulls(&emitted))
+		return
+	}
+
+	if emitted.msgs[0].Short != "Exception in thread main" {
+		t.Errorf("Process: expected first line as short message, got %q", emitted.msgs[0].Short)
+	}
+}
+
+// tests that full message of oversized first line isn't replaced by its truncated short message
+func TestMultilineFullMessage(t *testing.T) {
+	proc := newTestMultiline(t, nil)
+
+	first := testMessage("a", "Exception...", nil)
+	first.Full = "Exception in thread main"
+
+	var emitted collector
+	proc.Process(first, emitted.emit)
+	proc.Process(testMessage("a", "\tat Main.main(Main.java:1)", nil), emitted.emit)
+	proc.Flush(emitted.emit)
+
+	expected := "Exception in thread main\n\tat Main.main(Main.java:1)"
+	if fulls(&emitted) != expected {
+		t.Errorf("Process: expected %q, got %q", expected, fulls(&emitted))
+	}
+}
+
+func TestMultilineStartPattern(t *testing.T) {
+	proc := newTestMultiline(t, func(options *MultilineOptions) {
+		options.StartPattern = `^\d{4}-`
+	})
+
+	var emitted collector
+	for _, line := range []string{"2020-01-01 first", "detail", "2020-01-01 second"} {
+		proc.Process(testMessage("a", line, nil), emitted.emit)
+	}
+	proc.Flush(emitted.emit)
+
+	expected := "2020-01-01 first\ndetail|2020-01-01 second"
+	if fulls(&emitted) != expected {
+		t.Errorf("Process: expected %q, got %q", expected, fulls(&emitted))
+	}
+}
+
+func TestMultilineStreams(t *testing.T) {
+	proc := newTestMultiline(t, nil)
+
+	var emitted collector
+	proc.Process(testMessage("a", "a1", nil), emitted.emit)
+	proc.Process(testMessage("b", "b1", nil), emitted.emit)
+	proc.Process(testMessage("a", " a2", nil), emitted.emit)
+	proc.Process(testMessage("b", " b2", nil), emitted.emit)
+	proc.Flush(emitted.emit)
+
+	if fulls(&emitted) != "a1\n a2|b1\n b2" {
+		t.Errorf("Process: expected hosts to be grouped separately, got %q", fulls(&emitted))
+	}
+}
+
+func TestMultilineKeyFields(t *testing.T) {
+	proc := newTestMultiline(t, func(options *MultilineOptions) {
+		options.KeyFields = []string{"container"}
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "x1", map[string]interface{}{"_container": "x"}), emitted.emit)
+	proc.Process(testMessage("a", "y1", map[string]interface{}{"_container": "y"}), emitted.emit)
+	proc.Process(testMessage("a", " x2", map[string]interface{}{"_container": "x"}), emitted.emit)
+	proc.Flush(emitted.emit)
+
+	if fulls(&emitted) != "x1\n x2|y1" {
+		t.Errorf("Process: expected x1\\n x2|y1, got %q", fulls(&emitted))
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	proc := newTestMultiline(t, func(options *MultilineOptions) {
+		options.MaxLines = 2
+	})
+
+	var emitted collector
+	for _, line := range []string{"1", " 2", " 3"} {
+		proc.Process(testMessage("a", line, nil), emitted.emit)
+	}
+	proc.Flush(emitted.emit)
+
+	if fulls(&emitted) != "1\n 2| 3" {
+		t.Errorf("Process: expected 1\\n 2| 3, got %q", fulls(&emitted))
+	}
+}
+
+func TestMultilineMaxBytes(t *testing.T) {
+	proc := newTestMultiline(t, func(options *MultilineOptions) {
+		options.MaxBytes = 5
+	})
+
+	var emitted collector
+	for _, line := range []string{"12", " 3", " 4"} {
+		proc.Process(testMessage("a", line, nil), emitted.emit)
+	}
+	proc.Flush(emitted.emit)
+
+	if fulls(&emitted) != "12\n 3| 4" {
+		t.Errorf("Process: expected 12\\n 3| 4, got %q", fulls(&emitted))
+	}
+}
+
+func TestMultilineTick(t *testing.T) {
+	proc := newTestMultiline(t, func(options *MultilineOptions) {
+		options.FlushTimeout = time.Second
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", " 2", nil), emitted.emit)
+
+	proc.Tick(time.Now(), emitted.emit)
+	if len(emitted.msgs) != 0 {
+		t.Errorf("Tick: expected message to be held before timeout, got %s", emitted.shorts())
+		return
+	}
+
+	proc.Tick(time.Now().Add(time.Second), emitted.emit)
+	if fulls(&emitted) != "1\n 2" {
+		t.Errorf("Tick: expected joined message after timeout, got %q", fulls(&emitted))
+		return
+	}
+
+	if len(proc.groups) != 0 {
+		t.Errorf("Tick: expected no groups left, got %d", len(proc.groups))
+	}
+}
+
+func TestMultilineStartInvalid(t *testing.T) {
+	invalid := map[string]func(options *MultilineOptions){
+		"missing pattern":      func(options *MultilineOptions) { options.ContinuationPattern = "" },
+		"start pattern":        func(options *MultilineOptions) { options.StartPattern = "(" },
+		"continuation pattern": func(options *MultilineOptions) { options.ContinuationPattern = "(" },
+		"max lines":            func(options *MultilineOptions) { options.MaxLines = 0 },
+		"flush timeout":        func(options *MultilineOptions) { options.FlushTimeout = 0 },
+	}
+
+	for name, configure := range invalid {
+		options := NewMultilineOptions()
+		configure(&options)
+
+		if err := NewMultilineProcessor(options).Start(); err == nil {
+			t.Errorf("Start: expected error for invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.uber.org/zap"
+)
+
+// Pipeline runs messages received from input through processors and passes them on to output.
+type Pipeline struct {
+	processors   []Processor
+	outCh        chan *gelf.Message
+	outDone      chan interface{}
+	outLost      bool
+	tickInterval time.Duration
+	log          *zap.SugaredLogger
+}
+
+type PipelineOptions struct {
+	Processors []Processor
+	Output     chan *gelf.Message
+	// OutputDone is closed when output stops reading messages, so that pipeline doesn't block on a dead output
+	OutputDone   chan interface{}
+	TickInterval time.Duration
+}
+
+func NewPipelineOptions() PipelineOptions {
+	return PipelineOptions{
+		TickInterval: time.Second,
+	}
+}
+
+func NewPipeline(options PipelineOptions) *Pipeline {
+	return &Pipeline{
+		processors:   options.Processors,
+		outCh:        options.Output,
+		outDone:      options.OutputDone,
+		tickInterval: options.TickInterval,
+		log:          zap.S().With("component", "pipeline"),
+	}
+}
+
+func (p *Pipeline) Start() error {
+	for _, proc := range p.processors {
+		if starter, ok := proc.(Starter); ok {
+			if err := starter.Start(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Pipeline) Listen(msgCh chan *gelf.Message, stopCh chan interface{}) error {
+	ticker := time.NewTicker(p.tickInterval)
+	defer ticker.Stop()
+
+	p.log.Infof("Running %v processors", len(p.processors))
+
+	for {
+		select {
+		case <-stopCh:
+			p.stop(msgCh)
+			// output reads until the channel is closed, so that flushed messages aren't lost
+			close(p.outCh)
+			return nil
+		case msg := <-msgCh:
+			p.process(0, msg)
+		case now := <-ticker.C:
+			p.tick(now)
+		}
+	}
+}
+
+// stop processes messages left by stopped input and flushes messages held by processors.
+func (p *Pipeline) stop(msgCh chan *gelf.Message) {
+	for drained := false; !drained; {
+		select {
+		case msg := <-msgCh:
+			p.process(0, msg)
+		default:
+			drained = true
+		}
+	}
+
+	for i, proc := range p.processors {
+		if flusher, ok := proc.(Flusher); ok {
+			flusher.Flush(p.emitter(i + 1))
+		}
+	}
+
+	for _, proc := range p.processors {
+		if stopper, ok := proc.(Stopper); ok {
+			stopper.Stop()
+		}
+	}
+}
+
+func (p *Pipeline) tick(now time.Time) {
+	for i, proc := range p.processors {
+		if ticker, ok := proc.(Ticker); ok {
+			ticker.Tick(now, p.emitter(i+1))
+		}
+	}
+}
+
+// process runs message through processors starting at the given index.
+func (p *Pipeline) process(index int, msg *gelf.Message) {
+	if index < len(p.processors) {
+		p.processors[index].Process(msg, p.emitter(index+1))
+		return
+	}
+
+	select {
+	case p.outCh <- msg:
+	case <-p.outDone:
+		if !p.outLost {
+			p.log.Warnf("Output stopped, dropping messages")
+			p.outLost = true
+		}
+		util.IncCounter("pipeline.dropped")
+	}
+}
+
+func (p *Pipeline) emitter(index int) EmitFunc {
+	return func(msg *gelf.Message) {
+		p.process(index, msg)
+	}
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// holdProcessor holds every message until flushed.
+type holdProcessor struct {
+	held    []*gelf.Message
+	stopped bool
+}
+
+func (h *holdProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	h.held = append(h.held, msg)
+}
+
+func (h *holdProcessor) Flush(emit EmitFunc) {
+	for _, msg := range h.held {
+		emit(msg)
+	}
+	h.held = nil
+}
+
+func (h *holdProcessor) Stop() {
+	h.stopped = true
+}
+
+// runPipeline sends messages through the pipeline, stops it and returns short messages received by output.
+func runPipeline(processors []Processor, msgs ...string) (string, error) {
+	options := NewPipelineOptions()
+	options.Processors = processors
+	options.Output = make(chan *gelf.Message, len(msgs))
+	options.TickInterval = time.Hour
+
+	pipeline := NewPipeline(options)
+	if err := pipeline.Start(); err != nil {
+		return "", err
+	}
+
+	msgCh := make(chan *gelf.Message)
+	stopCh := make(chan interface{})
+	done := make(chan error)
+	go func() {
+		done <- pipeline.Listen(msgCh, stopCh)
+	}()
+
+	for _, short := range msgs {
+		msgCh <- testMessage("h", short, nil)
+	}
+	close(stopCh)
+
+	if err := <-done; err != nil {
+		return "", err
+	}
+
+	var shorts []string
+	for msg := range options.Output {
+		shorts = append(shorts, msg.Short)
+	}
+
+	return strings.Join(shorts, ","), nil
+}
+
+func TestPipelineNoProcessors(t *testing.T) {
+	got, err := runPipeline(nil, "1", "2")
+	if err != nil {
+		t.Errorf("runPipeline: %s", err)
+		return
+	}
+
+	if got != "1,2" {
+		t.Errorf("runPipeline: expected 1,2, got %s", got)
+	}
+}
+
+// tests that held messages go through the following processors when pipeline stops
+func TestPipelineFlush(t *testing.T) {
+	hold := &holdProcessor{}
+
+	got, err := runPipeline([]Processor{hold, NewRedactProcessor(NewRedactOptions())}, "a@example.com", "b")
+	if err != nil {
+		t.Errorf("runPipeline: %s", err)
+		return
+	}
+
+	if got != "[REDACTED:email],b" {
+		t.Errorf("runPipeline: expected [REDACTED:email],b, got %s", got)
+	}
+
+	if !hold.stopped {
+		t.Errorf("runPipeline: expected processor to be stopped")
+	}
+}
+
+func TestPipelineStartError(t *testing.T) {
+	options := NewRedactOptions()
+	options.Action = "erase"
+
+	if _, err := runPipeline([]Processor{NewRedactProcessor(options)}); err == nil {
+		t.Errorf("runPipeline: expected start error")
+	}
+}
+
+// tests that pipeline holding messages can stop after output failed and stopped reading
+func TestPipelineOutputDone(t *testing.T) {
+	options := NewPipelineOptions()
+	options.Processors = []Processor{&holdProcessor{}}
+	options.Output = make(chan *gelf.Message)
+	options.OutputDone = make(chan interface{})
+	options.TickInterval = time.Hour
+
+	pipeline := NewPipeline(options)
+	if err := pipeline.Start(); err != nil {
+		t.Errorf("Start: %s", err)
+		return
+	}
+
+	msgCh := make(chan *gelf.Message)
+	stopCh := make(chan interface{})
+	done := make(chan error)
+	go func() {
+		done <- pipeline.Listen(msgCh, stopCh)
+	}()
+
+	msgCh <- testMessage("h", "1", nil)
+	msgCh <- testMessage("h", "2", nil)
+
+	close(options.OutputDone)
+	close(stopCh)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Listen: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Listen: pipeline didn't stop after output was gone")
+	}
+}
@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// EmitFunc passes message to the next processor in the pipeline.
+type EmitFunc func(msg *gelf.Message)
+
+// Processor transforms messages between input and output.
+type Processor interface {
+	// Process handles a single message, emit needs to be called for every message that should be passed on.
+	// Not calling emit drops the message.
+	Process(msg *gelf.Message, emit EmitFunc)
+}
+
+// Starter is implemented by processors that need to validate options or load resources before processing.
+type Starter interface {
+	Start() error
+}
+
+// Ticker is implemented by processors that need to emit messages periodically, for example to flush buffers.
+type Ticker interface {
+	Tick(now time.Time, emit EmitFunc)
+}
+
+// Flusher is implemented by processors holding messages, it's called on shutdown.
+type Flusher interface {
+	Flush(emit EmitFunc)
+}
+
+// Stopper is implemented by processors running background routines.
+type Stopper interface {
+	Stop()
+}
@@ -0,0 +1,43 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+// collector records messages passed to its emit function.
+type collector struct {
+	msgs []*gelf.Message
+}
+
+func (c *collector) emit(msg *gelf.Message) {
+	c.msgs = append(c.msgs, msg)
+}
+
+// shorts returns short messages of collected messages joined with commas.
+func (c *collector) shorts() string {
+	shorts := make([]string, len(c.msgs))
+	for i, msg := range c.msgs {
+		shorts[i] = msg.Short
+	}
+
+	return strings.Join(shorts, ",")
+}
+
+func testMessage(host, short string, extra map[string]interface{}) *gelf.Message {
+	if extra == nil {
+		extra = make(map[string]interface{})
+	}
+
+	return &gelf.Message{Version: "1.1", Host: host, Short: short, TimeUnix: 1600000000, Extra: extra}
+}
+
+func startProcessor(t *testing.T, proc Starter) {
+	t.Helper()
+
+	if err := proc.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+}
@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+)
+
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+type redactDetector struct {
+	name     string
+	pattern  *regexp.Regexp
+	validate func(match string) bool
+}
+
+var builtinDetectors = map[string]redactDetector{
+	"pan": {
+		pattern:  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		validate: luhnValid,
+	},
+	"email": {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"ipv4": {
+		pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`),
+	},
+	"ipv6": {
+		pattern: regexp.MustCompile(`(?i)(?:[0-9a-f]{1,4}|:)(?::[0-9a-f]{0,4}){1,7}(?:(?:\d{1,3}\.){3}\d{1,3})?`),
+		validate: func(match string) bool {
+			return net.ParseIP(match) != nil
+		},
+	},
+	"jwt": {
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	"aws-key": {
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`),
+	},
+	"bearer": {
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	},
+	"password": {
+		pattern: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd)["']?\s*[=:]\s*["']?[^\s"',;&]+`),
+	},
+}
+
+// RedactProcessor masks, hashes or drops sensitive data found in messages.
+type RedactProcessor struct {
+	options   RedactOptions
+	detectors []redactDetector
+	fields    map[string]bool
+	allFields bool
+}
+
+type RedactOptions struct {
+	// Detectors are names of builtin detectors: pan, email, ipv4, ipv6, jwt, aws-key, bearer, password
+	Detectors []string
+	// Patterns are custom detectors, keyed by name
+	Patterns map[string]string
+	// Fields are additional fields scanned on top of short and full message, * means all string fields
+	Fields []string
+	// Action taken on match: mask, hash (HMAC-SHA256 using HashKey) or drop
+	Action  string
+	HashKey string
+}
+
+func NewRedactOptions() RedactOptions {
+	return RedactOptions{
+		Detectors: []string{"pan", "email", "jwt", "aws-key", "bearer", "password"},
+		Action:    RedactMask,
+	}
+}
+
+func NewRedactProcessor(options RedactOptions) *RedactProcessor {
+	return &RedactProcessor{
+		options: options,
+		fields:  make(map[string]bool),
+	}
+}
+
+func (r *RedactProcessor) Start() error {
+	switch r.options.Action {
+	case RedactMask, RedactDrop:
+	case RedactHash:
+		if r.options.HashKey == "" {
+			return fmt.Errorf("hash key is required for hash redact action")
+		}
+	default:
+		return fmt.Errorf("invalid redact action: %v", r.options.Action)
+	}
+
+	for _, name := range r.options.Detectors {
+		detector, ok := builtinDetectors[name]
+		if !ok {
+			return fmt.Errorf("unknown redact detector: %v", name)
+		}
+		detector.name = name
+		r.detectors = append(r.detectors, detector)
+	}
+
+	names := make([]string, 0, len(r.options.Patterns))
+	for name := range r.options.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		re, err := regexp.Compile(r.options.Patterns[name])
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %v: %w", name, err)
+		}
+		r.detectors = append(r.detectors, redactDetector{name: name, pattern: re})
+	}
+
+	for _, field := range r.options.Fields {
+		if field == "*" {
+			r.allFields = true
+			continue
+		}
+		r.fields["_"+strings.TrimPrefix(field, "_")] = true
+	}
+
+	return nil
+}
+
+func (r *RedactProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	if short, found := r.redact(msg.Short); found {
+		if r.options.Action == RedactDrop {
+			short = "[REDACTED]"
+		}
+		msg.Short = short
+	}
+
+	if full, found := r.redact(msg.Full); found {
+		if r.options.Action == RedactDrop {
+			full = ""
+		}
+		msg.Full = full
+	}
+
+	for key, value := range msg.Extra {
+		str, ok := value.(string)
+		if !ok || (!r.allFields && !r.fields[key]) {
+			continue
+		}
+
+		if redacted, found := r.redact(str); found {
+			if r.options.Action == RedactDrop {
+				delete(msg.Extra, key)
+			} else {
+				msg.Extra[key] = redacted
+			}
+		}
+	}
+
+	emit(msg)
+}
+
+// redact runs all detectors over the string, returning whether anything was found.
+func (r *RedactProcessor) redact(str string) (string, bool) {
+	found := false
+
+	for i := range r.detectors {
+		detector := &r.detectors[i]
+
+		str = detector.pattern.ReplaceAllStringFunc(str, func(match string) string {
+			if detector.validate != nil && !detector.validate(match) {
+				return match
+			}
+
+			found = true
+			util.IncCounter("redact." + detector.name)
+
+			return r.replacement(detector.name, match)
+		})
+	}
+
+	return str, found
+}
+
+func (r *RedactProcessor) replacement(detector, match string) string {
+	if r.options.Action == RedactHash {
+		mac := hmac.New(sha256.New, []byte(r.options.HashKey))
+		mac.Write([]byte(match))
+
+		return "[" + detector + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+	}
+
+	return "[REDACTED:" + detector + "]"
+}
+
+// luhnValid checks digits of possible card number using Luhn algorithm.
+func luhnValid(match string) bool {
+	sum := 0
+	digits := 0
+
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		digits++
+	}
+
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
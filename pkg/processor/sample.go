@@ -0,0 +1,285 @@
+package processor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.uber.org/zap"
+)
+
+const (
+	SampleRandom = "random"
+	SampleHash   = "hash"
+
+	// key used for all messages once the limit of tracked keys is reached
+	overflowKey = "<overflow>"
+	// key used in summary of sampled out messages of keys without a bucket
+	sampledKey = "<sampled>"
+
+	// how often buckets that refilled completely are forgotten
+	evictInterval = 10 * time.Second
+)
+
+type tokenBucket struct {
+	tokens     float64
+	last       time.Time
+	suppressed int64
+	host       string
+}
+
+// SampleProcessor drops messages using per-key token bucket rate limiting and sampling.
+type SampleProcessor struct {
+	options     SampleOptions
+	buckets     map[string]*tokenBucket
+	sampledOut  tokenBucket
+	lastSummary time.Time
+	lastEvict   time.Time
+	hostname    string
+	random      *rand.Rand
+	log         *zap.SugaredLogger
+}
+
+type SampleOptions struct {
+	// KeyFields identify streams rate limited separately, host by default
+	KeyFields []string
+	// Rate of messages per second allowed for each key, rate limiting is disabled if 0
+	Rate  float64
+	Burst int
+	// SampleRate is fraction of messages kept, sampling is disabled if 1
+	SampleRate float64
+	// SampleMode is either random or hash, the latter keeps or drops all messages with the same key
+	SampleMode string
+	// ExemptLevel disables dropping of messages with this or more severe level, -1 disables exemption
+	ExemptLevel int
+	// LevelField is an additional field containing level, GELF level is used if empty
+	LevelField string
+	// SummaryInterval controls how often messages summarizing dropped messages are emitted, disabled if 0
+	SummaryInterval time.Duration
+	// MaxKeys limits number of tracked keys, keys above the limit share a single bucket
+	MaxKeys int
+}
+
+func NewSampleOptions() SampleOptions {
+	return SampleOptions{
+		KeyFields:       []string{"host"},
+		Burst:           100,
+		SampleRate:      1,
+		SampleMode:      SampleRandom,
+		ExemptLevel:     -1,
+		SummaryInterval: time.Minute,
+		MaxKeys:         10000,
+	}
+}
+
+func NewSampleProcessor(options SampleOptions) *SampleProcessor {
+	return &SampleProcessor{
+		options: options,
+		buckets: make(map[string]*tokenBucket),
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		log:     zap.S().With("component", "sample-processor"),
+	}
+}
+
+func (s *SampleProcessor) Start() error {
+	if len(s.options.KeyFields) == 0 {
+		return fmt.Errorf("at least one sampling key field is required")
+	}
+	if s.options.Rate < 0 || s.options.Burst < 1 {
+		return fmt.Errorf("rate can't be negative and burst needs to be positive")
+	}
+	if s.options.SampleRate < 0 || s.options.SampleRate > 1 {
+		return fmt.Errorf("sample rate needs to be between 0 and 1")
+	}
+
+	switch s.options.SampleMode {
+	case SampleRandom, SampleHash:
+	default:
+		return fmt.Errorf("invalid sample mode: %v", s.options.SampleMode)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s.hostname = hostname
+	s.lastSummary = time.Now()
+	s.lastEvict = s.lastSummary
+
+	return nil
+}
+
+func (s *SampleProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	if s.exempt(msg) {
+		emit(msg)
+		return
+	}
+
+	key := fieldsKey(msg, s.options.KeyFields)
+
+	if !s.sampled(key) {
+		util.IncCounter("sample.sampled_out")
+		s.suppress(key, msg, false)
+		return
+	}
+
+	if !s.allowed(key, time.Now()) {
+		util.IncCounter("sample.rate_limited")
+		s.suppress(key, msg, true)
+		return
+	}
+
+	emit(msg)
+}
+
+func (s *SampleProcessor) Tick(now time.Time, emit EmitFunc) {
+	if s.options.SummaryInterval > 0 && now.Sub(s.lastSummary) >= s.options.SummaryInterval {
+		s.lastSummary = now
+		s.summarize(emit)
+	}
+
+	if now.Sub(s.lastEvict) >= evictInterval {
+		s.lastEvict = now
+		s.evict(now)
+	}
+}
+
+func (s *SampleProcessor) Flush(emit EmitFunc) {
+	if s.options.SummaryInterval > 0 {
+		s.summarize(emit)
+	}
+}
+
+func (s *SampleProcessor) exempt(msg *gelf.Message) bool {
+	if s.options.ExemptLevel < 0 {
+		return false
+	}
+
+	level, ok := messageLevel(msg, s.options.LevelField)
+	return ok && level <= int32(s.options.ExemptLevel)
+}
+
+func (s *SampleProcessor) sampled(key string) bool {
+	if s.options.SampleRate >= 1 {
+		return true
+	}
+
+	if s.options.SampleMode == SampleHash {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		return float64(h.Sum64()%10000) < s.options.SampleRate*10000
+	}
+
+	return s.random.Float64() < s.options.SampleRate
+}
+
+func (s *SampleProcessor) allowed(key string, now time.Time) bool {
+	if s.options.Rate == 0 {
+		return true
+	}
+
+	bucket := s.bucket(key, now)
+	if s.refill(bucket, now) < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+func (s *SampleProcessor) bucket(key string, now time.Time) *tokenBucket {
+	bucket, ok := s.buckets[key]
+	if ok {
+		return bucket
+	}
+
+	if s.options.MaxKeys > 0 && len(s.buckets) >= s.options.MaxKeys {
+		key = overflowKey
+		if bucket, ok = s.buckets[key]; ok {
+			return bucket
+		}
+	}
+
+	bucket = &tokenBucket{tokens: float64(s.options.Burst), last: now}
+	s.buckets[key] = bucket
+
+	return bucket
+}
+
+func (s *SampleProcessor) refill(bucket *tokenBucket, now time.Time) float64 {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * s.options.Rate
+	if bucket.tokens > float64(s.options.Burst) {
+		bucket.tokens = float64(s.options.Burst)
+	}
+	bucket.last = now
+
+	return bucket.tokens
+}
+
+// suppress counts dropped message for the summary. Messages dropped by sampling don't create buckets,
+// so that they don't take slots of keys which are rate limited.
+func (s *SampleProcessor) suppress(key string, msg *gelf.Message, create bool) {
+	if s.options.SummaryInterval <= 0 {
+		return
+	}
+
+	bucket, ok := s.buckets[key]
+	switch {
+	case ok:
+	case create:
+		bucket = s.bucket(key, time.Now())
+	default:
+		bucket = &s.sampledOut
+	}
+
+	bucket.suppressed++
+	bucket.host = msg.Host
+}
+
+// evict forgets buckets that refilled completely and have nothing to summarize,
+// they are indistinguishable from new ones.
+func (s *SampleProcessor) evict(now time.Time) {
+	for key, bucket := range s.buckets {
+		if bucket.suppressed == 0 && s.refill(bucket, now) >= float64(s.options.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// summarize emits a message for every key with suppressed messages since last summary.
+func (s *SampleProcessor) summarize(emit EmitFunc) {
+	keys := make([]string, 0)
+	for key, bucket := range s.buckets {
+		if bucket.suppressed > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s.emitSummary(key, s.buckets[key], emit)
+	}
+
+	if s.sampledOut.suppressed > 0 {
+		s.emitSummary(sampledKey, &s.sampledOut, emit)
+	}
+}
+
+func (s *SampleProcessor) emitSummary(key string, bucket *tokenBucket, emit EmitFunc) {
+	msg := util.NewGelfMessage()
+	msg.Host = s.hostname
+	msg.Level = gelf.LOG_NOTICE
+	msg.Short = fmt.Sprintf("suppressed %d messages from %v %v", bucket.suppressed, strings.Join(s.options.KeyFields, "/"), key)
+	util.AppendExtraToGelf(msg, "suppressed_count", bucket.suppressed)
+	util.AppendExtraToGelf(msg, "suppressed_key", key)
+	util.AppendExtraToGelf(msg, "suppressed_host", bucket.host)
+
+	bucket.suppressed = 0
+	emit(msg)
+}
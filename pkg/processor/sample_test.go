@@ -0,0 +1,274 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func newTestSample(t *testing.T, configure func(options *SampleOptions)) *SampleProcessor {
+	t.Helper()
+
+	options := NewSampleOptions()
+	configure(&options)
+
+	proc := NewSampleProcessor(options)
+	startProcessor(t, proc)
+
+	return proc
+}
+
+// flushSummaries returns suppressed counts from summaries emitted on flush, keyed by suppressed key.
+func flushSummaries(proc *SampleProcessor) map[string]int64 {
+	var summaries collector
+	proc.Flush(summaries.emit)
+
+	counts := make(map[string]int64)
+	for _, msg := range summaries.msgs {
+		counts[msg.Extra["_suppressed_key"].(string)] = msg.Extra["_suppressed_count"].(int64)
+	}
+
+	return counts
+}
+
+func TestSampleRateLimit(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 2
+	})
+
+	var emitted collector
+	for _, host := range []string{"a", "a", "a", "b", "a"} {
+		proc.Process(testMessage(host, "m", nil), emitted.emit)
+	}
+
+	if len(emitted.msgs) != 3 {
+		t.Errorf("Process: expected 3 messages, got %d", len(emitted.msgs))
+		return
+	}
+
+	if counts := flushSummaries(proc); len(counts) != 1 || counts["a"] != 2 {
+		t.Errorf("Flush: expected 2 suppressed for a, got %v", counts)
+	}
+}
+
+func TestSampleRateLimitDisabled(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Burst = 1
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "2", nil), emitted.emit)
+
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected 1,2, got %s", emitted.shorts())
+	}
+}
+
+func TestSampleExemptLevel(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.ExemptLevel = gelf.LOG_ERR
+	})
+
+	var emitted collector
+	for i, level := range []int32{0, gelf.LOG_ERR, gelf.LOG_WARNING, 0} {
+		msg := testMessage("a", string(rune('1'+i)), nil)
+		msg.Level = level
+		proc.Process(msg, emitted.emit)
+	}
+
+	// unset level isn't exempt
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected 1,2, got %s", emitted.shorts())
+	}
+}
+
+func TestSampleExemptLevelField(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.ExemptLevel = gelf.LOG_ERR
+		options.LevelField = "severity"
+	})
+
+	var emitted collector
+	proc.Process(testMessage("a", "1", nil), emitted.emit)
+	proc.Process(testMessage("a", "2", map[string]interface{}{"_severity": "error"}), emitted.emit)
+	proc.Process(testMessage("a", "3", map[string]interface{}{"_severity": "info"}), emitted.emit)
+
+	if emitted.shorts() != "1,2" {
+		t.Errorf("Process: expected 1,2, got %s", emitted.shorts())
+	}
+}
+
+// tests that sampled out messages are summarized together and don't take bucket slots
+func TestSampleSampledOut(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.SampleRate = 0
+	})
+
+	var emitted collector
+	for _, host := range []string{"a", "b", "c"} {
+		proc.Process(testMessage(host, "m", nil), emitted.emit)
+	}
+
+	if len(emitted.msgs) != 0 || len(proc.buckets) != 0 {
+		t.Errorf("Process: expected no messages and buckets, got %d and %d", len(emitted.msgs), len(proc.buckets))
+		return
+	}
+
+	if counts := flushSummaries(proc); len(counts) != 1 || counts[sampledKey] != 3 {
+		t.Errorf("Flush: expected 3 sampled out, got %v", counts)
+	}
+}
+
+func TestSampleHash(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.SampleRate = 0.5
+		options.SampleMode = SampleHash
+	})
+
+	for _, host := range []string{"a", "b", "c", "d"} {
+		var emitted collector
+		for i := 0; i < 10; i++ {
+			proc.Process(testMessage(host, "m", nil), emitted.emit)
+		}
+
+		if len(emitted.msgs) != 0 && len(emitted.msgs) != 10 {
+			t.Errorf("Process %s: expected all or no messages of the key, got %d", host, len(emitted.msgs))
+		}
+	}
+}
+
+func TestSampleMaxKeys(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.MaxKeys = 1
+	})
+
+	var emitted collector
+	for _, host := range []string{"a", "b", "c"} {
+		proc.Process(testMessage(host, "m", nil), emitted.emit)
+	}
+
+	if len(emitted.msgs) != 2 {
+		t.Errorf("Process: expected 2 messages, got %d", len(emitted.msgs))
+		return
+	}
+
+	if counts := flushSummaries(proc); len(counts) != 1 || counts[overflowKey] != 1 {
+		t.Errorf("Flush: expected 1 suppressed in overflow bucket, got %v", counts)
+	}
+}
+
+func TestSampleKeyFields(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.KeyFields = []string{"host", "app"}
+	})
+
+	var emitted collector
+	for _, app := range []string{"x", "y", "x"} {
+		proc.Process(testMessage("a", app, map[string]interface{}{"_app": app}), emitted.emit)
+	}
+
+	if emitted.shorts() != "x,y" {
+		t.Errorf("Process: expected x,y, got %s", emitted.shorts())
+		return
+	}
+
+	if counts := flushSummaries(proc); len(counts) != 1 || counts["a/x"] != 1 {
+		t.Errorf("Flush: expected 1 suppressed for a/x, got %v", counts)
+	}
+}
+
+func TestSampleTickEvict(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 1
+		options.Burst = 1
+		options.SummaryInterval = 0
+	})
+
+	var emitted collector
+	for _, host := range []string{"a", "a", "b"} {
+		proc.Process(testMessage(host, "m", nil), emitted.emit)
+	}
+
+	if len(proc.buckets) != 2 {
+		t.Errorf("Process: expected 2 buckets, got %d", len(proc.buckets))
+		return
+	}
+
+	var summaries collector
+	proc.Tick(time.Now().Add(evictInterval), summaries.emit)
+
+	if len(summaries.msgs) != 0 {
+		t.Errorf("Tick: expected no summaries when they are disabled, got %d", len(summaries.msgs))
+	}
+
+	if len(proc.buckets) != 0 {
+		t.Errorf("Tick: expected refilled buckets to be evicted, got %d", len(proc.buckets))
+	}
+}
+
+func TestSampleTickSummary(t *testing.T) {
+	proc := newTestSample(t, func(options *SampleOptions) {
+		options.Rate = 0.001
+		options.Burst = 1
+		options.SummaryInterval = time.Minute
+	})
+
+	var emitted collector
+	for i := 0; i < 3; i++ {
+		proc.Process(testMessage("a", "m", nil), emitted.emit)
+	}
+
+	var summaries collector
+	proc.Tick(time.Now(), summaries.emit)
+	if len(summaries.msgs) != 0 {
+		t.Errorf("Tick: expected no summary before the interval, got %d", len(summaries.msgs))
+		return
+	}
+
+	proc.Tick(time.Now().Add(time.Minute), summaries.emit)
+	if len(summaries.msgs) != 1 {
+		t.Errorf("Tick: expected 1 summary, got %d", len(summaries.msgs))
+		return
+	}
+
+	summary := summaries.msgs[0]
+	if summary.Extra["_suppressed_count"] != int64(2) || summary.Extra["_suppressed_host"] != "a" {
+		t.Errorf("Tick: unexpected summary fields %v", summary.Extra)
+	}
+
+	if _, ok := proc.buckets["a"]; !ok {
+		t.Errorf("Tick: expected bucket with suppressed messages to be kept")
+	}
+}
+
+func TestSampleStartInvalid(t *testing.T) {
+	invalid := map[string]func(options *SampleOptions){
+		"key fields":  func(options *SampleOptions) { options.KeyFields = nil },
+		"rate":        func(options *SampleOptions) { options.Rate = -1 },
+		"burst":       func(options *SampleOptions) { options.Burst = 0 },
+		"sample rate": func(options *SampleOptions) { options.SampleRate = 1.5 },
+		"sample mode": func(options *SampleOptions) { options.SampleMode = "first" },
+	}
+
+	for name, configure := range invalid {
+		options := NewSampleOptions()
+		configure(&options)
+
+		if err := NewSampleProcessor(options).Start(); err == nil {
+			t.Errorf("Start: expected error for invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/eplightning/gelf-forwarder/pkg/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.uber.org/zap"
+)
+
+const (
+	ScriptErrorPass = "pass"
+	ScriptErrorDrop = "drop"
+)
+
+// scriptMessage exposes gelf.Message to scripts as a mutable object.
+type scriptMessage struct {
+	msg    *gelf.Message
+	extra  *starlark.Dict
+	frozen bool
+}
+
+var scriptMessageAttrs = []string{"extra", "facility", "full", "host", "level", "short", "timestamp"}
+
+func newScriptMessage(msg *gelf.Message) *scriptMessage {
+	extra := starlark.NewDict(len(msg.Extra))
+	for key, value := range msg.Extra {
+		extra.SetKey(starlark.String(strings.TrimPrefix(key, "_")), goToStarlark(value))
+	}
+
+	return &scriptMessage{msg: msg, extra: extra}
+}
+
+func (m *scriptMessage) String() string {
+	return fmt.Sprintf("message(host=%q, short=%q)", m.msg.Host, m.msg.Short)
+}
+
+func (m *scriptMessage) Type() string         { return "message" }
+func (m *scriptMessage) Truth() starlark.Bool { return starlark.True }
+func (m *scriptMessage) AttrNames() []string  { return scriptMessageAttrs }
+
+func (m *scriptMessage) Freeze() {
+	m.frozen = true
+	m.extra.Freeze()
+}
+
+func (m *scriptMessage) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: message")
+}
+
+func (m *scriptMessage) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "host":
+		return starlark.String(m.msg.Host), nil
+	case "short":
+		return starlark.String(m.msg.Short), nil
+	case "full":
+		return starlark.String(m.msg.Full), nil
+	case "facility":
+		return starlark.String(m.msg.Facility), nil
+	case "level":
+		return starlark.MakeInt(int(m.msg.Level)), nil
+	case "timestamp":
+		return starlark.Float(m.msg.TimeUnix), nil
+	case "extra":
+		return m.extra, nil
+	}
+
+	return nil, nil
+}
+
+func (m *scriptMessage) SetField(name string, value starlark.Value) error {
+	if m.frozen {
+		return fmt.Errorf("cannot modify frozen message")
+	}
+
+	var err error
+
+	switch name {
+	case "host":
+		m.msg.Host, err = starlarkString(name, value)
+	case "short":
+		m.msg.Short, err = starlarkString(name, value)
+	case "full":
+		m.msg.Full, err = starlarkString(name, value)
+	case "facility":
+		m.msg.Facility, err = starlarkString(name, value)
+	case "level":
+		var level int
+		if level, err = starlark.AsInt32(value); err == nil {
+			m.msg.Level = int32(level)
+		}
+	case "timestamp":
+		ts, ok := starlark.AsFloat(value)
+		if !ok {
+			return fmt.Errorf("timestamp needs to be a number, got %v", value.Type())
+		}
+		m.msg.TimeUnix = ts
+	case "extra":
+		dict, ok := value.(*starlark.Dict)
+		if !ok {
+			return fmt.Errorf("extra needs to be a dict, got %v", value.Type())
+		}
+		m.extra = dict
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("message has no .%s field", name))
+	}
+
+	return err
+}
+
+// finish writes extra fields back to the message.
+func (m *scriptMessage) finish() *gelf.Message {
+	m.msg.Extra = make(map[string]interface{}, m.extra.Len())
+
+	for _, item := range m.extra.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			key = item[0].String()
+		}
+
+		if value := starlarkToGo(item[1]); value != nil {
+			util.AppendExtraToGelf(m.msg, key, value)
+		}
+	}
+
+	return m.msg
+}
+
+type compiledScript struct {
+	name    string
+	process *starlark.Function
+}
+
+// ScriptProcessor runs messages through Starlark scripts.
+type ScriptProcessor struct {
+	options     ScriptOptions
+	scripts     []compiledScript
+	predeclared starlark.StringDict
+	log         *zap.SugaredLogger
+}
+
+type ScriptOptions struct {
+	// Scripts are paths to Starlark files defining process(msg) function, run in order
+	Scripts []string
+	// MaxSteps limits number of execution steps of a single call, unlimited if 0
+	MaxSteps uint64
+	// Timeout limits duration of a single call, unlimited if 0
+	Timeout time.Duration
+	// OnError decides what happens with the message if script fails: pass (unmodified) or drop
+	OnError string
+}
+
+func NewScriptOptions() ScriptOptions {
+	return ScriptOptions{
+		MaxSteps: 100000,
+		Timeout:  100 * time.Millisecond,
+		OnError:  ScriptErrorPass,
+	}
+}
+
+func NewScriptProcessor(options ScriptOptions) *ScriptProcessor {
+	s := &ScriptProcessor{
+		options: options,
+		log:     zap.S().With("component", "script-processor"),
+	}
+
+	s.predeclared = starlark.StringDict{
+		"json":    starlarkjson.Module,
+		"message": starlark.NewBuiltin("message", scriptNewMessage),
+	}
+
+	return s
+}
+
+func (s *ScriptProcessor) Start() error {
+	if len(s.options.Scripts) == 0 {
+		return fmt.Errorf("at least one script is required")
+	}
+
+	switch s.options.OnError {
+	case ScriptErrorPass, ScriptErrorDrop:
+	default:
+		return fmt.Errorf("invalid script error action: %v", s.options.OnError)
+	}
+
+	for _, path := range s.options.Scripts {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+		thread := s.newThread(name)
+		globals, err := starlark.ExecFile(thread, path, nil, s.predeclared)
+		if err != nil {
+			return fmt.Errorf("could not load script %v: %w", path, err)
+		}
+		globals.Freeze()
+
+		process, ok := globals["process"].(*starlark.Function)
+		if !ok || process.NumParams() != 1 {
+			return fmt.Errorf("script %v needs to define process(msg) function", path)
+		}
+
+		s.scripts = append(s.scripts, compiledScript{name: name, process: process})
+	}
+
+	return nil
+}
+
+func (s *ScriptProcessor) Process(msg *gelf.Message, emit EmitFunc) {
+	msgs := []*gelf.Message{msg}
+
+	for _, script := range s.scripts {
+		next := make([]*gelf.Message, 0, len(msgs))
+
+		for _, m := range msgs {
+			next = append(next, s.run(script, m)...)
+		}
+
+		msgs = next
+	}
+
+	for _, m := range msgs {
+		emit(m)
+	}
+}
+
+// run calls script with the message, returning messages to pass on.
+func (s *ScriptProcessor) run(script compiledScript, msg *gelf.Message) []*gelf.Message {
+	thread := s.newThread(script.name)
+
+	if s.options.Timeout > 0 {
+		timer := time.AfterFunc(s.options.Timeout, func() {
+			thread.Cancel("timeout")
+		})
+		defer timer.Stop()
+	}
+
+	// script works on a copy so that the original is intact if it fails halfway
+	wrapped := newScriptMessage(copyMessage(msg))
+
+	result, err := starlark.Call(thread, script.process, starlark.Tuple{wrapped}, nil)
+	if err == nil {
+		var msgs []*gelf.Message
+		if msgs, err = scriptResult(wrapped, result); err == nil {
+			return msgs
+		}
+	}
+
+	util.IncCounter("script." + script.name + ".errors")
+	s.log.Warnw("Script failed", "script", script.name, "err", err)
+
+	if s.options.OnError == ScriptErrorDrop {
+		return nil
+	}
+
+	return []*gelf.Message{msg}
+}
+
+func (s *ScriptProcessor) newThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.log.Infow(msg, "script", name)
+		},
+	}
+
+	if s.options.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(s.options.MaxSteps)
+	}
+
+	return thread
+}
+
+// scriptResult interprets value returned by process: None or True keeps the message,
+// False drops it and a list replaces it with listed messages.
+func scriptResult(msg *scriptMessage, result starlark.Value) ([]*gelf.Message, error) {
+	switch casted := result.(type) {
+	case starlark.NoneType:
+		return []*gelf.Message{msg.finish()}, nil
+	case starlark.Bool:
+		if casted {
+			return []*gelf.Message{msg.finish()}, nil
+		}
+		return nil, nil
+	case *scriptMessage:
+		return []*gelf.Message{casted.finish()}, nil
+	case *starlark.List:
+		msgs := make([]*gelf.Message, 0, casted.Len())
+		listed := make(map[*scriptMessage]bool, casted.Len())
+		for i := 0; i < casted.Len(); i++ {
+			item, ok := casted.Index(i).(*scriptMessage)
+			if !ok {
+				return nil, fmt.Errorf("process returned list containing %v instead of messages", casted.Index(i).Type())
+			}
+
+			// message listed more than once is emitted as copies, since emitted messages are modified concurrently
+			if listed[item] {
+				msgs = append(msgs, copyMessage(item.msg))
+				continue
+			}
+			listed[item] = true
+			msgs = append(msgs, item.finish())
+		}
+		return msgs, nil
+	}
+
+	return nil, fmt.Errorf("process returned %v, expected None, bool, message or list of messages", result.Type())
+}
+
+// scriptNewMessage implements message() builtin creating new messages.
+func scriptNewMessage(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: unexpected positional arguments", fn.Name())
+	}
+
+	msg := newScriptMessage(util.NewGelfMessage())
+	msg.msg.Level = gelf.LOG_INFO
+
+	for _, kwarg := range kwargs {
+		name, _ := starlark.AsString(kwarg[0])
+		if err := msg.SetField(name, kwarg[1]); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+	}
+
+	return msg, nil
+}
+
+func copyMessage(msg *gelf.Message) *gelf.Message {
+	copied := *msg
+	copied.Extra = make(map[string]interface{}, len(msg.Extra))
+	for key, value := range msg.Extra {
+		copied.Extra[key] = value
+	}
+
+	return &copied
+}
+
+func starlarkString(name string, value starlark.Value) (string, error) {
+	str, ok := starlark.AsString(value)
+	if !ok {
+		return "", fmt.Errorf("%s needs to be a string, got %v", name, value.Type())
+	}
+
+	return str, nil
+}
+
+func goToStarlark(value interface{}) starlark.Value {
+	switch casted := value.(type) {
+	case nil:
+		return starlark.None
+	case string:
+		return starlark.String(casted)
+	case bool:
+		return starlark.Bool(casted)
+	case float64:
+		return starlark.Float(casted)
+	case float32:
+		return starlark.Float(casted)
+	case int:
+		return starlark.MakeInt(casted)
+	case int32:
+		return starlark.MakeInt64(int64(casted))
+	case int64:
+		return starlark.MakeInt64(casted)
+	case uint64:
+		return starlark.MakeUint64(casted)
+	default:
+		return starlark.String(fmt.Sprint(casted))
+	}
+}
+
+// starlarkToGo converts value of an extra field, nil means the field is removed.
+func starlarkToGo(value starlark.Value) interface{} {
+	switch casted := value.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.String:
+		return string(casted)
+	case starlark.Bool:
+		return bool(casted)
+	case starlark.Float:
+		return float64(casted)
+	case starlark.Int:
+		if i, ok := casted.Int64(); ok {
+			return i
+		}
+		return casted.String()
+	case *starlark.Dict, *starlark.List, starlark.Tuple:
+		return scriptJSON(value)
+	default:
+		return value.String()
+	}
+}
+
+// scriptJSON encodes composite values as JSON.
+func scriptJSON(value starlark.Value) string {
+	encode := starlarkjson.Module.Members["encode"].(*starlark.Builtin)
+
+	result, err := starlark.Call(&starlark.Thread{}, encode, starlark.Tuple{value}, nil)
+	if err != nil {
+		return value.String()
+	}
+
+	str, _ := starlark.AsString(result)
+	return str
+}
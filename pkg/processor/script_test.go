@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, name, source string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name+".star")
+	if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+		t.Fatalf("writing script: %s", err)
+	}
+
+	return path
+}
+
+// runScript runs a single message with host h and short message hello through the script.
+func runScript(t *testing.T, source, onError string) *collector {
+	t.Helper()
+
+	options := NewScriptOptions()
+	options.Scripts = []string{writeScript(t, "test", source)}
+	if onError != "" {
+		options.OnError = onError
+	}
+
+	proc := NewScriptProcessor(options)
+	startProcessor(t, proc)
+
+	emitted := &collector{}
+	proc.Process(testMessage("h", "hello", map[string]interface{}{"_keep": "x", "_drop_me": "y"}), emitted.emit)
+
+	return emitted
+}
+
+func TestScriptModify(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    msg.short = msg.short.upper()
+    msg.extra["app"] = "web"
+    msg.extra["count"] = 2
+    msg.extra["tags"] = ["a", "b"]
+    msg.extra.pop("drop_me")
+`, "")
+
+	if emitted.shorts() != "HELLO" {
+		t.Errorf("Process: expected HELLO, got %s", emitted.shorts())
+		return
+	}
+
+	expected := map[string]interface{}{"_keep": "x", "_app": "web", "_count": int64(2), "_tags": `["a","b"]`}
+	extra := emitted.msgs[0].Extra
+
+	if len(extra) != len(expected) {
+		t.Errorf("Process: expected %v, got %v", expected, extra)
+		return
+	}
+
+	for k, v := range expected {
+		if extra[k] != v {
+			t.Errorf("Process %s: expected %v (%T), got %v (%T)", k, v, v, extra[k], extra[k])
+		}
+	}
+}
+
+func TestScriptJSON(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    msg.extra.update(json.decode('{"user": "john"}'))
+`, "")
+
+	if len(emitted.msgs) != 1 || emitted.msgs[0].Extra["_user"] != "john" {
+		t.Errorf("Process: expected _user field, got %s", emitted.shorts())
+	}
+}
+
+func TestScriptDrop(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    return False
+`, "")
+
+	if len(emitted.msgs) != 0 {
+		t.Errorf("Process: expected message to be dropped, got %s", emitted.shorts())
+	}
+}
+
+func TestScriptSplit(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    return [message(host=msg.host, short=part) for part in msg.short.split("l")]
+`, "")
+
+	if emitted.shorts() != "he,,o" {
+		t.Errorf("Process: expected he,,o, got %s", emitted.shorts())
+	}
+}
+
+// tests that message listed twice is emitted as separate copies
+func TestScriptDuplicate(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    return [msg, msg]
+`, "")
+
+	if emitted.shorts() != "hello,hello" {
+		t.Errorf("Process: expected hello,hello, got %s", emitted.shorts())
+		return
+	}
+
+	if emitted.msgs[0] == emitted.msgs[1] {
+		t.Errorf("Process: expected copies of the message, got the same message twice")
+		return
+	}
+
+	emitted.msgs[1].Extra["_copy"] = true
+	if _, ok := emitted.msgs[0].Extra["_copy"]; ok {
+		t.Errorf("Process: expected copies not to share additional fields")
+	}
+
+	if emitted.msgs[1].Extra["_keep"] != "x" {
+		t.Errorf("Process: expected copy to keep additional fields, got %v", emitted.msgs[1].Extra)
+	}
+}
+
+func TestScriptErrorPass(t *testing.T) {
+	emitted := runScript(t, `
+def process(msg):
+    msg.short = "changed"
+    fail("broken")
+`, ScriptErrorPass)
+
+	if emitted.shorts() != "hello" {
+		t.Errorf("Process: expected original message, got %s", emitted.shorts())
+		return
+	}
+
+	if len(emitted.msgs[0].Extra) != 2 {
+		t.Errorf("Process: expected original fields, got %v", emitted.msgs[0].Extra)
+	}
+}
+
+func TestScriptErrorDrop(t *testing.T) {
+	sources := map[string]string{
+		"fail":           "def process(msg):\n    fail(\"broken\")\n",
+		"invalid result": "def process(msg):\n    return 1\n",
+		"step limit":     "def process(msg):\n    for i in range(1000000):\n        pass\n",
+	}
+
+	for name, source := range sources {
+		if emitted := runScript(t, source, ScriptErrorDrop); len(emitted.msgs) != 0 {
+			t.Errorf("Process %s: expected message to be dropped, got %s", name, emitted.shorts())
+		}
+	}
+}
+
+// tests that messages emitted by a script go through the following scripts
+func TestScriptChain(t *testing.T) {
+	options := NewScriptOptions()
+	options.Scripts = []string{
+		writeScript(t, "first", "def process(msg):\n    return [msg, message(host=msg.host, short=\"copy\")]\n"),
+		writeScript(t, "second", "def process(msg):\n    msg.short += \"!\"\n"),
+	}
+
+	proc := NewScriptProcessor(options)
+	startProcessor(t, proc)
+
+	var emitted collector
+	proc.Process(testMessage("h", "hello", nil), emitted.emit)
+
+	if emitted.shorts() != "hello!,copy!" {
+		t.Errorf("Process: expected hello!,copy!, got %s", emitted.shorts())
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	options := NewScriptOptions()
+	options.MaxSteps = 0
+	options.Timeout = 10 * time.Millisecond
+	options.OnError = ScriptErrorDrop
+	options.Scripts = []string{writeScript(t, "slow", "def process(msg):\n    for i in range(100000000):\n        pass\n")}
+
+	proc := NewScriptProcessor(options)
+	startProcessor(t, proc)
+
+	var emitted collector
+	proc.Process(testMessage("h", "hello", nil), emitted.emit)
+
+	if len(emitted.msgs) != 0 {
+		t.Errorf("Process: expected message to be dropped on timeout, got %s", emitted.shorts())
+	}
+}
+
+func TestScriptStartInvalid(t *testing.T) {
+	sources := map[string]string{
+		"syntax":     "def process(msg)\n",
+		"function":   "def handle(msg):\n    pass\n",
+		"parameters": "def process(msg, extra):\n    pass\n",
+	}
+
+	for name, source := range sources {
+		options := NewScriptOptions()
+		options.Scripts = []string{writeScript(t, "test", source)}
+
+		if err := NewScriptProcessor(options).Start(); err == nil {
+			t.Errorf("Start: expected error for invalid %s", name)
+		}
+	}
+
+	options := NewScriptOptions()
+	options.Scripts = []string{writeScript(t, "test", "def process(msg):\n    pass\n")}
+	options.OnError = "retry"
+
+	if err := NewScriptProcessor(options).Start(); err == nil {
+		t.Errorf("Start: expected error for invalid error action")
+	}
+}
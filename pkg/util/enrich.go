@@ -0,0 +1,91 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+type EnrichOptions struct {
+	// StaticFields are added to every message, environment variables in values are expanded
+	StaticFields map[string]string
+	// TemplateFields are Go templates executed against the message
+	TemplateFields map[string]string
+}
+
+// Enricher adds configured fields to messages.
+type Enricher struct {
+	static    map[string]string
+	templates map[string]*template.Template
+}
+
+func NewEnricher(options EnrichOptions) (*Enricher, error) {
+	enricher := &Enricher{
+		static:    make(map[string]string, len(options.StaticFields)),
+		templates: make(map[string]*template.Template, len(options.TemplateFields)),
+	}
+
+	for k, v := range options.StaticFields {
+		enricher.static[k] = os.ExpandEnv(v)
+	}
+
+	for k, v := range options.TemplateFields {
+		// field is skipped if the template references a missing field, instead of rendering "<no value>"
+		tpl, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of field %v: %w", k, err)
+		}
+		enricher.templates[k] = tpl
+	}
+
+	return enricher, nil
+}
+
+// Apply adds static and templated fields to the message. Templates see values from before enrichment.
+func (e *Enricher) Apply(msg *gelf.Message) {
+	if e == nil {
+		return
+	}
+
+	var data map[string]interface{}
+	if len(e.templates) > 0 {
+		data = MessageTemplateData(msg)
+	}
+
+	for k, v := range e.static {
+		AppendExtraToGelf(msg, k, v)
+	}
+
+	for k, tpl := range e.templates {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, data); err != nil {
+			IncCounter("enrich.template_errors")
+			continue
+		}
+
+		AppendExtraToGelf(msg, k, buf.String())
+	}
+}
+
+// MessageTemplateData exposes message to templates, additional fields are available without leading underscore.
+func MessageTemplateData(msg *gelf.Message) map[string]interface{} {
+	data := make(map[string]interface{}, len(msg.Extra)+7)
+
+	for k, v := range msg.Extra {
+		data[strings.TrimPrefix(k, "_")] = v
+	}
+
+	data["version"] = msg.Version
+	data["host"] = msg.Host
+	data["short_message"] = msg.Short
+	data["full_message"] = msg.Full
+	data["timestamp"] = msg.TimeUnix
+	data["level"] = msg.Level
+	data["facility"] = msg.Facility
+
+	return data
+}
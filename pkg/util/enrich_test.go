@@ -0,0 +1,86 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func newEnrichedMessage(t *testing.T, options EnrichOptions) *gelf.Message {
+	t.Helper()
+
+	enricher, err := NewEnricher(options)
+	if err != nil {
+		t.Fatalf("NewEnricher: %s", err)
+	}
+
+	msg := &gelf.Message{Host: "h", Short: "m", Extra: map[string]interface{}{"_app": "web"}}
+	enricher.Apply(msg)
+
+	return msg
+}
+
+func TestEnrichStatic(t *testing.T) {
+	t.Setenv("GELF_FORWARDER_TEST_ENV", "prod")
+
+	msg := newEnrichedMessage(t, EnrichOptions{
+		StaticFields: map[string]string{"env": "${GELF_FORWARDER_TEST_ENV}", "dc": "eu"},
+	})
+
+	if msg.Extra["_env"] != "prod" || msg.Extra["_dc"] != "eu" {
+		t.Errorf("Apply: unexpected fields %v", msg.Extra)
+	}
+}
+
+func TestEnrichTemplate(t *testing.T) {
+	msg := newEnrichedMessage(t, EnrichOptions{
+		TemplateFields: map[string]string{"summary": "{{.host}}/{{.app}}: {{.short_message}}"},
+	})
+
+	if msg.Extra["_summary"] != "h/web: m" {
+		t.Errorf("Apply: expected h/web: m, got %v", msg.Extra["_summary"])
+	}
+}
+
+// tests that templates see values from before enrichment
+func TestEnrichTemplateOrder(t *testing.T) {
+	msg := newEnrichedMessage(t, EnrichOptions{
+		StaticFields:   map[string]string{"app": "api"},
+		TemplateFields: map[string]string{"summary": "{{.app}}"},
+	})
+
+	if msg.Extra["_app"] != "api" || msg.Extra["_summary"] != "web" {
+		t.Errorf("Apply: unexpected fields %v", msg.Extra)
+	}
+}
+
+func TestEnrichTemplateMissingField(t *testing.T) {
+	msg := newEnrichedMessage(t, EnrichOptions{
+		TemplateFields: map[string]string{"summary": "{{.missing}}", "ok": "{{.app}}"},
+	})
+
+	if _, ok := msg.Extra["_summary"]; ok {
+		t.Errorf("Apply: expected template with missing field to be skipped, got %v", msg.Extra["_summary"])
+	}
+
+	if msg.Extra["_ok"] != "web" {
+		t.Errorf("Apply: expected web, got %v", msg.Extra["_ok"])
+	}
+}
+
+func TestEnrichInvalidTemplate(t *testing.T) {
+	if _, err := NewEnricher(EnrichOptions{TemplateFields: map[string]string{"x": "{{.host"}}); err == nil {
+		t.Errorf("NewEnricher: expected error for invalid template")
+	}
+}
+
+func TestEnrichNil(t *testing.T) {
+	var enricher *Enricher
+
+	msg := &gelf.Message{Extra: map[string]interface{}{}}
+	enricher.Apply(msg)
+
+	if len(msg.Extra) != 0 {
+		t.Errorf("Apply: expected no fields, got %v", msg.Extra)
+	}
+}
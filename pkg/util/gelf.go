@@ -2,12 +2,10 @@ package util
 
 import (
 	"github.com/Graylog2/go-gelf/gelf"
-	"regexp"
 	"time"
+	"unicode/utf8"
 )
 
-var fieldRegex = regexp.MustCompile("\\W")
-
 func NewGelfMessage() *gelf.Message {
 	return &gelf.Message{
 		Version:  "1.1",
@@ -16,7 +14,21 @@ func NewGelfMessage() *gelf.Message {
 	}
 }
 
+// AppendExtraToGelf adds additional field to the message, key is sanitized according to ConfigureKeySanitizer.
 func AppendExtraToGelf(msg *gelf.Message, key string, value interface{}) {
-	msg.Extra["_" + fieldRegex.ReplaceAllString(key, "_")] = value
+	loadKeySanitizer().Append(msg, key, value)
 }
 
+// TruncateUTF8 cuts string to at most maxBytes bytes without splitting multi-byte characters.
+func TruncateUTF8(str string, maxBytes int) string {
+	if len(str) <= maxBytes {
+		return str
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(str[cut]) {
+		cut--
+	}
+
+	return str[:cut]
+}
@@ -0,0 +1,20 @@
+package util
+
+import "testing"
+
+func TestTruncateUTF8(t *testing.T) {
+	expected := map[int]string{
+		0: "",
+		1: "",
+		2: "ż",
+		3: "ż",
+		4: "żó",
+		9: "żółw",
+	}
+
+	for maxBytes, want := range expected {
+		if got := TruncateUTF8("żółw", maxBytes); got != want {
+			t.Errorf("TruncateUTF8 %d: expected %q, got %q", maxBytes, want, got)
+		}
+	}
+}
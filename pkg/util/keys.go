@@ -0,0 +1,204 @@
+package util
+
+import (
+	"expvar"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+const (
+	CollisionOverwrite = "overwrite"
+	CollisionSuffix    = "suffix"
+	CollisionDrop      = "drop"
+
+	maxRenameReportSize = 1000
+)
+
+// Graylog rejects _id and strips leading underscore from additional fields,
+// so these would clash with message fields
+var reservedKeys = map[string]bool{
+	"id":            true,
+	"version":       true,
+	"host":          true,
+	"source":        true,
+	"message":       true,
+	"short_message": true,
+	"full_message":  true,
+	"timestamp":     true,
+	"level":         true,
+	"facility":      true,
+	"streams":       true,
+}
+
+var renamedKeys = expvar.NewMap("gelf_forwarder_renamed_keys")
+
+type KeySanitizerOptions struct {
+	// AllowedChars are characters allowed in keys on top of letters, digits and underscore
+	AllowedChars string
+	// MaxKeyLength limits length of keys including the leading underscore, unlimited if 0
+	MaxKeyLength int
+	// CollisionStrategy decides what happens when key already exists: overwrite, suffix or drop
+	CollisionStrategy string
+	// ReservedSuffix is appended to reserved keys such as _id
+	ReservedSuffix string
+}
+
+// KeySanitizer turns arbitrary keys into valid names of GELF additional fields.
+type KeySanitizer struct {
+	options      KeySanitizerOptions
+	invalidChars *regexp.Regexp
+	reportMutex  sync.Mutex
+	reportSize   int
+}
+
+// defaultKeySanitizer holds *KeySanitizer used by AppendExtraToGelf, it's replaced while inputs may be using it
+var defaultKeySanitizer atomic.Value
+
+func init() {
+	sanitizer, _ := NewKeySanitizer(NewKeySanitizerOptions())
+	defaultKeySanitizer.Store(sanitizer)
+}
+
+func loadKeySanitizer() *KeySanitizer {
+	return defaultKeySanitizer.Load().(*KeySanitizer)
+}
+
+func NewKeySanitizerOptions() KeySanitizerOptions {
+	return KeySanitizerOptions{
+		CollisionStrategy: CollisionOverwrite,
+		ReservedSuffix:    "_",
+	}
+}
+
+func NewKeySanitizer(options KeySanitizerOptions) (*KeySanitizer, error) {
+	switch options.CollisionStrategy {
+	case CollisionOverwrite, CollisionSuffix, CollisionDrop:
+	default:
+		return nil, fmt.Errorf("invalid key collision strategy: %v", options.CollisionStrategy)
+	}
+
+	if options.ReservedSuffix == "" {
+		return nil, fmt.Errorf("reserved key suffix can't be empty")
+	}
+
+	if options.MaxKeyLength < 0 || (options.MaxKeyLength > 0 && options.MaxKeyLength < 2+len(options.ReservedSuffix)) {
+		return nil, fmt.Errorf("max key length is too small: %v", options.MaxKeyLength)
+	}
+
+	var class strings.Builder
+	for _, c := range options.AllowedChars {
+		if strings.ContainsRune(`\]^-[`, c) {
+			class.WriteByte('\\')
+		}
+		class.WriteRune(c)
+	}
+
+	invalidChars, err := regexp.Compile(`[^\w` + class.String() + `]`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed key characters: %w", err)
+	}
+
+	return &KeySanitizer{
+		options:      options,
+		invalidChars: invalidChars,
+	}, nil
+}
+
+// ConfigureKeySanitizer replaces sanitizer used by AppendExtraToGelf.
+func ConfigureKeySanitizer(options KeySanitizerOptions) error {
+	sanitizer, err := NewKeySanitizer(options)
+	if err != nil {
+		return err
+	}
+
+	defaultKeySanitizer.Store(sanitizer)
+	return nil
+}
+
+// KeyCharsAllowed returns whether s contains only characters kept in keys by AppendExtraToGelf.
+func KeyCharsAllowed(s string) bool {
+	return !loadKeySanitizer().invalidChars.MatchString(s)
+}
+
+// Append sanitizes key and adds it to additional fields of the message.
+func (s *KeySanitizer) Append(msg *gelf.Message, key string, value interface{}) {
+	name := s.invalidChars.ReplaceAllString(key, "_")
+
+	if reservedKeys[strings.TrimPrefix(name, "_")] {
+		name += s.options.ReservedSuffix
+		IncCounter("keys.reserved")
+	}
+
+	sanitized := s.limitLength("_" + name)
+	if sanitized != "_"+name {
+		IncCounter("keys.truncated")
+	}
+
+	if _, exists := msg.Extra[sanitized]; exists {
+		IncCounter("keys.collisions")
+
+		switch s.options.CollisionStrategy {
+		case CollisionDrop:
+			IncCounter("keys.dropped")
+			return
+		case CollisionSuffix:
+			sanitized = s.suffixed(msg, sanitized)
+		}
+	}
+
+	if sanitized != "_"+key {
+		s.reportRename(key, sanitized)
+	}
+
+	msg.Extra[sanitized] = value
+}
+
+func (s *KeySanitizer) limitLength(key string) string {
+	if s.options.MaxKeyLength > 0 {
+		return TruncateUTF8(key, s.options.MaxKeyLength)
+	}
+
+	return key
+}
+
+// suffixed finds first free key with numeric suffix, respecting length limit.
+func (s *KeySanitizer) suffixed(msg *gelf.Message, key string) string {
+	for i := 2; ; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		base := key
+		if s.options.MaxKeyLength > 0 {
+			base = TruncateUTF8(key, s.options.MaxKeyLength-len(suffix))
+		}
+
+		if _, exists := msg.Extra[base+suffix]; !exists {
+			return base + suffix
+		}
+	}
+}
+
+// reportRename counts renames of each key, report is bounded to avoid unlimited growth.
+func (s *KeySanitizer) reportRename(from, to string) {
+	IncCounter("keys.renamed")
+
+	name := from + " -> " + to
+	if renamedKeys.Get(name) == nil {
+		s.reportMutex.Lock()
+		full := s.reportSize >= maxRenameReportSize
+		if !full {
+			s.reportSize++
+		}
+		s.reportMutex.Unlock()
+
+		if full {
+			return
+		}
+	}
+
+	renamedKeys.Add(name, 1)
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+)
+
+func newTestSanitizer(t *testing.T, options KeySanitizerOptions) *KeySanitizer {
+	t.Helper()
+
+	sanitizer, err := NewKeySanitizer(options)
+	if err != nil {
+		t.Fatalf("NewKeySanitizer: %s", err)
+	}
+
+	return sanitizer
+}
+
+func TestSanitizeKeys(t *testing.T) {
+	keys := map[string]string{
+		"name":         "_name",
+		"user.name":    "_user_name",
+		"a b-c":        "_a_b_c",
+		"zażółć":       "_za____",
+		"id":           "_id_",
+		"_id":          "__id_",
+		"__id":         "___id",
+		"host":         "_host_",
+		"hostname":     "_hostname",
+		"full_message": "_full_message_",
+	}
+
+	sanitizer := newTestSanitizer(t, NewKeySanitizerOptions())
+
+	for key, expected := range keys {
+		msg := &gelf.Message{Extra: map[string]interface{}{}}
+		sanitizer.Append(msg, key, "v")
+
+		if msg.Extra[expected] != "v" || len(msg.Extra) != 1 {
+			t.Errorf("Append %s: expected %s, got %v", key, expected, msg.Extra)
+		}
+	}
+}
+
+func TestSanitizeKeysAllowedChars(t *testing.T) {
+	options := NewKeySanitizerOptions()
+	options.AllowedChars = ".-]"
+	sanitizer := newTestSanitizer(t, options)
+
+	msg := &gelf.Message{Extra: map[string]interface{}{}}
+	sanitizer.Append(msg, "a.b-c]d e", "v")
+
+	if msg.Extra["_a.b-c]d_e"] != "v" {
+		t.Errorf("Append: expected _a.b-c]d_e, got %v", msg.Extra)
+	}
+}
+
+func TestSanitizeKeysMaxLength(t *testing.T) {
+	options := NewKeySanitizerOptions()
+	options.MaxKeyLength = 6
+	options.AllowedChars = "żół"
+	sanitizer := newTestSanitizer(t, options)
+
+	msg := &gelf.Message{Extra: map[string]interface{}{}}
+	sanitizer.Append(msg, "abcdefgh", "v")
+	sanitizer.Append(msg, "żółw", "w")
+
+	if msg.Extra["_abcde"] != "v" {
+		t.Errorf("Append: expected _abcde, got %v", msg.Extra)
+	}
+
+	if msg.Extra["_żó"] != "w" {
+		t.Errorf("Append: expected key truncated on rune boundary, got %v", msg.Extra)
+	}
+}
+
+func TestSanitizeKeysCollisions(t *testing.T) {
+	expected := map[string]map[string]interface{}{
+		CollisionOverwrite: {"_a_b": "2"},
+		CollisionDrop:      {"_a_b": "1"},
+		CollisionSuffix:    {"_a_b": "1", "_a_b_2": "2", "_a_b_3": "3"},
+	}
+
+	for strategy, extra := range expected {
+		options := NewKeySanitizerOptions()
+		options.CollisionStrategy = strategy
+		sanitizer := newTestSanitizer(t, options)
+
+		msg := &gelf.Message{Extra: map[string]interface{}{}}
+		sanitizer.Append(msg, "a.b", "1")
+		sanitizer.Append(msg, "a b", "2")
+		if strategy == CollisionSuffix {
+			sanitizer.Append(msg, "a-b", "3")
+		}
+
+		if len(msg.Extra) != len(extra) {
+			t.Errorf("Append %s: expected %v, got %v", strategy, extra, msg.Extra)
+			continue
+		}
+
+		for k, v := range extra {
+			if msg.Extra[k] != v {
+				t.Errorf("Append %s: expected %v, got %v", strategy, extra, msg.Extra)
+				break
+			}
+		}
+	}
+}
+
+// tests that numeric suffix still fits in the key length limit
+func TestSanitizeKeysSuffixMaxLength(t *testing.T) {
+	options := NewKeySanitizerOptions()
+	options.CollisionStrategy = CollisionSuffix
+	options.MaxKeyLength = 5
+	sanitizer := newTestSanitizer(t, options)
+
+	msg := &gelf.Message{Extra: map[string]interface{}{}}
+	sanitizer.Append(msg, "abcdef", "1")
+	sanitizer.Append(msg, "abcdxy", "2")
+
+	if msg.Extra["_abcd"] != "1" || msg.Extra["_ab_2"] != "2" {
+		t.Errorf("Append: unexpected keys %v", msg.Extra)
+	}
+}
+
+func TestNewKeySanitizerInvalid(t *testing.T) {
+	invalid := map[string]KeySanitizerOptions{
+		"collision strategy": {CollisionStrategy: "rename", ReservedSuffix: "_"},
+		"reserved suffix":    {CollisionStrategy: CollisionOverwrite},
+		"max key length":     {CollisionStrategy: CollisionOverwrite, ReservedSuffix: "_", MaxKeyLength: 2},
+		"negative length":    {CollisionStrategy: CollisionOverwrite, ReservedSuffix: "_", MaxKeyLength: -1},
+	}
+
+	for name, options := range invalid {
+		if _, err := NewKeySanitizer(options); err == nil {
+			t.Errorf("NewKeySanitizer: expected error for invalid %s", name)
+		}
+	}
+}
+
+// tests that sanitizer can be configured while messages are being sanitized, run with -race
+func TestConfigureKeySanitizerConcurrent(t *testing.T) {
+	defer ConfigureKeySanitizer(NewKeySanitizerOptions())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			AppendExtraToGelf(&gelf.Message{Extra: map[string]interface{}{}}, "user.name", "v")
+			KeyCharsAllowed("user.name")
+		}
+	}()
+
+	options := NewKeySanitizerOptions()
+	options.AllowedChars = "."
+	if err := ConfigureKeySanitizer(options); err != nil {
+		t.Errorf("ConfigureKeySanitizer: %s", err)
+	}
+	<-done
+
+	if !KeyCharsAllowed("user.name") {
+		t.Errorf("KeyCharsAllowed: expected configured characters to be allowed")
+	}
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"go.uber.org/zap"
+)
+
+var counters = expvar.NewMap("gelf_forwarder")
+
+// IncCounter increments counter with a given name, counters are created on first use.
+func IncCounter(name string) {
+	counters.Add(name, 1)
+}
+
+// AddCounter adds delta to counter with a given name, counters are created on first use.
+func AddCounter(name string, delta int64) {
+	counters.Add(name, delta)
+}
+
+// MetricsServer exposes counters in expvar JSON format under /debug/vars.
+type MetricsServer struct {
+	address  string
+	listener net.Listener
+	log      *zap.SugaredLogger
+}
+
+func NewMetricsServer(address string) *MetricsServer {
+	return &MetricsServer{
+		address: address,
+		log:     zap.S().With("component", "metrics"),
+	}
+}
+
+func (m *MetricsServer) Start() error {
+	listener, err := net.Listen("tcp", m.address)
+	if err != nil {
+		return err
+	}
+
+	m.listener = listener
+	return nil
+}
+
+func (m *MetricsServer) Listen(msgCh chan *gelf.Message, stopCh chan interface{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	server := &http.Server{
+		Addr:    m.address,
+		Handler: mux,
+	}
+
+	go func() {
+		select {
+		case <-stopCh:
+			server.Close()
+		}
+	}()
+
+	m.log.Infof("Serving metrics on %v", m.address)
+
+	if err := server.Serve(m.listener); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -6,8 +6,21 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
 )
 
+type TLSOutputOptions struct {
+	CAPath             string
+	CertPath           string
+	KeyPath            string
+	ServerName         string
+	MinVersion         string
+	InsecureSkipVerify bool
+}
+
 type TLSInputOptions struct {
 	Enabled        bool
 	ServerCertPath string
@@ -20,6 +33,20 @@ func WrapInputWithTLS(lis net.Listener, options TLSInputOptions) (net.Listener,
 		return lis, nil
 	}
 
+	conf, err := NewTLSServerConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(lis, conf), nil
+}
+
+// NewTLSServerConfig creates server TLS configuration, nil is returned if TLS is disabled.
+func NewTLSServerConfig(options TLSInputOptions) (*tls.Config, error) {
+	if !options.Enabled {
+		return nil, nil
+	}
+
 	if options.ServerCertPath == "" || options.ServerKeyPath == "" {
 		return nil, fmt.Errorf("when TLS is enabled, both cert and key paths need to be provided")
 	}
@@ -48,5 +75,192 @@ func WrapInputWithTLS(lis net.Listener, options TLSInputOptions) (net.Listener,
 		conf.ClientAuth = tls.RequireAndVerifyClientCert
 	}
 
-	return tls.NewListener(lis, conf), nil
+	return conf, nil
+}
+
+// PeerCommonName returns common name of the verified client certificate, if any.
+func PeerCommonName(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return state.PeerCertificates[0].Subject.CommonName
+}
+
+// PeerSubject returns subject of the verified client certificate, if any.
+func PeerSubject(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return state.PeerCertificates[0].Subject.String()
+}
+
+// NewTLSClientConfig creates client TLS configuration. Client certificate and CA bundle are
+// reloaded when their files change, affecting new connections only.
+func NewTLSClientConfig(options TLSOutputOptions) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(options.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if (options.CertPath == "") != (options.KeyPath == "") {
+		return nil, fmt.Errorf("both client cert and key paths need to be provided")
+	}
+
+	files := &tlsClientFiles{
+		caPath:   options.CAPath,
+		certPath: options.CertPath,
+		keyPath:  options.KeyPath,
+		// SNI in connection state omits IP addresses, so the configured name is verified instead
+		serverName: options.ServerName,
+		modTimes:   make(map[string]time.Time),
+		log:        zap.S().With("component", "tls"),
+	}
+	if err := files.reload(); err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		ServerName:         options.ServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CertPath != "" {
+		conf.GetClientCertificate = files.clientCertificate
+	}
+
+	// standard verification can't use CA pool changing at runtime, so it's done in VerifyConnection instead
+	if options.CAPath != "" && !options.InsecureSkipVerify {
+		conf.InsecureSkipVerify = true
+		conf.VerifyConnection = files.verifyConnection
+	}
+
+	return conf, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("invalid TLS version: %v", version)
+}
+
+// tlsClientFiles holds client certificate and CA pool, reloading them when files change.
+type tlsClientFiles struct {
+	mu       sync.Mutex
+	caPath   string
+	certPath string
+	keyPath  string
+	// serverName is the name certificate is verified against, server name of the connection if empty
+	serverName string
+	modTimes   map[string]time.Time
+	cert       *tls.Certificate
+	pool       *x509.CertPool
+	log        *zap.SugaredLogger
+}
+
+// reload loads files modified since the last call, previous ones are kept on error.
+func (f *tlsClientFiles) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.certPath != "" && (f.changed(f.certPath) || f.changed(f.keyPath)) {
+		cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+		if err != nil {
+			return fmt.Errorf("could not load X509 key pair: %w", err)
+		}
+
+		f.cert = &cert
+		f.updateModTime(f.certPath)
+		f.updateModTime(f.keyPath)
+	}
+
+	if f.caPath != "" && f.changed(f.caPath) {
+		file, err := os.ReadFile(f.caPath)
+		if err != nil {
+			return fmt.Errorf("error while loading CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(file) {
+			return fmt.Errorf("could not load any CA certificates")
+		}
+
+		f.pool = pool
+		f.updateModTime(f.caPath)
+	}
+
+	return nil
+}
+
+func (f *tlsClientFiles) changed(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		// let the load report the error
+		return true
+	}
+
+	return !info.ModTime().Equal(f.modTimes[path])
+}
+
+func (f *tlsClientFiles) updateModTime(path string) {
+	if info, err := os.Stat(path); err == nil {
+		f.modTimes[path] = info.ModTime()
+	}
+}
+
+func (f *tlsClientFiles) current() (*tls.Certificate, *x509.CertPool) {
+	if err := f.reload(); err != nil {
+		f.log.Warnf("Could not reload TLS files, using previous ones: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.cert, f.pool
+}
+
+func (f *tlsClientFiles) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := f.current()
+	return cert, nil
+}
+
+func (f *tlsClientFiles) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not present a certificate")
+	}
+
+	serverName := f.serverName
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+
+	// empty DNSName would skip hostname verification
+	if serverName == "" {
+		return fmt.Errorf("server name is required to verify server certificate")
+	}
+
+	_, pool := f.current()
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
 }
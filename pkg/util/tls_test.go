@@ -0,0 +1,25 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+)
+
+func TestVerifyConnectionNoCertificates(t *testing.T) {
+	files := &tlsClientFiles{}
+
+	if err := files.verifyConnection(tls.ConnectionState{ServerName: "graylog"}); err == nil {
+		t.Errorf("verifyConnection: expected error without server certificate")
+	}
+}
+
+// tests that hostname verification can't be skipped by missing server name
+func TestVerifyConnectionNoServerName(t *testing.T) {
+	files := &tlsClientFiles{}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+	if err := files.verifyConnection(state); err == nil {
+		t.Errorf("verifyConnection: expected error without server name")
+	}
+}
@@ -0,0 +1,75 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// compressor is gzip or zlib writer, which can be reused by resetting.
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+type compressorKey struct {
+	typ   CompressType
+	level int
+}
+
+// compressorPools holds *sync.Pool of compressors for every type and level
+// in use. Allocating a new compressor per message is expensive, as each
+// one comes with its own window and hash tables.
+var compressorPools sync.Map
+
+func compressorPool(typ CompressType, level int) (*sync.Pool, error) {
+	key := compressorKey{typ: typ, level: level}
+	if pool, ok := compressorPools.Load(key); ok {
+		return pool.(*sync.Pool), nil
+	}
+
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", level)
+	}
+
+	var newCompressor func() interface{}
+	switch typ {
+	case CompressGzip:
+		newCompressor = func() interface{} {
+			zw, _ := gzip.NewWriterLevel(nil, level)
+			return zw
+		}
+	case CompressZlib:
+		newCompressor = func() interface{} {
+			zw, _ := zlib.NewWriterLevel(nil, level)
+			return zw
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression type %d", typ)
+	}
+
+	pool, _ := compressorPools.LoadOrStore(key, &sync.Pool{New: newCompressor})
+	return pool.(*sync.Pool), nil
+}
+
+// compress writes src compressed with the given type and level to dst.
+func compress(typ CompressType, level int, dst *bytes.Buffer, src []byte) error {
+	pool, err := compressorPool(typ, level)
+	if err != nil {
+		return err
+	}
+
+	zw := pool.Get().(compressor)
+	defer pool.Put(zw)
+
+	zw.Reset(dst)
+	if _, err = zw.Write(src); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
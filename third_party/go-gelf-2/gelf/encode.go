@@ -0,0 +1,172 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// writeJSONString writes s as JSON string. Unlike encoding/json it doesn't
+// escape HTML characters, which have no special meaning for GELF.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+
+			buf.WriteString(s[start:i])
+			switch c {
+			case '"', '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[c>>4])
+				buf.WriteByte(hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			buf.WriteString(s[start:i])
+			buf.WriteRune(utf8.RuneError)
+		case r == '\u2028' || r == '\u2029':
+			// valid JSON, but not valid JavaScript
+			buf.WriteString(s[start:i])
+			buf.WriteString(`\u202`)
+			buf.WriteByte(hexDigits[r&0xf])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+
+	buf.WriteString(s[start:])
+	buf.WriteByte('"')
+}
+
+// writeJSONFloat writes f formatted the same way as encoding/json does.
+func writeJSONFloat(buf *bytes.Buffer, f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("json: unsupported value: %s", strconv.FormatFloat(f, 'g', -1, bits))
+	}
+
+	var scratch [64]byte
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+
+	b := strconv.AppendFloat(scratch[:0], f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+
+	buf.Write(b)
+	return nil
+}
+
+// writeJSONValue writes value of Extra field. Types produced by inputs are
+// encoded directly, anything else falls back to encoding/json.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	var scratch [24]byte
+
+	switch v := v.(type) {
+	case string:
+		writeJSONString(buf, v)
+	case float64:
+		return writeJSONFloat(buf, v, 64)
+	case float32:
+		return writeJSONFloat(buf, float64(v), 32)
+	case int64:
+		buf.Write(strconv.AppendInt(scratch[:0], v, 10))
+	case int:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int32:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int16:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int8:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case uint64:
+		buf.Write(strconv.AppendUint(scratch[:0], v, 10))
+	case uint:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint32:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint16:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint8:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case nil:
+		buf.WriteString("null")
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+
+	return nil
+}
+
+// sortedKeys returns keys of Extra in sorted order, so that encoded messages
+// are stable. Insertion sort avoids allocations of sort.Strings for the usual
+// handful of fields.
+func sortedKeys(extra map[string]interface{}, keys []string) []string {
+	for key := range extra {
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 16 {
+		sort.Strings(keys)
+		return keys
+	}
+
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+
+	return keys
+}
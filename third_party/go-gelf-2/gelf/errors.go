@@ -0,0 +1,66 @@
+package gelf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EncodeError is returned when message can't be encoded as JSON, e.g. due to
+// NaN or unsupported type of a field. Invalid UTF-8 isn't an error, such
+// bytes are replaced with U+FFFD.
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("unable to encode message: %v", e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary always returns false, the message will never be encoded.
+func (e *EncodeError) Temporary() bool {
+	return false
+}
+
+// BatchError is returned when batched frames couldn't be written. The batch
+// is discarded, so Messages need to be sent again by the caller. Failure of
+// batch written in the background is returned by the next write together
+// with the message being written.
+type BatchError struct {
+	Messages []*Message
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("unable to write batch of %d messages: %v", len(e.Messages), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary always returns true, messages may be written by another attempt.
+func (e *BatchError) Temporary() bool {
+	return true
+}
+
+// IsPermanent returns whether err is caused by the message itself, so that
+// sending it again is going to fail the same way. Errors of the connection
+// or the server are transient.
+func IsPermanent(err error) bool {
+	var encodeErr *EncodeError
+	if errors.As(err, &encodeErr) {
+		return true
+	}
+
+	var tooLarge *TooLargeError
+	if errors.As(err, &tooLarge) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && !httpErr.Temporary()
+}
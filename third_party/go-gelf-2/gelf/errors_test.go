@@ -0,0 +1,42 @@
+package gelf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestIsPermanent(t *testing.T) {
+	w, err := NewUDPWriter("127.0.0.1:12201", true)
+	if err != nil {
+		t.Errorf("NewUDPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+
+	encodeErr := w.WriteMessage(&Message{Version: "1.1", TimeUnix: math.NaN()})
+	if _, ok := encodeErr.(*EncodeError); !ok {
+		t.Errorf("w.WriteMessage: expected EncodeError, got %v", encodeErr)
+		return
+	}
+
+	cases := []struct {
+		err       error
+		permanent bool
+	}{
+		{encodeErr, true},
+		{fmt.Errorf("wrapped: %w", encodeErr), true},
+		{&TooLargeError{Size: 2, Limit: 1}, true},
+		{&HTTPError{StatusCode: 400}, true},
+		{&HTTPError{StatusCode: 429}, false},
+		{&HTTPError{StatusCode: 503}, false},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, c := range cases {
+		if IsPermanent(c.err) != c.permanent {
+			t.Errorf("IsPermanent(%v): expected %v", c.err, c.permanent)
+		}
+	}
+}
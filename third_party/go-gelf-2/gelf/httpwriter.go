@@ -0,0 +1,160 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// HTTPWriter sends messages to GELF HTTP input, one message per request.
+type HTTPWriter struct {
+	GelfWriter
+	URL      string
+	Client   *http.Client
+	Header   http.Header
+	Compress bool
+	// CompressionLevel is one of the consts from compress/flate
+	CompressionLevel int
+}
+
+// HTTPError is returned when server responds with status other than 2xx.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by Retry-After header, 0 if missing
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected HTTP response: %s", e.Status)
+}
+
+// Temporary returns whether request may succeed if retried, which is the case
+// for server errors, timeouts and rate limiting.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// NewHTTPWriter returns a new HTTPWriter posting messages to url using
+// client. Connections are reused as long as client allows it.
+func NewHTTPWriter(url string, client *http.Client) (*HTTPWriter, error) {
+	var err error
+	w := new(HTTPWriter)
+	w.URL = url
+	w.Client = client
+	w.Header = make(http.Header)
+	w.CompressionLevel = flate.DefaultCompression
+	w.proto = "http"
+	w.addr = url
+
+	if w.Client == nil {
+		w.Client = http.DefaultClient
+	}
+	if w.hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+
+	w.Facility = path.Base(os.Args[0])
+
+	return w, nil
+}
+
+// WriteMessage sends the specified message to the GELF server
+// specified in the call to New().  It assumes all the fields are
+// filled out appropriately.  In general, clients will want to use
+// Write, rather than WriteMessage.
+func (w *HTTPWriter) WriteMessage(m *Message) (err error) {
+	buf := newBuffer()
+	defer bufPool.Put(buf)
+	messageBytes, err := m.toBytes(buf)
+	if err != nil {
+		return err
+	}
+
+	if w.Compress {
+		zBuf := newBuffer()
+		defer bufPool.Put(zBuf)
+
+		if err = compress(CompressGzip, w.CompressionLevel, zBuf, messageBytes); err != nil {
+			return err
+		}
+		messageBytes = zBuf.Bytes()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(messageBytes))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range w.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// body needs to be read fully for the connection to be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	return nil
+}
+
+// parseRetryAfter parses Retry-After header, which is either number of seconds or HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+func (w *HTTPWriter) Write(p []byte) (n int, err error) {
+	file, line := getCallerIgnoringLogMulti(1)
+
+	m := constructMessage(p, w.hostname, w.Facility, file, line)
+
+	if err = w.WriteMessage(m); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close releases idle connections.
+func (w *HTTPWriter) Close() error {
+	w.Client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,52 @@
+package gelf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPWriterRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	w, err := NewHTTPWriter(server.URL, nil)
+	if err != nil {
+		t.Errorf("NewHTTPWriter: %s", err)
+		return
+	}
+
+	var httpErr *HTTPError
+	if err = w.WriteMessage(&Message{Version: "1.1", Short: "m"}); !errors.As(err, &httpErr) {
+		t.Errorf("w.WriteMessage: expected HTTPError, got %v", err)
+		return
+	}
+
+	if httpErr.StatusCode != http.StatusTooManyRequests || httpErr.RetryAfter != 3*time.Second {
+		t.Errorf("w.WriteMessage: expected 429 with 3s delay, got %v %v", httpErr.StatusCode, httpErr.RetryAfter)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+	cases := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"soon":                          0,
+		"Sun, 13 Sep 2020 12:27:40 GMT": time.Minute,
+		"Sun, 13 Sep 2020 12:25:40 GMT": 0,
+	}
+
+	for value, expected := range cases {
+		if delay := parseRetryAfter(value, now); delay != expected {
+			t.Errorf("parseRetryAfter(%q): expected %v, got %v", value, expected, delay)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -33,39 +34,51 @@ const (
 	LOG_DEBUG   = 7
 )
 
+// MarshalJSONBuf writes the message as JSON into buf. Fields are encoded
+// directly rather than through encoding/json, Extra fields are written in
+// sorted order.
 func (m *Message) MarshalJSONBuf(buf *bytes.Buffer) error {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return err
+	buf.WriteString(`{"version":`)
+	writeJSONString(buf, m.Version)
+	buf.WriteString(`,"host":`)
+	writeJSONString(buf, m.Host)
+	buf.WriteString(`,"short_message":`)
+	writeJSONString(buf, m.Short)
+	if m.Full != "" {
+		buf.WriteString(`,"full_message":`)
+		writeJSONString(buf, m.Full)
 	}
-	// write up until the final }
-	if _, err = buf.Write(b[:len(b)-1]); err != nil {
+	buf.WriteString(`,"timestamp":`)
+	if err := writeJSONFloat(buf, m.TimeUnix, 64); err != nil {
 		return err
 	}
+	if m.Level != 0 {
+		var scratch [16]byte
+		buf.WriteString(`,"level":`)
+		buf.Write(strconv.AppendInt(scratch[:0], int64(m.Level), 10))
+	}
+	if m.Facility != "" {
+		buf.WriteString(`,"facility":`)
+		writeJSONString(buf, m.Facility)
+	}
+
 	if len(m.Extra) > 0 {
-		eb, err := json.Marshal(m.Extra)
-		if err != nil {
-			return err
-		}
-		// merge serialized message + serialized extra map
-		if err = buf.WriteByte(','); err != nil {
-			return err
-		}
-		// write serialized extra bytes, without enclosing quotes
-		if _, err = buf.Write(eb[1 : len(eb)-1]); err != nil {
-			return err
+		var keysArr [16]string
+		for _, key := range sortedKeys(m.Extra, keysArr[:0]) {
+			buf.WriteByte(',')
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if err := writeJSONValue(buf, m.Extra[key]); err != nil {
+				return fmt.Errorf("json: field %s: %w", key, err)
+			}
 		}
 	}
 
 	if len(m.RawExtra) > 0 {
-		if err := buf.WriteByte(','); err != nil {
-			return err
-		}
+		buf.WriteByte(',')
 
 		// write serialized extra bytes, without enclosing quotes
-		if _, err = buf.Write(m.RawExtra[1 : len(m.RawExtra)-1]); err != nil {
-			return err
-		}
+		buf.Write(m.RawExtra[1 : len(m.RawExtra)-1])
 	}
 
 	// write final closing quotes
@@ -115,7 +128,7 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 
 func (m *Message) toBytes(buf *bytes.Buffer) (messageBytes []byte, err error) {
 	if err = m.MarshalJSONBuf(buf); err != nil {
-		return nil, err
+		return nil, &EncodeError{Err: err}
 	}
 	messageBytes = buf.Bytes()
 	return messageBytes, nil
@@ -1,7 +1,10 @@
 package gelf
 
 import (
+	"bytes"
 	"encoding/json"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -25,3 +28,179 @@ func TestWrongFieldTypes(t *testing.T) {
 	}
 
 }
+
+// marshalJSONBufStdlib is the previous encoding/json based implementation,
+// used as reference in tests and benchmarks. HTML escaping is disabled to
+// match the output of MarshalJSONBuf.
+func marshalJSONBufStdlib(m *Message, buf *bytes.Buffer) error {
+	encode := func(v interface{}) ([]byte, error) {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+	}
+
+	b, err := encode(m)
+	if err != nil {
+		return err
+	}
+	buf.Write(b[:len(b)-1])
+
+	if len(m.Extra) > 0 {
+		eb, err := encode(m.Extra)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(',')
+		buf.Write(eb[1 : len(eb)-1])
+	}
+
+	if len(m.RawExtra) > 0 {
+		buf.WriteByte(',')
+		buf.Write(m.RawExtra[1 : len(m.RawExtra)-1])
+	}
+
+	return buf.WriteByte('}')
+}
+
+func newBenchmarkMessage() *Message {
+	return &Message{
+		Version:  "1.1",
+		Host:     "web-01.example.com",
+		Short:    "GET /api/v1/users?id=42 HTTP/1.1 200",
+		Full:     "GET /api/v1/users?id=42 HTTP/1.1 200\n\tuser agent: \"curl/7.68.0\"",
+		TimeUnix: 1634567890.123456,
+		Level:    6,
+		Facility: "nginx",
+		Extra: map[string]interface{}{
+			"_remote_addr":     "10.0.0.1",
+			"_request_time":    0.042,
+			"_status":          int64(200),
+			"_bytes_sent":      int64(5123),
+			"_http_user_agent": "curl/7.68.0",
+			"_upstream":        "backend-3:8080",
+			"_geo_lat":         52.2297,
+			"_geo_lon":         21.0122,
+		},
+	}
+}
+
+func TestMarshalJSONBufMatchesStdlib(t *testing.T) {
+	messages := []*Message{
+		newBenchmarkMessage(),
+		{Version: "1.1", Host: "h", Short: "s", TimeUnix: 0},
+		{
+			Version:  "1.1",
+			Host:     "h\"quoted\"",
+			Short:    "tab\tnewline\ncr\rbell\abackslash\\ <html> & \u2028\u2029 zażółć 日本 \U0001F600",
+			Full:     "invalid \xff\xfe utf-8 \x00 nul",
+			TimeUnix: 1e21,
+			Level:    -1,
+			Facility: "f",
+			Extra: map[string]interface{}{
+				"_small":   1e-7,
+				"_large":   1e21,
+				"_neg":     -123.456,
+				"_zero":    0.0,
+				"_float32": float32(3.14),
+				"_int":     42,
+				"_int64":   int64(math.MaxInt64),
+				"_uint64":  uint64(math.MaxUint64),
+				"_int8":    int8(-8),
+				"_bool":    true,
+				"_false":   false,
+				"_nil":     nil,
+				"_map":     map[string]interface{}{"a": []int{1, 2}},
+				"_key\"q":  "value",
+			},
+			RawExtra: []byte(`{"_raw":1}`),
+		},
+	}
+
+	for _, m := range messages {
+		var got, expected bytes.Buffer
+		if err := m.MarshalJSONBuf(&got); err != nil {
+			t.Errorf("MarshalJSONBuf: %s", err)
+			return
+		}
+		if err := marshalJSONBufStdlib(m, &expected); err != nil {
+			t.Errorf("marshalJSONBufStdlib: %s", err)
+			return
+		}
+
+		if got.String() != expected.String() {
+			t.Errorf("MarshalJSONBuf:\nexpected %s\ngot      %s", expected.String(), got.String())
+		}
+
+		if !json.Valid(got.Bytes()) {
+			t.Errorf("MarshalJSONBuf: invalid JSON %s", got.String())
+		}
+	}
+}
+
+func TestMarshalJSONBufStableOrder(t *testing.T) {
+	extra := make(map[string]interface{})
+	for _, key := range strings.Split("_q,_w,_e,_r,_t,_y,_u,_i,_o,_p,_a,_s,_d,_f,_g,_h,_j,_k,_l,_z", ",") {
+		extra[key] = key
+	}
+
+	m := &Message{Version: "1.1", Host: "h", Short: "s", Extra: extra}
+
+	var first bytes.Buffer
+	if err := m.MarshalJSONBuf(&first); err != nil {
+		t.Errorf("MarshalJSONBuf: %s", err)
+		return
+	}
+
+	if !strings.HasPrefix(first.String(), `{"version":"1.1","host":"h","short_message":"s","timestamp":0,"_a":"_a","_d":"_d"`) {
+		t.Errorf("MarshalJSONBuf: unexpected order %s", first.String())
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		m.MarshalJSONBuf(&buf)
+		if buf.String() != first.String() {
+			t.Errorf("MarshalJSONBuf: order changed, %s != %s", buf.String(), first.String())
+			return
+		}
+	}
+}
+
+func TestMarshalJSONBufUnsupported(t *testing.T) {
+	for _, m := range []*Message{
+		{Version: "1.1", TimeUnix: math.NaN()},
+		{Version: "1.1", Extra: map[string]interface{}{"_inf": math.Inf(1)}},
+		{Version: "1.1", Extra: map[string]interface{}{"_chan": make(chan int)}},
+	} {
+		var buf bytes.Buffer
+		if err := m.MarshalJSONBuf(&buf); err == nil {
+			t.Errorf("MarshalJSONBuf: expected error, got %s", buf.String())
+		}
+	}
+}
+
+func BenchmarkMarshalJSONBuf(b *testing.B) {
+	m := newBenchmarkMessage()
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		m.MarshalJSONBuf(buf)
+	}
+}
+
+func BenchmarkMarshalJSONBufStdlib(b *testing.B) {
+	m := newBenchmarkMessage()
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		marshalJSONBufStdlib(m, buf)
+	}
+}
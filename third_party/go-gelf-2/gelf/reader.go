@@ -62,7 +62,7 @@ func (r *Reader) Read(p []byte) (int, error) {
 }
 
 func (r *Reader) ReadMessage() (*Message, error) {
-	cBuf := make([]byte, ChunkSize)
+	cBuf := make([]byte, MaxChunkSize)
 	var (
 		err        error
 		n, length  int
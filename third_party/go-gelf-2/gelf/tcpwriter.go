@@ -1,6 +1,7 @@
 package gelf
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
@@ -9,28 +10,81 @@ import (
 )
 
 const (
-	DefaultMaxReconnect   = 1
-	DefaultReconnectDelay = 1
+	DefaultMaxReconnect      = 1
+	DefaultReconnectDelay    = 100 * time.Millisecond
+	DefaultMaxReconnectDelay = 10 * time.Second
+	DefaultDialTimeout       = 10 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultKeepAlive         = 30 * time.Second
 )
 
 type TCPWriter struct {
 	GelfWriter
-	mu             sync.Mutex
-	MaxReconnect   int
-	ReconnectDelay time.Duration
+	mu sync.Mutex
+	// MaxReconnect is the number of reconnects attempted by a single write
+	MaxReconnect int
+	// ReconnectDelay is the delay after first failed reconnect, doubled
+	// with every following failure up to MaxReconnectDelay
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	DialTimeout       time.Duration
+	WriteTimeout      time.Duration
+	KeepAlive         time.Duration
+	// TLSConfig enables TLS when set, see NewTLSWriter
+	TLSConfig *tls.Config
+	// BatchBytes and BatchMessages enable batching when either is set, frames
+	// are then buffered and written together once batch reaches the limit
+	BatchBytes    int
+	BatchMessages int
+	// BatchLinger is how long frames may wait for the batch to fill, batch is
+	// written only by Flush if zero
+	BatchLinger time.Duration
+	// OnFlushError is called when batch written after BatchLinger fails, its
+	// messages are then returned by the next write, Flush or Close
+	OnFlushError func(err *BatchError)
+
+	dialFailures int
+	nextDial     time.Time
+	// closed is closed when peer closes the current connection
+	closed chan struct{}
+
+	batch    []byte
+	frames   []int // end offsets of frames in batch
+	messages []*Message
+	linger   *time.Timer
+	// failed is batch that failed to be written after BatchLinger
+	failed *BatchError
 }
 
 func NewTCPWriter(addr string) (*TCPWriter, error) {
-	var err error
+	return newTCPWriter(addr, "tcp", nil)
+}
+
+// NewTLSWriter returns TCPWriter sending messages over TLS. Config is used
+// for every connection, so certificates provided through its callbacks
+// (e.g. GetClientCertificate) may change between reconnects.
+func NewTLSWriter(addr string, config *tls.Config) (*TCPWriter, error) {
+	return newTCPWriter(addr, "tls", config)
+}
+
+func newTCPWriter(addr, proto string, config *tls.Config) (*TCPWriter, error) {
 	w := new(TCPWriter)
 	w.MaxReconnect = DefaultMaxReconnect
 	w.ReconnectDelay = DefaultReconnectDelay
-	w.proto = "tcp"
+	w.MaxReconnectDelay = DefaultMaxReconnectDelay
+	w.DialTimeout = DefaultDialTimeout
+	w.WriteTimeout = DefaultWriteTimeout
+	w.KeepAlive = DefaultKeepAlive
+	w.TLSConfig = config
+	w.proto = proto
 	w.addr = addr
 
-	if w.conn, err = net.Dial("tcp", addr); err != nil {
+	conn, err := w.dial()
+	if err != nil {
 		return nil, err
 	}
+	w.setConn(conn)
+
 	if w.hostname, err = os.Hostname(); err != nil {
 		return nil, err
 	}
@@ -52,6 +106,10 @@ func (w *TCPWriter) WriteMessage(m *Message) (err error) {
 
 	messageBytes = append(messageBytes, 0)
 
+	if w.batching() {
+		return w.enqueue(m, messageBytes)
+	}
+
 	n, err := w.writeToSocketWithReconnectAttempts(messageBytes)
 	if err != nil {
 		return err
@@ -75,30 +133,309 @@ func (w *TCPWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// writeToSocketWithReconnectAttempts writes the whole frame to the connection,
+// reconnecting if needed. Connection that failed in the middle of a frame is
+// discarded and the frame is written again from the start on a fresh one, so
+// that the receiver never sees a truncated message followed by another one.
 func (w *TCPWriter) writeToSocketWithReconnectAttempts(zBytes []byte) (n int, err error) {
-	var errConn error
-	var i int
-
 	w.mu.Lock()
-	for i = 0; i <= w.MaxReconnect; i++ {
-		errConn = nil
+	defer w.mu.Unlock()
 
-		if w.conn != nil {
-			n, err = w.conn.Write(zBytes)
-		} else {
-			err = fmt.Errorf("Connection was nil, will attempt reconnect")
+	if err = w.write(zBytes, nil); err != nil {
+		return 0, err
+	}
+
+	return len(zBytes), nil
+}
+
+// write writes b to the connection, reconnecting if needed. After a failed
+// write of n bytes, unsent returns what needs to be written again on a new
+// connection, the whole b if it's nil.
+func (w *TCPWriter) write(b []byte, unsent func(n int) []byte) (err error) {
+	if w.conn != nil && !w.alive() {
+		w.discard()
+	}
+
+	for i := 0; i <= w.MaxReconnect; i++ {
+		if w.conn == nil {
+			if err = w.reconnect(); err != nil {
+				continue
+			}
+		}
+
+		if w.WriteTimeout > 0 {
+			w.conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+		}
+
+		var n int
+		if n, err = w.conn.Write(b); err == nil {
+			return nil
+		}
+
+		if unsent != nil {
+			b = unsent(n)
+		}
+
+		w.discard()
+	}
+
+	return fmt.Errorf("write failed after %d reconnect attempts: %w", w.MaxReconnect, err)
+}
+
+// alive checks whether the peer closed the connection.
+func (w *TCPWriter) alive() bool {
+	select {
+	case <-w.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// setConn starts using conn. GELF servers never send anything, so a read
+// returns only once the connection is closed, which is noticed by the next write
+// instead of losing the message in a half-closed connection.
+func (w *TCPWriter) setConn(conn net.Conn) {
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		var b [64]byte
+		for {
+			if _, err := conn.Read(b[:]); err != nil {
+				return
+			}
 		}
-		if err != nil {
-			// time.Sleep(w.ReconnectDelay * time.Second)
-			w.conn, errConn = net.Dial("tcp", w.addr)
+	}()
+
+	w.conn = conn
+	w.closed = closed
+}
+
+// reconnect dials a new connection, waiting for the backoff after previous failures.
+func (w *TCPWriter) reconnect() error {
+	if wait := time.Until(w.nextDial); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	conn, err := w.dial()
+	if err != nil {
+		delay := w.ReconnectDelay
+		for i := 0; i < w.dialFailures && delay < w.MaxReconnectDelay; i++ {
+			delay *= 2
+		}
+		if delay > w.MaxReconnectDelay {
+			delay = w.MaxReconnectDelay
+		}
+
+		w.dialFailures++
+		w.nextDial = time.Now().Add(delay)
+
+		return err
+	}
+
+	w.setConn(conn)
+	w.dialFailures = 0
+	w.nextDial = time.Time{}
+
+	return nil
+}
+
+// connect makes sure there's a connection which wasn't closed by the peer.
+func (w *TCPWriter) connect() error {
+	if w.conn != nil && !w.alive() {
+		w.discard()
+	}
+
+	if w.conn != nil {
+		return nil
+	}
+
+	if err := w.reconnect(); err != nil {
+		return fmt.Errorf("unable to connect: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes batched frames and closes the current connection, next
+// write reconnects.
+func (w *TCPWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	flushErr := w.takeFailed(w.flush())
+	if w.linger != nil {
+		w.linger.Stop()
+	}
+
+	if w.conn == nil {
+		return flushErr
+	}
+
+	err := w.conn.Close()
+	<-w.closed
+	w.conn = nil
+
+	if flushErr != nil {
+		return flushErr
+	}
+
+	return err
+}
+
+func (w *TCPWriter) discard() {
+	w.conn.Close()
+	<-w.closed
+	w.conn = nil
+}
+
+func (w *TCPWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   w.DialTimeout,
+		KeepAlive: w.KeepAlive,
+	}
+
+	if w.TLSConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", w.addr, w.TLSConfig)
+	}
+
+	return dialer.Dial("tcp", w.addr)
+}
+
+func (w *TCPWriter) batching() bool {
+	return w.BatchBytes > 0 || w.BatchMessages > 0
+}
+
+func (w *TCPWriter) batchFull() bool {
+	return (w.BatchBytes > 0 && len(w.batch) >= w.BatchBytes) ||
+		(w.BatchMessages > 0 && len(w.frames) >= w.BatchMessages)
+}
+
+// enqueue adds frame to the batch, writing the batch once it's full. Batch
+// is discarded if it can't be written and its messages are returned in
+// BatchError, so that callers may send them elsewhere.
+func (w *TCPWriter) enqueue(m *Message, frame []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed != nil {
+		err := w.failed
+		w.failed = nil
+		err.Messages = append(err.Messages, m)
+		return err
+	}
+
+	// frames are accepted only while connected, so that callers notice dead servers
+	if err := w.connect(); err != nil {
+		return err
+	}
+
+	w.batch = append(w.batch, frame...)
+	w.frames = append(w.frames, len(w.batch))
+	w.messages = append(w.messages, m)
+
+	if w.batchFull() {
+		return w.flush()
+	}
+
+	if len(w.frames) == 1 && w.BatchLinger > 0 {
+		if w.linger == nil {
+			w.linger = time.AfterFunc(w.BatchLinger, w.flushLinger)
 		} else {
-			break
+			w.linger.Reset(w.BatchLinger)
 		}
 	}
+
+	return nil
+}
+
+// Flush writes batched frames to the connection.
+func (w *TCPWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.takeFailed(w.flush())
+}
+
+func (w *TCPWriter) flushLinger() {
+	w.mu.Lock()
+	err := w.flush()
+	if err != nil {
+		w.failed, _ = w.takeFailed(err).(*BatchError)
+	}
+	onError := w.OnFlushError
 	w.mu.Unlock()
 
-	if errConn != nil {
-		return 0, fmt.Errorf("Write Failed: %s\nReconnection failed: %s", err, errConn)
+	if err != nil && onError != nil {
+		onError(err.(*BatchError))
 	}
-	return n, nil
+}
+
+// flush writes the batch, error is always BatchError with messages that
+// weren't written.
+func (w *TCPWriter) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+
+	var failed error
+	if err := w.write(w.batch, w.consume); err != nil {
+		failed = &BatchError{
+			Messages: append([]*Message(nil), w.messages...),
+			Err:      err,
+		}
+	}
+
+	for i := range w.messages {
+		w.messages[i] = nil
+	}
+	w.batch = w.batch[:0]
+	w.frames = w.frames[:0]
+	w.messages = w.messages[:0]
+
+	if w.linger != nil {
+		w.linger.Stop()
+	}
+
+	return failed
+}
+
+// takeFailed adds messages of batch that failed after BatchLinger to err.
+func (w *TCPWriter) takeFailed(err error) error {
+	failed := w.failed
+	w.failed = nil
+
+	if failed == nil {
+		return err
+	}
+	if batchErr, ok := err.(*BatchError); ok {
+		failed.Messages = append(failed.Messages, batchErr.Messages...)
+		failed.Err = batchErr.Err
+	}
+
+	return failed
+}
+
+// consume removes frames fully written by the first n bytes of the batch,
+// keeping partially written frame so that it's written again from the start.
+func (w *TCPWriter) consume(n int) []byte {
+	sent := 0
+	for sent < len(w.frames) && w.frames[sent] <= n {
+		sent++
+	}
+	if sent == 0 {
+		return w.batch
+	}
+
+	offset := w.frames[sent-1]
+	w.batch = w.batch[:copy(w.batch, w.batch[offset:])]
+	w.frames = w.frames[:copy(w.frames, w.frames[sent:])]
+	w.messages = w.messages[:copy(w.messages, w.messages[sent:])]
+	for i := range w.frames {
+		w.frames[i] -= offset
+	}
+
+	return w.batch
 }
@@ -1,10 +1,13 @@
 package gelf
 
 import (
+	"bufio"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -30,8 +33,8 @@ func TestNewTCPWriterConfig(t *testing.T) {
 		return
 	}
 
-	if w.MaxReconnect != 3 {
-		t.Errorf("Default MaxReconnect: expected %d, got %d", 3, w.MaxReconnect)
+	if w.MaxReconnect != DefaultMaxReconnect {
+		t.Errorf("Default MaxReconnect: expected %d, got %d", DefaultMaxReconnect, w.MaxReconnect)
 		return
 	}
 	w.MaxReconnect = 5
@@ -40,13 +43,13 @@ func TestNewTCPWriterConfig(t *testing.T) {
 		return
 	}
 
-	if w.ReconnectDelay != 1 {
-		t.Errorf("Default ReconnectDelay: expected %d, got %d", 1, w.ReconnectDelay)
+	if w.ReconnectDelay != DefaultReconnectDelay {
+		t.Errorf("Default ReconnectDelay: expected %v, got %v", DefaultReconnectDelay, w.ReconnectDelay)
 		return
 	}
-	w.ReconnectDelay = 5
-	if w.ReconnectDelay != 5 {
-		t.Errorf("Custom ReconnectDelay: expected %d, got %d", 5, w.ReconnectDelay)
+	w.ReconnectDelay = 5 * time.Second
+	if w.ReconnectDelay != 5*time.Second {
+		t.Errorf("Custom ReconnectDelay: expected %v, got %v", 5*time.Second, w.ReconnectDelay)
 		return
 	}
 }
@@ -63,6 +66,8 @@ func assertMessages(msg *Message, short, full string, t *testing.T) {
 
 }
 
+// Write keeps the whole message in short_message, unlike upstream it doesn't
+// split off the first line
 func TestWriteSmallMultiLineTCP(t *testing.T) {
 	msgData := "awesomesauce\nbananas"
 
@@ -72,7 +77,7 @@ func TestWriteSmallMultiLineTCP(t *testing.T) {
 		return
 	}
 
-	assertMessages(msg, "awesomesauce", msgData, t)
+	assertMessages(msg, msgData, "", t)
 }
 
 func TestWriteSmallOneLineTCP(t *testing.T) {
@@ -87,7 +92,7 @@ func TestWriteSmallOneLineTCP(t *testing.T) {
 
 	assertMessages(msg, msgDataTrunc, "", t)
 
-	fileExpected := "/go-gelf/gelf/tcpwriter_test.go"
+	fileExpected := "/gelf/tcpwriter_test.go"
 	if !strings.HasSuffix(msg.Extra["_file"].(string), fileExpected) {
 		t.Errorf("msg.File: expected %s, got %s", fileExpected,
 			msg.Extra["_file"].(string))
@@ -114,7 +119,7 @@ func TestWriteBigMessageTCP(t *testing.T) {
 		return
 	}
 
-	assertMessages(msg, "awesomesauce", msgData, t)
+	assertMessages(msg, msgData, "", t)
 }
 
 func TestWriteMultiPacketMessageTCP(t *testing.T) {
@@ -131,7 +136,7 @@ func TestWriteMultiPacketMessageTCP(t *testing.T) {
 		return
 	}
 
-	assertMessages(msg, "awesomesauce", msgData, t)
+	assertMessages(msg, msgData, "", t)
 }
 
 func TestExtraDataTCP(t *testing.T) {
@@ -198,8 +203,8 @@ func TestWrite2MessagesWithConnectionDropTCP(t *testing.T) {
 		return
 	}
 
-	assertMessages(msg1, "First message", msgData1, t)
-	assertMessages(msg2, "Second message", msgData2, t)
+	assertMessages(msg1, msgData1, "", t)
+	assertMessages(msg2, msgData2, "", t)
 }
 
 func TestWrite2MessagesWithServerDropTCP(t *testing.T) {
@@ -212,7 +217,7 @@ func TestWrite2MessagesWithServerDropTCP(t *testing.T) {
 		return
 	}
 
-	assertMessages(msg1, "First message", msgData1, t)
+	assertMessages(msg1, msgData1, "", t)
 }
 
 func setupConnections() (*TCPReader, chan string, chan string, *TCPWriter, error) {
@@ -360,3 +365,344 @@ func sendAndRecv2MessagesWithServerDropTCP(msgData1 string, msgData2 string) (*M
 	w.Close()
 	return message1, nil
 }
+
+func TestWriteAfterPeerCloseTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+	defer l.Close()
+
+	w, err := NewTCPWriter(l.Addr().String())
+	if err != nil {
+		t.Errorf("NewTCPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+	conn.Close()
+
+	// give the writer a moment to notice the closed connection
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err = w.Write([]byte("after close")); err != nil {
+		t.Errorf("w.Write: %s", err)
+		return
+	}
+
+	conn, err = l.Accept()
+	if err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil {
+		t.Errorf("ReadBytes: %s", err)
+		return
+	}
+
+	var msg Message
+	if err = json.Unmarshal(b[:len(b)-1], &msg); err != nil {
+		t.Errorf("json.Unmarshal: %s", err)
+		return
+	}
+	if msg.Short != "after close" {
+		t.Errorf("msg.Short: expected %s, got %s", "after close", msg.Short)
+	}
+}
+
+func TestReconnectBackoffTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+
+	w, err := NewTCPWriter(l.Addr().String())
+	if err != nil {
+		t.Errorf("NewTCPWriter: %s", err)
+		return
+	}
+	w.MaxReconnect = 0
+	w.ReconnectDelay = 50 * time.Millisecond
+	w.MaxReconnectDelay = 200 * time.Millisecond
+
+	l.Close()
+	w.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err = w.Write([]byte("lost")); err == nil {
+			t.Errorf("w.Write: expected error with server down")
+			return
+		}
+	}
+
+	if w.dialFailures != 5 {
+		t.Errorf("dialFailures: expected %d, got %d", 5, w.dialFailures)
+	}
+
+	start := time.Now()
+	w.Write([]byte("lost"))
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("reconnect delay: expected at least %v, got %v", w.MaxReconnectDelay, elapsed)
+	}
+}
+
+func readFramesTCP(conn net.Conn, count int) ([]string, error) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var shorts []string
+	for len(shorts) < count {
+		b, err := reader.ReadBytes(0)
+		if err != nil {
+			return shorts, err
+		}
+
+		var msg Message
+		if err = json.Unmarshal(b[:len(b)-1], &msg); err != nil {
+			return shorts, err
+		}
+		shorts = append(shorts, msg.Short)
+	}
+
+	return shorts, nil
+}
+
+func TestBatchTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+	defer l.Close()
+
+	w, err := NewTCPWriter(l.Addr().String())
+	if err != nil {
+		t.Errorf("NewTCPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+	w.BatchMessages = 3
+	w.BatchLinger = 100 * time.Millisecond
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, short := range []string{"one", "two", "three", "four"} {
+		if _, err = w.Write([]byte(short)); err != nil {
+			t.Errorf("w.Write: %s", err)
+			return
+		}
+	}
+
+	w.mu.Lock()
+	left := len(w.frames)
+	w.mu.Unlock()
+
+	if left != 1 {
+		t.Errorf("batch: expected %d frame left after flush, got %d", 1, left)
+		return
+	}
+
+	// last frame is written by linger timer
+	shorts, err := readFramesTCP(conn, 4)
+	if err != nil {
+		t.Errorf("readFramesTCP: %s", err)
+		return
+	}
+
+	if strings.Join(shorts, ",") != "one,two,three,four" {
+		t.Errorf("frames: expected %s, got %s", "one,two,three,four", strings.Join(shorts, ","))
+	}
+}
+
+func TestBatchFailureTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+	addr := l.Addr().String()
+
+	w, err := NewTCPWriter(addr)
+	if err != nil {
+		t.Errorf("NewTCPWriter: %s", err)
+		return
+	}
+	w.BatchMessages = 10
+	w.ReconnectDelay = 0
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+
+	for _, short := range []string{"one", "two"} {
+		if _, err = w.Write([]byte(short)); err != nil {
+			t.Errorf("w.Write: %s", err)
+			return
+		}
+	}
+
+	// server is gone before the batch is written
+	conn.Close()
+	l.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	err = w.Flush()
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Errorf("w.Flush: expected BatchError, got %v", err)
+		return
+	}
+
+	if len(batchErr.Messages) != 2 || batchErr.Messages[0].Short != "one" || batchErr.Messages[1].Short != "two" {
+		t.Errorf("BatchError: unexpected messages %v", batchErr.Messages)
+		return
+	}
+
+	if len(w.batch) != 0 || len(w.frames) != 0 || len(w.messages) != 0 {
+		t.Errorf("batch: expected failed batch to be discarded")
+		return
+	}
+
+	// frames aren't accepted while the server is down
+	if _, err = w.Write([]byte("three")); err == nil {
+		t.Errorf("w.Write: expected error while disconnected")
+		return
+	}
+
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+	defer l.Close()
+
+	w.Write([]byte("four"))
+	w.Write([]byte("five"))
+
+	if err = w.Flush(); err != nil {
+		t.Errorf("w.Flush: %s", err)
+		return
+	}
+
+	if conn, err = l.Accept(); err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	shorts, err := readFramesTCP(conn, 2)
+	if err != nil {
+		t.Errorf("readFramesTCP: %s", err)
+		return
+	}
+
+	if strings.Join(shorts, ",") != "four,five" {
+		t.Errorf("frames: expected %s, got %s", "four,five", strings.Join(shorts, ","))
+	}
+}
+
+func TestBatchLingerFailureTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("net.Listen: %s", err)
+		return
+	}
+
+	w, err := NewTCPWriter(l.Addr().String())
+	if err != nil {
+		t.Errorf("NewTCPWriter: %s", err)
+		return
+	}
+	w.BatchMessages = 10
+	w.BatchLinger = 200 * time.Millisecond
+	w.ReconnectDelay = 0
+
+	failures := make(chan *BatchError, 1)
+	w.OnFlushError = func(err *BatchError) {
+		failures <- err
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Errorf("l.Accept: %s", err)
+		return
+	}
+
+	if _, err = w.Write([]byte("one")); err != nil {
+		t.Errorf("w.Write: %s", err)
+		return
+	}
+
+	// server is gone before the batch is written by linger timer
+	conn.Close()
+	l.Close()
+
+	select {
+	case err := <-failures:
+		if len(err.Messages) != 1 {
+			t.Errorf("OnFlushError: expected 1 message, got %d", len(err.Messages))
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("OnFlushError: not called")
+		return
+	}
+
+	// failed message is returned by the next write together with the written one
+	_, err = w.Write([]byte("two"))
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Errorf("w.Write: expected BatchError, got %v", err)
+		return
+	}
+
+	if len(batchErr.Messages) != 2 || batchErr.Messages[0].Short != "one" || batchErr.Messages[1].Short != "two" {
+		t.Errorf("BatchError: unexpected messages %v", batchErr.Messages)
+		return
+	}
+
+	if err = w.Close(); err != nil {
+		t.Errorf("w.Close: %s", err)
+	}
+}
+
+func TestBatchConsume(t *testing.T) {
+	w := &TCPWriter{}
+	w.batch = []byte("one\x00two\x00three\x00")
+	w.frames = []int{4, 8, 14}
+	w.messages = []*Message{{Short: "one"}, {Short: "two"}, {Short: "three"}}
+
+	// second frame was written partially
+	if rest := string(w.consume(6)); rest != "two\x00three\x00" {
+		t.Errorf("consume: expected %q, got %q", "two\x00three\x00", rest)
+		return
+	}
+
+	if len(w.frames) != 2 || w.frames[0] != 4 || w.frames[1] != 10 {
+		t.Errorf("consume: unexpected frame offsets %v", w.frames)
+		return
+	}
+
+	if len(w.messages) != 2 || w.messages[0].Short != "two" {
+		t.Errorf("consume: unexpected messages %v", w.messages)
+	}
+}
@@ -7,8 +7,6 @@ package gelf
 import (
 	"bytes"
 	"compress/flate"
-	"compress/gzip"
-	"compress/zlib"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -22,6 +20,10 @@ type UDPWriter struct {
 	GelfWriter
 	CompressionLevel int // one of the consts from compress/flate
 	CompressionType  CompressType
+	// CompressionThreshold is the size of message below which it's sent uncompressed
+	CompressionThreshold int
+	// ChunkSize is the maximum size of a single datagram, ChunkSize if zero
+	ChunkSize int
 }
 
 // What compression type the writer should use when sending messages
@@ -41,23 +43,46 @@ const (
 const (
 	ChunkSize        = 1420
 	chunkedHeaderLen = 12
-	chunkedDataLen   = ChunkSize - chunkedHeaderLen
+	// MinChunkSize and MaxChunkSize are limits of UDPWriter.ChunkSize, the
+	// latter being the largest UDP payload
+	MinChunkSize = chunkedHeaderLen + 1
+	MaxChunkSize = 65507
+	// MaxChunks is the maximum number of chunks of a message allowed by GELF
+	MaxChunks = 128
 )
 
+// TooLargeError is returned when compressed message needs more than
+// MaxChunks chunks. Retrying won't help unless the message is made smaller.
+type TooLargeError struct {
+	// Size is the size of compressed message
+	Size int
+	// Limit is the maximum size of compressed message
+	Limit int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("msg too large, %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+// Temporary always returns false, sending the same message again will fail too.
+func (e *TooLargeError) Temporary() bool {
+	return false
+}
+
 var (
 	magicChunked = []byte{0x1e, 0x0f}
 	magicZlib    = []byte{0x78}
 	magicGzip    = []byte{0x1f, 0x8b}
 )
 
-// numChunks returns the number of GELF chunks necessary to transmit
-// the given compressed buffer.
-func numChunks(b []byte) int {
+// numChunks returns the number of GELF chunks of chunkSize necessary to
+// transmit the given compressed buffer.
+func numChunks(b []byte, chunkSize int) int {
 	lenB := len(b)
-	if lenB <= ChunkSize {
+	if lenB <= chunkSize {
 		return 1
 	}
-	return len(b)/chunkedDataLen + 1
+	return (lenB + chunkSize - chunkedHeaderLen - 1) / (chunkSize - chunkedHeaderLen)
 }
 
 // New returns a new GELF Writer.  This writer can be used to send the
@@ -66,6 +91,7 @@ func numChunks(b []byte) int {
 func NewUDPWriter(addr string, compression bool) (*UDPWriter, error) {
 	var err error
 	w := new(UDPWriter)
+	w.ChunkSize = ChunkSize
 
 	if compression {
 		w.CompressionLevel = flate.BestSpeed
@@ -86,17 +112,18 @@ func NewUDPWriter(addr string, compression bool) (*UDPWriter, error) {
 }
 
 // writes the gzip compressed byte array to the connection as a series
-// of GELF chunked messages.  The format is documented at
-// http://docs.graylog.org/en/2.1/pages/gelf.html as:
+// of GELF chunked messages of at most chunkSize bytes.  The format is
+// documented at http://docs.graylog.org/en/2.1/pages/gelf.html as:
 //
 //     2-byte magic (0x1e 0x0f), 8 byte id, 1 byte sequence id, 1 byte
 //     total, chunk-data
-func (w *GelfWriter) writeChunked(zBytes []byte) (err error) {
-	b := make([]byte, 0, ChunkSize)
+func (w *GelfWriter) writeChunked(zBytes []byte, chunkSize int) (err error) {
+	chunkedDataLen := chunkSize - chunkedHeaderLen
+	b := make([]byte, 0, chunkSize)
 	buf := bytes.NewBuffer(b)
-	nChunksI := numChunks(zBytes)
-	if nChunksI > 128 {
-		return fmt.Errorf("msg too large, would need %d chunks", nChunksI)
+	nChunksI := numChunks(zBytes, chunkSize)
+	if nChunksI > MaxChunks {
+		return &TooLargeError{Size: len(zBytes), Limit: MaxChunks * chunkedDataLen}
 	}
 	nChunks := uint8(nChunksI)
 	// use urandom to get a unique message id
@@ -169,7 +196,7 @@ func (w *UDPWriter) WriteMessage(m *Message) (err error) {
 	mBuf := newBuffer()
 	defer bufPool.Put(mBuf)
 	if err = m.MarshalJSONBuf(mBuf); err != nil {
-		return err
+		return &EncodeError{Err: err}
 	}
 	mBytes := mBuf.Bytes()
 
@@ -178,36 +205,37 @@ func (w *UDPWriter) WriteMessage(m *Message) (err error) {
 		zBytes []byte
 	)
 
-	var zw io.WriteCloser
-	switch w.CompressionType {
-	case CompressGzip:
-		zBuf = newBuffer()
-		defer bufPool.Put(zBuf)
-		zw, err = gzip.NewWriterLevel(zBuf, w.CompressionLevel)
-	case CompressZlib:
+	compressionType := w.CompressionType
+	if len(mBytes) < w.CompressionThreshold {
+		compressionType = CompressNone
+	}
+
+	switch compressionType {
+	case CompressGzip, CompressZlib:
 		zBuf = newBuffer()
 		defer bufPool.Put(zBuf)
-		zw, err = zlib.NewWriterLevel(zBuf, w.CompressionLevel)
+		if err = compress(compressionType, w.CompressionLevel, zBuf, mBytes); err != nil {
+			return
+		}
+		zBytes = zBuf.Bytes()
 	case CompressNone:
 		zBytes = mBytes
 	default:
 		panic(fmt.Sprintf("unknown compression type %d",
 			w.CompressionType))
 	}
-	if zw != nil {
-		if err != nil {
-			return
-		}
-		if _, err = zw.Write(mBytes); err != nil {
-			zw.Close()
-			return
-		}
-		zw.Close()
-		zBytes = zBuf.Bytes()
+
+	chunkSize := w.ChunkSize
+	if chunkSize == 0 {
+		// writers not created by NewUDPWriter
+		chunkSize = ChunkSize
+	}
+	if chunkSize < MinChunkSize || chunkSize > MaxChunkSize {
+		return fmt.Errorf("invalid chunk size %d, must be between %d and %d", chunkSize, MinChunkSize, MaxChunkSize)
 	}
 
-	if numChunks(zBytes) > 1 {
-		return w.writeChunked(zBytes)
+	if numChunks(zBytes, chunkSize) > 1 {
+		return w.writeChunked(zBytes, chunkSize)
 	}
 	n, err := w.conn.Write(zBytes)
 	if err != nil {
@@ -18,7 +18,7 @@ import (
 )
 
 func TestNewUDPWriter(t *testing.T) {
-	w, err := NewUDPWriter("")
+	w, err := NewUDPWriter("", true)
 	if err == nil || w != nil {
 		t.Errorf("New didn't fail")
 		return
@@ -31,7 +31,7 @@ func sendAndRecv(msgData string, compress CompressType) (*Message, error) {
 		return nil, fmt.Errorf("NewReader: %s", err)
 	}
 
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		return nil, fmt.Errorf("NewUDPWriter: %s", err)
 	}
@@ -51,7 +51,7 @@ func sendAndRecvMsg(msg *Message, compress CompressType) (*Message, error) {
 		return nil, fmt.Errorf("NewReader: %s", err)
 	}
 
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		return nil, fmt.Errorf("NewUDPWriter: %s", err)
 	}
@@ -65,8 +65,8 @@ func sendAndRecvMsg(msg *Message, compress CompressType) (*Message, error) {
 	return r.ReadMessage()
 }
 
-// tests single-message (non-chunked) messages that are split over
-// multiple lines
+// tests single-message (non-chunked) messages spanning multiple lines, which
+// are kept whole in short_message
 func TestWriteSmallMultiLine(t *testing.T) {
 	for _, i := range []CompressType{CompressGzip, CompressZlib, CompressNone} {
 		msgData := "awesomesauce\nbananas"
@@ -77,13 +77,13 @@ func TestWriteSmallMultiLine(t *testing.T) {
 			return
 		}
 
-		if msg.Short != "awesomesauce" {
-			t.Errorf("msg.Short: expected %s, got %s", "awesomesauce", msg.Full)
+		if msg.Short != msgData {
+			t.Errorf("msg.Short: expected %s, got %s", msgData, msg.Short)
 			return
 		}
 
-		if msg.Full != msgData {
-			t.Errorf("msg.Full: expected %s, got %s", msgData, msg.Full)
+		if msg.Full != "" {
+			t.Errorf("msg.Full: expected empty, got %s", msg.Full)
 			return
 		}
 	}
@@ -112,7 +112,7 @@ func TestWriteSmallOneLine(t *testing.T) {
 		return
 	}
 
-	fileExpected := "/go-gelf/gelf/udpwriter_test.go"
+	fileExpected := "/gelf/udpwriter_test.go"
 	if !strings.HasSuffix(msg.Extra["_file"].(string), fileExpected) {
 		t.Errorf("msg.File: expected %s, got %s", fileExpected,
 			msg.Extra["_file"].(string))
@@ -159,18 +159,89 @@ func TestWriteBigChunked(t *testing.T) {
 			return
 		}
 
-		if msg.Short != "awesomesauce" {
-			t.Errorf("msg.Short: expected %s, got %s", msgData, msg.Full)
+		if msg.Short != msgData {
+			t.Errorf("msg.Short: expected %s, got %s", msgData, msg.Short)
 			return
 		}
 
-		if msg.Full != msgData {
-			t.Errorf("msg.Full: expected %s, got %s", msgData, msg.Full)
+		if msg.Full != "" {
+			t.Errorf("msg.Full: expected empty, got %s", msg.Full)
 			return
 		}
 	}
 }
 
+// tests chunked messages with custom chunk size, both smaller and larger than
+// default, zero meaning the default
+func TestWriteChunkSize(t *testing.T) {
+	randData := make([]byte, 8192)
+	if _, err := rand.Read(randData); err != nil {
+		t.Errorf("cannot get random data: %s", err)
+		return
+	}
+	msgData := base64.StdEncoding.EncodeToString(randData)
+
+	for _, size := range []int{0, 512, 8192} {
+		r, err := NewReader("127.0.0.1:0")
+		if err != nil {
+			t.Errorf("NewReader: %s", err)
+			return
+		}
+
+		w, err := NewUDPWriter(r.Addr(), false)
+		if err != nil {
+			t.Errorf("NewUDPWriter: %s", err)
+			return
+		}
+		w.ChunkSize = size
+
+		if err = w.WriteMessage(&Message{Version: "1.1", Host: "fake-host", Short: msgData}); err != nil {
+			t.Errorf("w.WriteMessage: %s", err)
+			return
+		}
+		w.Close()
+
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Errorf("r.ReadMessage: %s", err)
+			return
+		}
+
+		if msg.Short != msgData {
+			t.Errorf("msg.Short: chunk size %d didn't roundtrip", size)
+			return
+		}
+	}
+}
+
+func TestWriteTooLarge(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Errorf("NewReader: %s", err)
+		return
+	}
+
+	w, err := NewUDPWriter(r.Addr(), false)
+	if err != nil {
+		t.Errorf("NewUDPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+	w.ChunkSize = MinChunkSize
+
+	err = w.WriteMessage(&Message{Version: "1.1", Host: "fake-host", Short: strings.Repeat("a", 1024)})
+
+	tooLarge, ok := err.(*TooLargeError)
+	if !ok {
+		t.Errorf("w.WriteMessage: expected TooLargeError, got %v", err)
+		return
+	}
+
+	if tooLarge.Limit != MaxChunks || tooLarge.Temporary() {
+		t.Errorf("unexpected error: %v", tooLarge)
+	}
+}
+
 // tests messages with extra data
 func TestExtraData(t *testing.T) {
 
@@ -242,7 +313,7 @@ func BenchmarkWriteBestSpeed(b *testing.B) {
 		b.Fatalf("NewReader: %s", err)
 	}
 	go io.Copy(ioutil.Discard, r)
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		b.Fatalf("NewUDPWriter: %s", err)
 	}
@@ -268,7 +339,7 @@ func BenchmarkWriteNoCompression(b *testing.B) {
 		b.Fatalf("NewReader: %s", err)
 	}
 	go io.Copy(ioutil.Discard, r)
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		b.Fatalf("NewUDPWriter: %s", err)
 	}
@@ -294,7 +365,7 @@ func BenchmarkWriteDisableCompressionCompletely(b *testing.B) {
 		b.Fatalf("NewReader: %s", err)
 	}
 	go io.Copy(ioutil.Discard, r)
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		b.Fatalf("NewUDPWriter: %s", err)
 	}
@@ -320,7 +391,7 @@ func BenchmarkWriteDisableCompressionAndPreencodeExtra(b *testing.B) {
 		b.Fatalf("NewReader: %s", err)
 	}
 	go io.Copy(ioutil.Discard, r)
-	w, err := NewUDPWriter(r.Addr())
+	w, err := NewUDPWriter(r.Addr(), true)
 	if err != nil {
 		b.Fatalf("NewUDPWriter: %s", err)
 	}
@@ -339,3 +410,81 @@ func BenchmarkWriteDisableCompressionAndPreencodeExtra(b *testing.B) {
 		})
 	}
 }
+
+func TestCompressionThreshold(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Errorf("NewReader: %s", err)
+		return
+	}
+
+	w, err := NewUDPWriter(r.Addr(), true)
+	if err != nil {
+		t.Errorf("NewUDPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+	w.CompressionType = CompressZlib
+	w.CompressionThreshold = 1024
+
+	for _, short := range []string{"small", strings.Repeat("large", 1024)} {
+		if err = w.WriteMessage(&Message{Version: "1.1", Host: "fake-host", Short: short}); err != nil {
+			t.Errorf("w.WriteMessage: %s", err)
+			return
+		}
+
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Errorf("r.ReadMessage: %s", err)
+			return
+		}
+
+		if msg.Short != short {
+			t.Errorf("msg.Short: expected %d bytes, got %d", len(short), len(msg.Short))
+			return
+		}
+	}
+}
+
+func TestInvalidCompressionLevel(t *testing.T) {
+	w, err := NewUDPWriter("127.0.0.1:12201", true)
+	if err != nil {
+		t.Errorf("NewUDPWriter: %s", err)
+		return
+	}
+	defer w.Close()
+	w.CompressionLevel = flate.BestCompression + 1
+
+	if err = w.WriteMessage(&Message{Version: "1.1", Host: "fake-host", Short: "short"}); err == nil {
+		t.Errorf("w.WriteMessage: expected error for invalid level")
+	}
+}
+
+func BenchmarkWriteZlib(b *testing.B) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("NewReader: %s", err)
+	}
+	go io.Copy(ioutil.Discard, r)
+	w, err := NewUDPWriter(r.Addr(), true)
+	if err != nil {
+		b.Fatalf("NewUDPWriter: %s", err)
+	}
+	w.CompressionType = CompressZlib
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.WriteMessage(&Message{
+			Version:  "1.1",
+			Host:     w.hostname,
+			Short:    "short message",
+			Full:     "full message",
+			TimeUnix: float64(time.Now().UnixNano()) / float64(time.Second),
+			Level:    6, // info
+			Facility: w.Facility,
+			Extra:    map[string]interface{}{"_file": "1234", "_line": "3456"},
+		})
+	}
+
+	w.Close()
+}